@@ -9,3 +9,5 @@ require (
 	github.com/google/uuid v1.6.0
 	golang.org/x/crypto v0.39.0
 )
+
+require golang.org/x/sys v0.33.0 // indirect
@@ -0,0 +1,273 @@
+// go-swift/goswift/apikey.go
+package goswift
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrAPIKeyInvalid is returned for malformed, unknown and expired API keys.
+var ErrAPIKeyInvalid = errors.New("API key is invalid or expired")
+
+// APIKey is the stored form of an API key. The key itself is only shown once, at creation;
+// afterwards it is identified by ID (the public part of the key) and verified against Hash.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Hash       string     `json:"-"` // SHA-256 of the full key
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // Nil for keys that never expire
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Expired reports whether the key has expired at t.
+func (k *APIKey) Expired(t time.Time) bool {
+	return k.ExpiresAt != nil && t.After(*k.ExpiresAt)
+}
+
+// APIKeyStore persists API keys.
+type APIKeyStore interface {
+	// Save stores a new key.
+	Save(key *APIKey) error
+	// Get returns the key with the given ID, or nil if it is unknown.
+	Get(id string) (*APIKey, error)
+	// ListByUser returns the keys belonging to a user, oldest first.
+	ListByUser(userID string) ([]*APIKey, error)
+	// Delete removes a key. Deleting an unknown key is not an error.
+	Delete(id string) error
+	// Touch records that the key was used at t.
+	Touch(id string, t time.Time) error
+}
+
+// APIKeyService creates and verifies API keys of the form "<prefix>_<id>_<secret>".
+// The prefix makes keys recognizable (e.g. to secret scanners) and is not secret.
+type APIKeyService struct {
+	Store  APIKeyStore
+	Prefix string
+}
+
+// NewAPIKeyService creates a service issuing keys with the given prefix (e.g. "qd").
+// A nil store uses a MemoryAPIKeyStore.
+func NewAPIKeyService(store APIKeyStore, prefix string) (*APIKeyService, error) {
+	if prefix == "" || strings.Contains(prefix, "_") {
+		return nil, errors.New("API key prefix must be non-empty and must not contain '_'")
+	}
+	if store == nil {
+		store = NewMemoryAPIKeyStore()
+	}
+	return &APIKeyService{Store: store, Prefix: prefix}, nil
+}
+
+// Create issues a new key for the user. A zero ttl creates a key that never expires.
+// The returned plaintext key must be shown to the user now; it cannot be recovered later.
+func (s *APIKeyService) Create(userID, name string, scopes []string, ttl time.Duration) (string, *APIKey, error) {
+	idBytes := make([]byte, 8)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	id := recoveryCodeEncoding.EncodeToString(idBytes)
+	plaintext := s.Prefix + "_" + id + "_" + recoveryCodeEncoding.EncodeToString(secret)
+
+	now := time.Now()
+	key := &APIKey{
+		ID:        id,
+		Hash:      hashAPIKey(plaintext),
+		UserID:    userID,
+		Name:      name,
+		Scopes:    append([]string(nil), scopes...),
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		key.ExpiresAt = &expiresAt
+	}
+	if err := s.Store.Save(key); err != nil {
+		return "", nil, fmt.Errorf("failed to store API key: %w", err)
+	}
+	return plaintext, key, nil
+}
+
+// Authenticate verifies a plaintext key and records its use.
+func (s *APIKeyService) Authenticate(plaintext string) (*APIKey, error) {
+	parts := strings.Split(plaintext, "_")
+	if len(parts) != 3 || parts[0] != s.Prefix {
+		return nil, ErrAPIKeyInvalid
+	}
+	key, err := s.Store.Get(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API key: %w", err)
+	}
+	if key == nil {
+		return nil, ErrAPIKeyInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(plaintext)), []byte(key.Hash)) != 1 {
+		return nil, ErrAPIKeyInvalid
+	}
+	now := time.Now()
+	if key.Expired(now) {
+		return nil, ErrAPIKeyInvalid
+	}
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > time.Minute { // Don't write to the store on every request
+		if err := s.Store.Touch(key.ID, now); err != nil {
+			return nil, fmt.Errorf("failed to record API key use: %w", err)
+		}
+		key.LastUsedAt = &now
+	}
+	return key, nil
+}
+
+// List returns the user's keys.
+func (s *APIKeyService) List(userID string) ([]*APIKey, error) {
+	return s.Store.ListByUser(userID)
+}
+
+// Revoke deletes one of the user's keys. It reports false if the user has no key with that ID.
+func (s *APIKeyService) Revoke(userID, id string) (bool, error) {
+	key, err := s.Store.Get(id)
+	if err != nil {
+		return false, err
+	}
+	if key == nil || key.UserID != userID {
+		return false, nil
+	}
+	return true, s.Store.Delete(id)
+}
+
+// hashAPIKey hashes a key for storage. Keys are random, so a fast hash is sufficient.
+func hashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyAuth authenticates requests with an API key and sets "userID", "apiKey" (*APIKey)
+// and "authMethod" ("apikey") in the context; the key's scopes are available through c.Subject().
+// lookups work as in JWTAuthMiddleware and default to "header:X-API-Key".
+func APIKeyAuth(service *APIKeyService, lookups ...string) MiddlewareFunc {
+	if len(lookups) == 0 {
+		lookups = []string{"header:X-API-Key"}
+	}
+	extractors := make([]tokenExtractor, len(lookups))
+	for i, lookup := range lookups {
+		extractor, err := newTokenExtractor(lookup)
+		if err != nil {
+			panic(fmt.Sprintf("APIKeyAuth: %v", err))
+		}
+		extractors[i] = extractor
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			var plaintext string
+			for _, extract := range extractors {
+				value, err := extract(c)
+				if err != nil {
+					return err
+				}
+				if value != "" {
+					plaintext = value
+					break
+				}
+			}
+			if plaintext == "" {
+				return NewHTTPError(http.StatusUnauthorized, "API key required", ErrNoCredentials)
+			}
+
+			key, err := service.Authenticate(plaintext)
+			if err != nil {
+				if !errors.Is(err, ErrAPIKeyInvalid) {
+					return NewHTTPError(http.StatusInternalServerError, "Internal Server Error", err)
+				}
+				c.engine.Logger.Warning("API key authentication failed from %s", c.RealIP())
+				return NewHTTPError(http.StatusUnauthorized, "Invalid or expired API key")
+			}
+
+			c.Set("userID", key.UserID)
+			c.Set("apiKey", key)
+			c.Set("authMethod", "apikey")
+			return next(c)
+		}
+	}
+}
+
+// MemoryAPIKeyStore is an in-memory APIKeyStore.
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]APIKey // map[id]APIKey
+}
+
+// NewMemoryAPIKeyStore creates an empty in-memory API key store.
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{
+		keys: make(map[string]APIKey),
+	}
+}
+
+// Save implements APIKeyStore.
+func (s *MemoryAPIKeyStore) Save(key *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *key
+	copied.Scopes = append([]string(nil), key.Scopes...)
+	s.keys[key.ID] = copied
+	return nil
+}
+
+// Get implements APIKeyStore.
+func (s *MemoryAPIKeyStore) Get(id string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, nil
+	}
+	return &key, nil
+}
+
+// ListByUser implements APIKeyStore.
+func (s *MemoryAPIKeyStore) ListByUser(userID string) ([]*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := []*APIKey{}
+	for _, key := range s.keys {
+		if key.UserID == userID {
+			copied := key
+			keys = append(keys, &copied)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// Delete implements APIKeyStore.
+func (s *MemoryAPIKeyStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, id)
+	return nil
+}
+
+// Touch implements APIKeyStore.
+func (s *MemoryAPIKeyStore) Touch(id string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.keys[id]; ok {
+		key.LastUsedAt = &t
+		s.keys[id] = key
+	}
+	return nil
+}
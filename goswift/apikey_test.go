@@ -0,0 +1,198 @@
+// go-swift/goswift/apikey_test.go
+package goswift
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingAPIKeyStore fails every lookup, standing in for an unreachable database.
+type failingAPIKeyStore struct {
+	*MemoryAPIKeyStore
+}
+
+func (s failingAPIKeyStore) Get(id string) (*APIKey, error) {
+	return nil, errors.New("database down")
+}
+
+// testAPIKeyService returns a service issuing "qd" keys from an in-memory store.
+func testAPIKeyService(t *testing.T) *APIKeyService {
+	t.Helper()
+	s, err := NewAPIKeyService(nil, "qd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// apiKeyStatus sends GET /r to app with the given X-API-Key header (none if empty).
+func apiKeyStatus(app *Engine, key string) int {
+	req := httptest.NewRequest(http.MethodGet, "/r", nil)
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestNewAPIKeyServicePrefix(t *testing.T) {
+	for _, prefix := range []string{"", "q_d"} {
+		if _, err := NewAPIKeyService(nil, prefix); err == nil {
+			t.Errorf("prefix %q accepted", prefix)
+		}
+	}
+}
+
+// Only the exact key authenticates; anything malformed, tampered with or unknown is ErrAPIKeyInvalid.
+func TestAPIKeyAuthenticate(t *testing.T) {
+	s := testAPIKeyService(t)
+	plaintext, created, err := s.Create("user-1", "CI", []string{"docs:read"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(plaintext, "qd_"+created.ID+"_") {
+		t.Fatalf("key %q does not have the form qd_<id>_<secret>", plaintext)
+	}
+	if created.Hash == "" || strings.Contains(created.Hash, plaintext) {
+		t.Fatalf("stored hash %q", created.Hash)
+	}
+
+	key, err := s.Authenticate(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.UserID != "user-1" || key.LastUsedAt == nil {
+		t.Fatalf("Authenticate = %+v", key)
+	}
+	if stored, _ := s.Store.Get(created.ID); stored.LastUsedAt == nil {
+		t.Fatal("use not recorded in the store")
+	}
+
+	secret := strings.TrimPrefix(plaintext, "qd_"+created.ID+"_")
+	tampered := []byte(secret)
+	tampered[0] ^= 1
+	invalid := map[string]string{
+		"empty":           "",
+		"wrong prefix":    "xx_" + created.ID + "_" + secret,
+		"missing secret":  "qd_" + created.ID,
+		"extra part":      plaintext + "_extra",
+		"tampered secret": "qd_" + created.ID + "_" + string(tampered),
+		"unknown ID":      "qd_AAAAAAAAAAAAAA_" + secret,
+	}
+	for name, candidate := range invalid {
+		if _, err := s.Authenticate(candidate); err != ErrAPIKeyInvalid {
+			t.Errorf("%s: got %v, want ErrAPIKeyInvalid", name, err)
+		}
+	}
+}
+
+func TestAPIKeyExpiry(t *testing.T) {
+	s := testAPIKeyService(t)
+	plaintext, created, err := s.Create("user-1", "short-lived", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ExpiresAt == nil || created.Expired(time.Now()) || !created.Expired(time.Now().Add(2*time.Hour)) {
+		t.Fatalf("ExpiresAt = %v", created.ExpiresAt)
+	}
+	if _, err := s.Authenticate(plaintext); err != nil {
+		t.Fatal(err)
+	}
+
+	expired := *created
+	past := time.Now().Add(-time.Second)
+	expired.ExpiresAt = &past
+	s.Store.Save(&expired)
+	if _, err := s.Authenticate(plaintext); err != ErrAPIKeyInvalid {
+		t.Fatalf("expired key: got %v, want ErrAPIKeyInvalid", err)
+	}
+
+	_, forever, err := s.Create("user-1", "forever", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forever.ExpiresAt != nil || forever.Expired(time.Now().Add(100*365*24*time.Hour)) {
+		t.Fatalf("key without ttl expires at %v", forever.ExpiresAt)
+	}
+}
+
+// APIKeyAuth exposes the key's scopes to RequireScopes; store failures are 500s, not 401s.
+func TestAPIKeyAuthScopes(t *testing.T) {
+	s := testAPIKeyService(t)
+	reader, _, err := s.Create("user-1", "reader", []string{"docs:read"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, _, err := s.Create("user-1", "writer", []string{"docs:read", "docs:write"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := New()
+	app.Use(APIKeyAuth(s))
+	app.GET("/r", func(c *Context) error {
+		return c.NoContent(http.StatusOK)
+	}).RequireScopes("docs:write").Handler()
+
+	tests := map[string]struct {
+		key  string
+		want int
+	}{
+		"all scopes":     {writer, http.StatusOK},
+		"missing scope":  {reader, http.StatusForbidden},
+		"no key":         {"", http.StatusUnauthorized},
+		"invalid key":    {"qd_nope_nope", http.StatusUnauthorized},
+		"not an API key": {"Bearer " + writer, http.StatusUnauthorized},
+	}
+	for name, tt := range tests {
+		if got := apiKeyStatus(app, tt.key); got != tt.want {
+			t.Errorf("%s: got %d, want %d", name, got, tt.want)
+		}
+	}
+
+	broken := New()
+	broken.Use(APIKeyAuth(&APIKeyService{Store: failingAPIKeyStore{NewMemoryAPIKeyStore()}, Prefix: "qd"}))
+	broken.GET("/r", func(c *Context) error {
+		return c.NoContent(http.StatusOK)
+	}).Handler()
+	if got := apiKeyStatus(broken, writer); got != http.StatusInternalServerError {
+		t.Errorf("store failure: got %d, want 500", got)
+	}
+}
+
+// Only the key's owner can revoke it, and a revoked key stops working at once.
+func TestAPIKeyRevoke(t *testing.T) {
+	s := testAPIKeyService(t)
+	plaintext, created, err := s.Create("user-1", "CI", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := New()
+	app.Use(APIKeyAuth(s))
+	app.GET("/r", func(c *Context) error {
+		return c.NoContent(http.StatusOK)
+	}).Handler()
+
+	if ok, err := s.Revoke("user-2", created.ID); ok || err != nil {
+		t.Fatalf("revoking another user's key: got %v, %v", ok, err)
+	}
+	if got := apiKeyStatus(app, plaintext); got != http.StatusOK {
+		t.Fatalf("after a foreign revoke attempt: got %d, want 200", got)
+	}
+	if ok, err := s.Revoke("user-1", created.ID); !ok || err != nil {
+		t.Fatalf("revoking own key: got %v, %v", ok, err)
+	}
+	if got := apiKeyStatus(app, plaintext); got != http.StatusUnauthorized {
+		t.Fatalf("revoked key: got %d, want 401", got)
+	}
+	if ok, _ := s.Revoke("user-1", created.ID); ok {
+		t.Fatal("revoking twice reported success")
+	}
+	if keys, _ := s.List("user-1"); len(keys) != 0 {
+		t.Fatalf("List after revoke = %v", keys)
+	}
+}
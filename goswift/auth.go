@@ -6,53 +6,233 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
-
-	"golang.org/x/crypto/bcrypt" // External dependency for password hashing
-)
-
-const (
-	sessionCookieName = "goswift_session"
-	sessionExpiry     = 24 * time.Hour // Sessions expire after 24 hours
 )
 
-// HashPassword generates a bcrypt hash of the password.
+// HashPassword hashes the password with the default PasswordHasher (bcrypt unless changed
+// with SetDefaultPasswordHasher).
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+	return DefaultPasswordHasher().Hash(password)
 }
 
-// CheckPasswordHash compares a plain password with its bcrypt hash.
+// CheckPasswordHash compares a plain password with a hash produced by any supported algorithm.
+// An empty or unusable hash (e.g. an account that only signs in through OIDC) never matches,
+// but is checked against the dummy hash so it takes as long as a real one.
 func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	if !isPasswordHash(hash) {
+		CheckDummyPassword(password)
+		return false
+	}
+	ok, err := VerifyPassword(password, hash)
+	return err == nil && ok
+}
+
+// isPasswordHash reports whether s looks like a hash produced by HashPassword.
+func isPasswordHash(s string) bool {
+	return isBcryptHash(s) || strings.HasPrefix(s, "$argon2id$")
+}
+
+// SessionConfig controls session lifetimes and the session cookie.
+type SessionConfig struct {
+	CookieName  string
+	Domain      string
+	Path        string
+	Secure      bool // Set to true in production with HTTPS
+	SameSite    http.SameSite
+	Partitioned bool // CHIPS; requires Secure
+	// MaxLifetime is the absolute lifetime of a session, regardless of activity.
+	MaxLifetime time.Duration
+	// IdleTimeout expires sessions that see no activity for this long (sliding expiry). 0 disables it.
+	IdleTimeout time.Duration
+}
+
+// DefaultSessionConfig returns the default session settings: a 24h absolute lifetime,
+// no idle timeout and a Lax, HttpOnly cookie named "goswift_session".
+func DefaultSessionConfig() SessionConfig {
+	return SessionConfig{
+		CookieName:  "goswift_session",
+		Path:        "/",
+		SameSite:    http.SameSiteLaxMode,
+		MaxLifetime: 24 * time.Hour, // Sessions expire after 24 hours
+	}
+}
+
+// SessionConfigFromConfig builds a SessionConfig from the ConfigManager, falling back to the defaults.
+// Recognised keys: SESSION_COOKIE_NAME, SESSION_COOKIE_DOMAIN, SESSION_COOKIE_PATH, SESSION_COOKIE_SECURE,
+// SESSION_COOKIE_SAMESITE (lax, strict, none), SESSION_COOKIE_PARTITIONED, SESSION_MAX_LIFETIME and
+// SESSION_IDLE_TIMEOUT (Go durations, e.g. "30m").
+func SessionConfigFromConfig(cm *ConfigManager) (SessionConfig, error) {
+	cfg := DefaultSessionConfig()
+	if v := cm.Get("SESSION_COOKIE_NAME"); v != "" {
+		cfg.CookieName = v
+	}
+	if v := cm.Get("SESSION_COOKIE_DOMAIN"); v != "" {
+		cfg.Domain = v
+	}
+	if v := cm.Get("SESSION_COOKIE_PATH"); v != "" {
+		cfg.Path = v
+	}
+	cfg.Secure = cm.Get("SESSION_COOKIE_SECURE") == "true"
+	cfg.Partitioned = cm.Get("SESSION_COOKIE_PARTITIONED") == "true"
+	switch strings.ToLower(cm.Get("SESSION_COOKIE_SAMESITE")) {
+	case "", "lax":
+		cfg.SameSite = http.SameSiteLaxMode
+	case "strict":
+		cfg.SameSite = http.SameSiteStrictMode
+	case "none":
+		cfg.SameSite = http.SameSiteNoneMode
+	default:
+		return cfg, fmt.Errorf("invalid SESSION_COOKIE_SAMESITE '%s'", cm.Get("SESSION_COOKIE_SAMESITE"))
+	}
+	for key, target := range map[string]*time.Duration{
+		"SESSION_MAX_LIFETIME": &cfg.MaxLifetime,
+		"SESSION_IDLE_TIMEOUT": &cfg.IdleTimeout,
+	} {
+		if v := cm.Get(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			*target = d
+		}
+	}
+	return cfg, nil
 }
 
 // Session represents a user session.
+// Values holds arbitrary data; since stores may serialize it as JSON, numbers read back as float64.
 type Session struct {
-	UserID    string
-	ExpiresAt time.Time
+	ID            string                 `json:"id"`
+	UserID        string                 `json:"user_id"`
+	Values        map[string]interface{} `json:"values,omitempty"`
+	FlashMessages []string               `json:"flashes,omitempty"`
+	CreatedAt     time.Time              `json:"created_at"`
+	// ExpiresAt is when the session becomes invalid: the earlier of the absolute deadline
+	// and the idle deadline. Stores only need to look at this field.
+	ExpiresAt time.Time `json:"expires_at"`
+	// AbsoluteExpiresAt is the hard deadline set when the session was created.
+	AbsoluteExpiresAt time.Time `json:"absolute_expires_at"`
+
+	token string // Cookie value the session was loaded from or last saved as
+}
+
+// Set stores a value in the session. Call Context.SaveSession to persist it.
+func (s *Session) Set(key string, value interface{}) {
+	if s.Values == nil {
+		s.Values = make(map[string]interface{})
+	}
+	s.Values[key] = value
+}
+
+// Get returns a value from the session.
+func (s *Session) Get(key string) (interface{}, bool) {
+	value, ok := s.Values[key]
+	return value, ok
+}
+
+// Delete removes a value from the session.
+func (s *Session) Delete(key string) {
+	delete(s.Values, key)
+}
+
+// AddFlash queues a one-time message, typically shown on the next page load.
+func (s *Session) AddFlash(message string) {
+	s.FlashMessages = append(s.FlashMessages, message)
+}
+
+// Flashes returns and clears the queued flash messages. Save the session afterwards
+// so they are not shown again.
+func (s *Session) Flashes() []string {
+	flashes := s.FlashMessages
+	s.FlashMessages = nil
+	return flashes
+}
+
+// IsNew reports whether the session has never been saved.
+func (s *Session) IsNew() bool {
+	return s.token == ""
+}
+
+// clone returns a copy of the session that shares no mutable state with the original.
+func (s *Session) clone() *Session {
+	copied := *s
+	if s.Values != nil {
+		copied.Values = make(map[string]interface{}, len(s.Values))
+		for k, v := range s.Values {
+			copied.Values[k] = v
+		}
+	}
+	copied.FlashMessages = append([]string(nil), s.FlashMessages...)
+	return &copied
 }
 
 // SessionManager handles session creation, storage, and retrieval.
-// This is an in-memory implementation for simplicity.
-// In a production app, use a persistent store (e.g., database, Redis).
+// Sessions are kept in a pluggable SessionStore; NewSessionManager uses an in-memory store.
 type SessionManager struct {
-	mu       sync.RWMutex
-	sessions map[string]Session // map[sessionID]Session
+	store     SessionStore
+	mu        sync.RWMutex
+	config    SessionConfig
+	stopGC    chan struct{}
+	closeOnce sync.Once
 }
 
-// NewSessionManager creates and initializes a new SessionManager.
+// NewSessionManager creates and initializes a new SessionManager backed by an in-memory store.
 func NewSessionManager() *SessionManager {
+	return NewSessionManagerWithStore(NewMemorySessionStore())
+}
+
+// NewSessionManagerWithStore creates a SessionManager backed by the given store
+// and starts a background goroutine that garbage-collects expired sessions until Close is called.
+func NewSessionManagerWithStore(store SessionStore) *SessionManager {
 	sm := &SessionManager{
-		sessions: make(map[string]Session),
+		store:  store,
+		config: DefaultSessionConfig(),
+		stopGC: make(chan struct{}),
 	}
 	// Start a goroutine to clean up expired sessions
 	go sm.cleanupExpiredSessions()
 	return sm
 }
 
+// Store returns the underlying SessionStore.
+func (sm *SessionManager) Store() SessionStore {
+	return sm.store
+}
+
+// SetConfig replaces the session settings. Empty fields fall back to the defaults.
+func (sm *SessionManager) SetConfig(cfg SessionConfig) {
+	defaults := DefaultSessionConfig()
+	if cfg.CookieName == "" {
+		cfg.CookieName = defaults.CookieName
+	}
+	if cfg.Path == "" {
+		cfg.Path = defaults.Path
+	}
+	if cfg.MaxLifetime <= 0 {
+		cfg.MaxLifetime = defaults.MaxLifetime
+	}
+	if cfg.SameSite == 0 {
+		cfg.SameSite = defaults.SameSite
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.config = cfg
+}
+
+// Config returns the current session settings.
+func (sm *SessionManager) Config() SessionConfig {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.config
+}
+
+// Close stops the background session cleanup. It is safe to call more than once.
+func (sm *SessionManager) Close() {
+	sm.closeOnce.Do(func() { close(sm.stopGC) })
+}
+
 // GenerateSessionID generates a new random session ID.
 func (sm *SessionManager) GenerateSessionID() (string, error) {
 	b := make([]byte, 32) // 32 bytes for a strong session ID
@@ -62,69 +242,178 @@ func (sm *SessionManager) GenerateSessionID() (string, error) {
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
-// CreateSession creates a new session for a user and returns its ID.
-func (sm *SessionManager) CreateSession(userID string) (string, error) {
+// NewSession returns a fresh, unsaved session with a new ID and lifetimes taken from the config.
+func (sm *SessionManager) NewSession() (*Session, error) {
 	sessionID, err := sm.GenerateSessionID()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	cfg := sm.Config()
+	now := time.Now()
+	session := &Session{
+		ID:                sessionID,
+		CreatedAt:         now,
+		AbsoluteExpiresAt: now.Add(cfg.MaxLifetime),
+	}
+	session.ExpiresAt = sm.nextExpiry(session, now)
+	return session, nil
+}
 
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-	sm.sessions[sessionID] = Session{
-		UserID:    userID,
-		ExpiresAt: time.Now().Add(sessionExpiry),
+// nextExpiry computes the effective expiry of a session that is active at now.
+func (sm *SessionManager) nextExpiry(session *Session, now time.Time) time.Time {
+	cfg := sm.Config()
+	if cfg.IdleTimeout > 0 {
+		if idle := now.Add(cfg.IdleTimeout); idle.Before(session.AbsoluteExpiresAt) {
+			return idle
+		}
+	}
+	return session.AbsoluteExpiresAt
+}
+
+// CreateSession creates a new session for a user and returns the value to store in the session cookie.
+// For server-side stores this is the session ID; cookie stores return the sealed session itself.
+func (sm *SessionManager) CreateSession(userID string) (string, error) {
+	session, err := sm.NewSession()
+	if err != nil {
+		return "", err
 	}
-	return sessionID, nil
+	session.UserID = userID
+	return sm.store.Save(session)
 }
 
-// GetSession retrieves a session by its ID. Returns nil if not found or expired.
+// GetSession retrieves a session by its cookie value. Returns nil if not found or expired.
 func (sm *SessionManager) GetSession(sessionID string) *Session {
-	sm.mu.RLock()
-	defer sm.mu.RUnlock()
-	session, ok := sm.sessions[sessionID]
-	if !ok || session.ExpiresAt.Before(time.Now()) {
-		return nil // Session not found or expired
+	if sessionID == "" {
+		return nil
 	}
-	return &session
+	session, err := sm.store.Get(sessionID)
+	if err != nil || session == nil || session.ExpiresAt.Before(time.Now()) {
+		return nil // Session not found, unreadable or expired
+	}
+	if !session.AbsoluteExpiresAt.IsZero() && session.AbsoluteExpiresAt.Before(time.Now()) {
+		return nil
+	}
+	session.token = sessionID
+	return session
 }
 
 // DeleteSession removes a session.
 func (sm *SessionManager) DeleteSession(sessionID string) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-	delete(sm.sessions, sessionID)
+	if err := sm.store.Delete(sessionID); err != nil {
+		fmt.Printf("SessionManager: failed to delete session: %v\n", err)
+	}
+}
+
+// Load returns the session referenced by the request cookie, or nil if there is none or it has expired.
+func (sm *SessionManager) Load(r *http.Request) (*Session, error) {
+	sessionID, err := sm.GetSessionIDFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return sm.GetSession(sessionID), nil
+}
+
+// Save persists the session and sets the session cookie. It must be called before the response body is written.
+func (sm *SessionManager) Save(w http.ResponseWriter, session *Session) error {
+	session.ExpiresAt = sm.nextExpiry(session, time.Now())
+	token, err := sm.store.Save(session)
+	if err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	session.token = token
+	sm.setCookie(w, token, session.ExpiresAt)
+	return nil
+}
+
+// Touch records activity on a session, sliding its idle expiry forward.
+// It is a no-op when no idle timeout is configured or the session was touched within the last minute.
+func (sm *SessionManager) Touch(w http.ResponseWriter, session *Session) error {
+	if sm.Config().IdleTimeout <= 0 || session.IsNew() {
+		return nil
+	}
+	expiresAt := sm.nextExpiry(session, time.Now())
+	if expiresAt.Sub(session.ExpiresAt) < time.Minute {
+		return nil // Avoid rewriting the store and cookie on every request
+	}
+	token, err := sm.store.Touch(session.token, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+	session.ExpiresAt = expiresAt
+	session.token = token
+	sm.setCookie(w, token, expiresAt)
+	return nil
+}
+
+// Regenerate gives the session a new ID, deleting the old one, and sets the new cookie.
+// Call it whenever the privilege level changes (notably on login) to prevent session fixation.
+func (sm *SessionManager) Regenerate(w http.ResponseWriter, session *Session) error {
+	newID, err := sm.GenerateSessionID()
+	if err != nil {
+		return err
+	}
+	if !session.IsNew() {
+		if err := sm.store.Delete(session.token); err != nil {
+			return fmt.Errorf("failed to delete old session: %w", err)
+		}
+	}
+	session.ID = newID
+	session.token = ""
+	return sm.Save(w, session)
+}
+
+// Destroy deletes the session from the store and clears the session cookie.
+func (sm *SessionManager) Destroy(w http.ResponseWriter, session *Session) error {
+	if !session.IsNew() {
+		if err := sm.store.Delete(session.token); err != nil {
+			return fmt.Errorf("failed to delete session: %w", err)
+		}
+	}
+	session.token = ""
+	sm.ClearSessionCookie(w)
+	return nil
 }
 
 // SetSessionCookie sets a session cookie in the HTTP response.
 func (sm *SessionManager) SetSessionCookie(w http.ResponseWriter, sessionID string) {
+	sm.setCookie(w, sessionID, time.Now().Add(sm.Config().MaxLifetime))
+}
+
+// setCookie writes the session cookie using the configured attributes.
+func (sm *SessionManager) setCookie(w http.ResponseWriter, value string, expires time.Time) {
+	cfg := sm.Config()
 	http.SetCookie(w, &http.Cookie{
-		Name:     sessionCookieName,
-		Value:    sessionID,
-		Path:     "/",
-		Expires:  time.Now().Add(sessionExpiry),
-		HttpOnly: true, // Prevent JavaScript access to the cookie
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: http.SameSiteLaxMode,
+		Name:        cfg.CookieName,
+		Value:       value,
+		Path:        cfg.Path,
+		Domain:      cfg.Domain,
+		Expires:     expires,
+		HttpOnly:    true, // Prevent JavaScript access to the cookie
+		Secure:      cfg.Secure,
+		SameSite:    cfg.SameSite,
+		Partitioned: cfg.Partitioned,
 	})
 }
 
 // ClearSessionCookie removes the session cookie from the HTTP response.
 func (sm *SessionManager) ClearSessionCookie(w http.ResponseWriter) {
+	cfg := sm.Config()
 	http.SetCookie(w, &http.Cookie{
-		Name:     sessionCookieName,
-		Value:    "",
-		Path:     "/",
-		MaxAge:   -1, // Delete the cookie
-		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: http.SameSiteLaxMode,
+		Name:        cfg.CookieName,
+		Value:       "",
+		Path:        cfg.Path,
+		Domain:      cfg.Domain,
+		MaxAge:      -1, // Delete the cookie
+		HttpOnly:    true,
+		Secure:      cfg.Secure,
+		SameSite:    cfg.SameSite,
+		Partitioned: cfg.Partitioned,
 	})
 }
 
 // GetSessionIDFromRequest extracts the session ID from the request cookie.
 func (sm *SessionManager) GetSessionIDFromRequest(r *http.Request) (string, error) {
-	cookie, err := r.Cookie(sessionCookieName)
+	cookie, err := r.Cookie(sm.Config().CookieName)
 	if err != nil {
 		if err == http.ErrNoCookie {
 			return "", nil // No session cookie found
@@ -134,18 +423,20 @@ func (sm *SessionManager) GetSessionIDFromRequest(r *http.Request) (string, erro
 	return cookie.Value, nil
 }
 
-// cleanupExpiredSessions periodically removes expired sessions from memory.
+// cleanupExpiredSessions periodically removes expired sessions from the store until Close is called.
 func (sm *SessionManager) cleanupExpiredSessions() {
 	ticker := time.NewTicker(time.Minute) // Check every minute
 	defer ticker.Stop()
 
-	for range ticker.C {
-		sm.mu.Lock()
-		for sessionID, session := range sm.sessions {
-			if session.ExpiresAt.Before(time.Now()) {
-				delete(sm.sessions, sessionID)
+	for {
+		select {
+		case <-ticker.C:
+			if err := sm.store.GC(); err != nil {
+				// No engine logger is available here, mirror AsyncTaskQueue's logging
+				fmt.Printf("SessionManager: session cleanup failed: %v\n", err)
 			}
+		case <-sm.stopGC:
+			return
 		}
-		sm.mu.Unlock()
 	}
 }
@@ -0,0 +1,226 @@
+// go-swift/goswift/authz.go
+package goswift
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Subject is the authenticated principal an authorization decision is made for.
+type Subject struct {
+	ID     string
+	Roles  []string
+	Scopes []string
+	Source string // "jwt", "apikey" or "session"
+}
+
+// HasRole reports whether the subject has the given role.
+func (s *Subject) HasRole(role string) bool {
+	return containsString(s.Roles, role)
+}
+
+// HasScope reports whether the subject was granted the given scope.
+func (s *Subject) HasScope(scope string) bool {
+	return containsString(s.Scopes, scope)
+}
+
+// subjectClaims are the custom JWT claims Subject reads roles and scopes from.
+// "scope" is a space-separated string as in OAuth 2.0 (RFC 8693); "scp" is a list.
+type subjectClaims struct {
+	Roles []string `json:"roles"`
+	Scope string   `json:"scope"`
+	Scp   []string `json:"scp"`
+}
+
+// Subject returns the authenticated subject of the request.
+// It reads the "roles" and "scope"/"scp" claims of the JWT set by JWTAuthMiddleware, the scopes
+// of the key used with APIKeyAuth, or else the "roles" and "scopes" values of the session loaded
+// by AuthMiddleware.
+// It returns a 401 HTTPError if the request is not authenticated.
+func (c *Context) Subject() (*Subject, error) {
+	if v, ok := c.Get("subject"); ok {
+		subject, isSubject := v.(*Subject)
+		if !isSubject {
+			return nil, NewHTTPError(http.StatusInternalServerError, "Internal Server Error", fmt.Errorf("context value 'subject' is a %T", v))
+		}
+		return subject, nil
+	}
+	userID, err := c.UserID()
+	if err != nil {
+		return nil, err
+	}
+
+	subject := &Subject{ID: userID}
+	if v, ok := c.Get("apiKey"); ok {
+		key, isKey := v.(*APIKey)
+		if !isKey {
+			return nil, NewHTTPError(http.StatusInternalServerError, "Internal Server Error", fmt.Errorf("context value 'apiKey' is a %T", v))
+		}
+		subject.Source = "apikey"
+		subject.Scopes = key.Scopes
+	} else if claims, err := c.Claims(); err == nil {
+		var custom subjectClaims
+		if err := claims.Decode(&custom); err != nil {
+			return nil, NewHTTPError(http.StatusUnauthorized, "Invalid token claims", err)
+		}
+		subject.Source = "jwt"
+		subject.Roles = custom.Roles
+		subject.Scopes = append(strings.Fields(custom.Scope), custom.Scp...)
+	} else if c.session != nil {
+		subject.Source = "session"
+		subject.Roles = sessionStrings(c.session, "roles")
+		subject.Scopes = sessionStrings(c.session, "scopes")
+	}
+	c.Set("subject", subject)
+	return subject, nil
+}
+
+// sessionStrings reads a list of strings from a session value. JSON-backed stores
+// return []interface{} rather than []string, so both are accepted.
+func sessionStrings(s *Session, key string) []string {
+	v, ok := s.Get(key)
+	if !ok {
+		return nil
+	}
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if str, ok := item.(string); ok {
+				out = append(out, str)
+			}
+		}
+		return out
+	case string:
+		return strings.Fields(list)
+	}
+	return nil
+}
+
+// Policy decides whether the current request may proceed. Returning an HTTPError
+// (e.g. 404 for a missing resource) short-circuits the decision with that error.
+type Policy func(c *Context) (bool, error)
+
+// Authorize allows the request only if policy returns true. Unauthenticated requests get
+// 401 Unauthorized and denied ones 403 Forbidden. Every decision is logged (allows only with
+// debug logging enabled), so place it after the authentication middleware.
+func Authorize(policy Policy) MiddlewareFunc {
+	return authorize("policy", policy)
+}
+
+// RequireRoles allows subjects that have at least one of the given roles.
+func RequireRoles(roles ...string) MiddlewareFunc {
+	return authorize("roles="+strings.Join(roles, ","), func(c *Context) (bool, error) {
+		subject, err := c.Subject()
+		if err != nil {
+			return false, err
+		}
+		for _, role := range roles {
+			if subject.HasRole(role) {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
+
+// RequireScopes allows subjects that were granted all of the given scopes.
+func RequireScopes(scopes ...string) MiddlewareFunc {
+	return authorize("scopes="+strings.Join(scopes, ","), func(c *Context) (bool, error) {
+		subject, err := c.Subject()
+		if err != nil {
+			return false, err
+		}
+		for _, scope := range scopes {
+			if !subject.HasScope(scope) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+// authorize runs policy and turns its outcome into a response and an audit log line.
+func authorize(rule string, policy Policy) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			subjectID, err := c.UserID()
+			if err != nil {
+				auditDecision(c, "unauthenticated", "-", rule)
+				return err
+			}
+
+			allowed, err := policy(c)
+			if err != nil {
+				var httpErr *HTTPError
+				if !errors.As(err, &httpErr) {
+					err = NewHTTPError(http.StatusInternalServerError, "Internal Server Error", err)
+				}
+				auditDecision(c, "error", subjectID, rule)
+				return err
+			}
+			if !allowed {
+				auditDecision(c, "deny", subjectID, rule)
+				return NewHTTPError(http.StatusForbidden, "Forbidden")
+			}
+			auditDecision(c, "allow", subjectID, rule)
+			return next(c)
+		}
+	}
+}
+
+// auditDecision logs an authorization decision as key=value pairs for easy filtering.
+// Allows are routine and logged at debug level; everything else is a warning.
+func auditDecision(c *Context, decision, subjectID, rule string) {
+	line := "authz decision=%s subject=%s method=%s path=%s rule=%q ip=%s"
+	args := []interface{}{decision, subjectID, c.Request.Method, c.Request.URL.Path, rule, c.RealIP()}
+	if decision == "allow" {
+		c.engine.Logger.Debug(line, args...)
+	} else {
+		c.engine.Logger.Warning(line, args...)
+	}
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRoles restricts this route to subjects with at least one of the given roles.
+func (rb *RouteBuilder) RequireRoles(roles ...string) *RouteBuilder {
+	return rb.Before(RequireRoles(roles...))
+}
+
+// RequireScopes restricts this route to subjects granted all of the given scopes.
+func (rb *RouteBuilder) RequireScopes(scopes ...string) *RouteBuilder {
+	return rb.Before(RequireScopes(scopes...))
+}
+
+// Authorize restricts this route with a custom policy.
+func (rb *RouteBuilder) Authorize(policy Policy) *RouteBuilder {
+	return rb.Before(Authorize(policy))
+}
+
+// RequireRoles restricts all routes subsequently registered in the group to the given roles.
+func (rg *RouterGroup) RequireRoles(roles ...string) {
+	rg.Use(RequireRoles(roles...))
+}
+
+// RequireScopes restricts all routes subsequently registered in the group to the given scopes.
+func (rg *RouterGroup) RequireScopes(scopes ...string) {
+	rg.Use(RequireScopes(scopes...))
+}
+
+// Authorize restricts all routes subsequently registered in the group with a custom policy.
+func (rg *RouterGroup) Authorize(policy Policy) {
+	rg.Use(Authorize(policy))
+}
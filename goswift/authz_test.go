@@ -0,0 +1,146 @@
+// go-swift/goswift/authz_test.go
+package goswift
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// authzTestAuth stands in for the authentication middleware: it authenticates requests
+// carrying X-Test-User, with the roles of a JWT (X-Test-Roles) or the scopes of an API key
+// (X-Test-Scopes).
+func authzTestAuth(t *testing.T) MiddlewareFunc {
+	t.Helper()
+	s := testJWTService(t, mustJWTKey(NewHMACKey("k1", "HS256", testHMACSecret)))
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			userID := c.Request.Header.Get("X-Test-User")
+			if userID == "" {
+				return next(c)
+			}
+			c.Set("userID", userID)
+			if scopes := c.Request.Header.Get("X-Test-Scopes"); scopes != "" {
+				c.Set("apiKey", &APIKey{UserID: userID, Scopes: strings.Split(scopes, ",")})
+				return next(c)
+			}
+			token, err := s.GenerateWithClaims(userID, map[string]interface{}{"roles": strings.Split(c.Request.Header.Get("X-Test-Roles"), ",")})
+			if err != nil {
+				return err
+			}
+			claims, err := s.Validate(token)
+			if err != nil {
+				return err
+			}
+			c.Set("claims", claims)
+			return next(c)
+		}
+	}
+}
+
+// authzStatus sends GET /r to app with the given test headers and returns the status.
+func authzStatus(app *Engine, headers map[string]string) int {
+	req := httptest.NewRequest(http.MethodGet, "/r", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// Unauthenticated requests get 401; authenticated ones without the required roles, scopes or
+// policy approval get 403.
+func TestAuthorizationStatus(t *testing.T) {
+	errBoom := errors.New("boom")
+	ownerOnly := func(c *Context) (bool, error) {
+		subject, err := c.Subject()
+		if err != nil {
+			return false, err
+		}
+		switch subject.ID {
+		case "missing":
+			return false, NewHTTPError(http.StatusNotFound, "Not Found")
+		case "broken":
+			return false, errBoom
+		}
+		return subject.ID == "owner", nil
+	}
+
+	tests := []struct {
+		name    string
+		rule    MiddlewareFunc
+		headers map[string]string
+		want    int
+	}{
+		{"roles, unauthenticated", RequireRoles("admin", "editor"), nil, http.StatusUnauthorized},
+		{"roles, none matching", RequireRoles("admin", "editor"), map[string]string{"X-Test-User": "u", "X-Test-Roles": "viewer"}, http.StatusForbidden},
+		{"roles, one matching", RequireRoles("admin", "editor"), map[string]string{"X-Test-User": "u", "X-Test-Roles": "viewer,editor"}, http.StatusOK},
+		{"roles, API key without roles", RequireRoles("admin"), map[string]string{"X-Test-User": "u", "X-Test-Scopes": "admin"}, http.StatusForbidden},
+		{"scopes, unauthenticated", RequireScopes("docs:read", "docs:write"), nil, http.StatusUnauthorized},
+		{"scopes, some missing", RequireScopes("docs:read", "docs:write"), map[string]string{"X-Test-User": "u", "X-Test-Scopes": "docs:read"}, http.StatusForbidden},
+		{"scopes, all granted", RequireScopes("docs:read", "docs:write"), map[string]string{"X-Test-User": "u", "X-Test-Scopes": "docs:write,docs:read"}, http.StatusOK},
+		{"policy, unauthenticated", Authorize(ownerOnly), nil, http.StatusUnauthorized},
+		{"policy, denied", Authorize(ownerOnly), map[string]string{"X-Test-User": "someone"}, http.StatusForbidden},
+		{"policy, allowed", Authorize(ownerOnly), map[string]string{"X-Test-User": "owner"}, http.StatusOK},
+		{"policy, HTTPError", Authorize(ownerOnly), map[string]string{"X-Test-User": "missing"}, http.StatusNotFound},
+		{"policy, other error", Authorize(ownerOnly), map[string]string{"X-Test-User": "broken"}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := New()
+			app.Use(authzTestAuth(t))
+			app.GET("/r", func(c *Context) error {
+				return c.NoContent(http.StatusOK)
+			}).Before(tt.rule).Handler()
+			if got := authzStatus(app, tt.headers); got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// Context values of the wrong type are a server error, not a panic.
+func TestSubjectWrongContextType(t *testing.T) {
+	for _, key := range []string{"subject", "apiKey"} {
+		app := New()
+		app.GET("/r", func(c *Context) error {
+			c.Set("userID", "u")
+			c.Set(key, "not the right type")
+			_, err := c.Subject()
+			return err
+		}).Handler()
+		if got := authzStatus(app, nil); got != http.StatusInternalServerError {
+			t.Errorf("%s: got %d, want 500", key, got)
+		}
+	}
+}
+
+// Denials are logged as warnings; allows only at debug level.
+func TestAuthorizationAuditLog(t *testing.T) {
+	app := New()
+	var logs bytes.Buffer
+	app.Logger.SetOutput(&logs)
+	app.Use(authzTestAuth(t))
+	app.GET("/r", func(c *Context) error {
+		return c.NoContent(http.StatusOK)
+	}).RequireRoles("admin").Handler()
+
+	authzStatus(app, map[string]string{"X-Test-User": "u", "X-Test-Roles": "admin"})
+	if strings.Contains(logs.String(), "decision=allow") {
+		t.Fatalf("allow logged without debug logging: %s", logs.String())
+	}
+	authzStatus(app, map[string]string{"X-Test-User": "u", "X-Test-Roles": "viewer"})
+	if !strings.Contains(logs.String(), "[WARN] authz decision=deny subject=u") {
+		t.Fatalf("deny not logged as a warning: %s", logs.String())
+	}
+
+	app.Logger.SetDebug(true)
+	authzStatus(app, map[string]string{"X-Test-User": "u", "X-Test-Roles": "admin"})
+	if !strings.Contains(logs.String(), "[DEBUG] authz decision=allow subject=u") {
+		t.Fatalf("allow not logged at debug level: %s", logs.String())
+	}
+}
@@ -0,0 +1,130 @@
+// go-swift/goswift/bodylimit_test.go
+package goswift
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// bodyLimitHandler reads the whole body and answers with its size. Read errors are returned
+// as plain errors, which BodyLimit must still turn into a 413.
+func bodyLimitHandler(c *Context) error {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return fmt.Errorf("reading body: %w", err)
+	}
+	return c.String(http.StatusOK, "%d", len(body))
+}
+
+// bodyLimitStatus POSTs size bytes to path and returns the status. Unless announced is set the
+// body is sent without a Content-Length, so only reading it can hit the limit.
+func bodyLimitStatus(app *Engine, path string, size int, announced bool) int {
+	var body io.Reader = strings.NewReader(strings.Repeat("x", size))
+	if !announced {
+		body = io.MultiReader(body) // Hides the length from httptest.NewRequest
+	}
+	req := httptest.NewRequest(http.MethodPost, path, body)
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// Route limits override group limits, which override the global BodyLimit, in either direction.
+func TestBodyLimitOverrides(t *testing.T) {
+	app := New()
+	app.Use(BodyLimit(10))
+	app.POST("/global", bodyLimitHandler).Handler()
+	app.POST("/raised", bodyLimitHandler).BodyLimit(100).Handler()
+	app.POST("/lowered", bodyLimitHandler).BodyLimit(4).Handler()
+	group := app.Group("/group")
+	group.BodyLimit(50)
+	group.POST("", bodyLimitHandler).Handler()
+	group.POST("/route", bodyLimitHandler).BodyLimit(5).Handler()
+
+	tests := []struct {
+		path string
+		size int
+		want int
+	}{
+		{"/global", 10, http.StatusOK},
+		{"/global", 11, http.StatusRequestEntityTooLarge},
+		{"/raised", 100, http.StatusOK},
+		{"/raised", 101, http.StatusRequestEntityTooLarge},
+		{"/lowered", 4, http.StatusOK},
+		{"/lowered", 5, http.StatusRequestEntityTooLarge},
+		{"/group", 50, http.StatusOK},
+		{"/group", 51, http.StatusRequestEntityTooLarge},
+		{"/group/route", 5, http.StatusOK},
+		{"/group/route", 6, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		for _, announced := range []bool{true, false} {
+			if got := bodyLimitStatus(app, tt.path, tt.size, announced); got != tt.want {
+				t.Errorf("%s, %d bytes (Content-Length: %v): got %d, want %d", tt.path, tt.size, announced, got, tt.want)
+			}
+		}
+	}
+}
+
+// Route and group limits apply without a global BodyLimit; other routes stay unlimited.
+func TestBodyLimitWithoutGlobal(t *testing.T) {
+	app := New()
+	app.POST("/unlimited", bodyLimitHandler).Handler()
+	app.POST("/route", bodyLimitHandler).BodyLimit(8).Handler()
+	group := app.Group("/group")
+	group.BodyLimit(8)
+	group.POST("", bodyLimitHandler).Handler()
+
+	if got := bodyLimitStatus(app, "/unlimited", 1<<16, false); got != http.StatusOK {
+		t.Errorf("/unlimited: got %d, want 200", got)
+	}
+	for _, path := range []string{"/route", "/group"} {
+		if got := bodyLimitStatus(app, path, 9, false); got != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: got %d, want 413", path, got)
+		}
+	}
+}
+
+// Hitting the limit is a 413 whatever the handler makes of the read error, and an announced
+// oversized body is rejected before the handler runs.
+func TestBodyLimitStatusMapping(t *testing.T) {
+	handlers := map[string]HandlerFunc{
+		"BindJSON": func(c *Context) error {
+			var v map[string]string
+			if err := c.BindJSON(&v); err != nil {
+				return NewHTTPError(http.StatusBadRequest, "Invalid JSON", err)
+			}
+			return c.NoContent(http.StatusOK)
+		},
+		"swallowed error": func(c *Context) error {
+			io.ReadAll(c.Request.Body)
+			return nil
+		},
+	}
+	for name, handler := range handlers {
+		app := New()
+		app.Use(BodyLimit(16))
+		app.POST("/r", handler).Handler()
+		req := httptest.NewRequest(http.MethodPost, "/r", io.MultiReader(strings.NewReader(`{"text":"`+strings.Repeat("x", 32)+`"}`)))
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: got %d, want 413", name, rec.Code)
+		}
+	}
+
+	called := false
+	app := New()
+	app.Use(BodyLimit(16))
+	app.POST("/r", func(c *Context) error {
+		called = true
+		return nil
+	}).Handler()
+	if got := bodyLimitStatus(app, "/r", 17, true); got != http.StatusRequestEntityTooLarge || called {
+		t.Fatalf("announced oversized body: got %d, handler called %v", got, called)
+	}
+}
@@ -0,0 +1,145 @@
+// go-swift/goswift/broker.go
+package goswift
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrBrokerClosed is returned when using a broker after Close.
+var ErrBrokerClosed = errors.New("broker is closed")
+
+// BrokerHandler receives the messages published on a subscribed topic. Handlers run on the
+// broker's delivery goroutine and should return quickly.
+type BrokerHandler func(topic string, payload []byte)
+
+// BrokerSubscription identifies a subscription for Unsubscribe.
+type BrokerSubscription struct {
+	Topic string
+	id    uint64
+}
+
+// Broker is a publish/subscribe transport. SSEManager publishes through it, so that with a
+// broker shared between processes, events reach subscribers connected to any instance.
+//
+// Messages published on a topic are delivered to every subscription of that topic, including
+// ones in the publishing process; messages from one publisher arrive in the order they were
+// published. Implementations must not hold their subscription locks while calling handlers,
+// so handlers may subscribe and unsubscribe.
+type Broker interface {
+	Publish(topic string, payload []byte) error
+	Subscribe(topic string, handler BrokerHandler) (BrokerSubscription, error)
+	Unsubscribe(sub BrokerSubscription) error
+	Close() error
+}
+
+// brokerHandlers is the subscription registry shared by the broker implementations.
+type brokerHandlers struct {
+	mu       sync.RWMutex
+	handlers map[string]map[uint64]BrokerHandler // map[topic]map[subscriptionID]BrokerHandler
+	nextID   uint64
+}
+
+// add registers a handler and reports whether it is the topic's first.
+func (h *brokerHandlers) add(topic string, handler BrokerHandler) (BrokerSubscription, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.handlers == nil {
+		h.handlers = make(map[string]map[uint64]BrokerHandler)
+	}
+	first := len(h.handlers[topic]) == 0
+	if first {
+		h.handlers[topic] = make(map[uint64]BrokerHandler)
+	}
+	h.nextID++
+	h.handlers[topic][h.nextID] = handler
+	return BrokerSubscription{Topic: topic, id: h.nextID}, first
+}
+
+// remove unregisters a handler and reports whether it was the topic's last.
+func (h *brokerHandlers) remove(sub BrokerSubscription) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	topicHandlers, ok := h.handlers[sub.Topic]
+	if !ok {
+		return false
+	}
+	if _, ok := topicHandlers[sub.id]; !ok {
+		return false
+	}
+	delete(topicHandlers, sub.id)
+	if len(topicHandlers) == 0 {
+		delete(h.handlers, sub.Topic)
+		return true
+	}
+	return false
+}
+
+// topics returns the topics with at least one handler.
+func (h *brokerHandlers) topics() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	topics := make([]string, 0, len(h.handlers))
+	for topic := range h.handlers {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// dispatch calls the topic's handlers, without holding the lock.
+func (h *brokerHandlers) dispatch(topic string, payload []byte) {
+	h.mu.RLock()
+	handlers := make([]BrokerHandler, 0, len(h.handlers[topic]))
+	for _, handler := range h.handlers[topic] {
+		handlers = append(handlers, handler)
+	}
+	h.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(topic, payload)
+	}
+}
+
+// MemoryBroker is an in-process Broker. Publish delivers synchronously, so handlers must not
+// publish on the same broker themselves. It only connects components of the same process;
+// use a NetBroker to fan out across instances.
+type MemoryBroker struct {
+	handlers  brokerHandlers
+	publishMu sync.Mutex // Keeps concurrent publishes in one order for all subscribers
+	closed    bool
+}
+
+// NewMemoryBroker creates an in-process broker.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+// Publish implements Broker.
+func (b *MemoryBroker) Publish(topic string, payload []byte) error {
+	b.publishMu.Lock()
+	defer b.publishMu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	b.handlers.dispatch(topic, payload)
+	return nil
+}
+
+// Subscribe implements Broker.
+func (b *MemoryBroker) Subscribe(topic string, handler BrokerHandler) (BrokerSubscription, error) {
+	sub, _ := b.handlers.add(topic, handler)
+	return sub, nil
+}
+
+// Unsubscribe implements Broker.
+func (b *MemoryBroker) Unsubscribe(sub BrokerSubscription) error {
+	b.handlers.remove(sub)
+	return nil
+}
+
+// Close implements Broker.
+func (b *MemoryBroker) Close() error {
+	b.publishMu.Lock()
+	defer b.publishMu.Unlock()
+	b.closed = true
+	return nil
+}
@@ -26,6 +26,13 @@ type Context struct {
 	mu   sync.RWMutex // Mutex for data map access
 	// Reference to the engine for accessing logger, config, etc.
 	engine *Engine
+	// Body size limit of the matched route, if any (see RouteBuilder.BodyLimit)
+	routeBodyLimit int64
+	// Original request body, kept so nested BodyLimit middleware can re-wrap it
+	rawBody io.ReadCloser
+	// Lazily loaded session (see Session) and the manager it belongs to
+	session    *Session
+	sessionMan *SessionManager
 }
 
 // newContext creates a new Context for a given HTTP request and response.
@@ -41,21 +48,41 @@ func newContext(w http.ResponseWriter, r *http.Request) *Context {
 // responseWriter is a wrapper around http.ResponseWriter to capture the status code.
 type responseWriter struct {
 	http.ResponseWriter
-	status int
-	size   int
+	status   int
+	size     int
+	hijacked bool // Set by Context.Upgrade; the connection no longer speaks HTTP
 }
 
 func (rw *responseWriter) WriteHeader(statusCode int) {
+	if rw.hijacked {
+		return
+	}
 	rw.status = statusCode
 	rw.ResponseWriter.WriteHeader(statusCode)
 }
 
 func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.hijacked {
+		return 0, http.ErrHijacked
+	}
 	size, err := rw.ResponseWriter.Write(b)
 	rw.size += size
 	return size, err
 }
 
+// Flush sends buffered data to the client if the underlying writer supports it.
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, so http.ResponseController can reach its
+// Flush, SetWriteDeadline and Hijack methods.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Status returns the HTTP status code written to the response.
 func (c *Context) Status() int {
 	if c.Writer.status == 0 {
@@ -204,6 +231,87 @@ func (c *Context) TraceID() string {
 	return ""
 }
 
+// UserID returns the authenticated user's ID as set by the auth middleware.
+// It returns a 401 HTTPError if the request is not authenticated, so handlers can return it directly.
+func (c *Context) UserID() (string, error) {
+	if id, ok := c.Get("userID"); ok {
+		if userID, isString := id.(string); isString && userID != "" {
+			return userID, nil
+		}
+	}
+	return "", NewHTTPError(http.StatusUnauthorized, "Authentication required")
+}
+
+// Claims returns the claims of the JWT that authenticated the request (see JWTAuthMiddleware).
+// It returns a 401 HTTPError if the request was not authenticated with a token.
+func (c *Context) Claims() (*Claims, error) {
+	if v, ok := c.Get("claims"); ok {
+		if claims, isClaims := v.(*Claims); isClaims {
+			return claims, nil
+		}
+	}
+	return nil, NewHTTPError(http.StatusUnauthorized, "Token authentication required")
+}
+
+// Session returns the current session, loading it from the session cookie on first use.
+// If the request has no valid session, a new empty one is returned; it is only persisted
+// (and the cookie set) once SaveSession is called.
+func (c *Context) Session() (*Session, error) {
+	if c.session != nil {
+		return c.session, nil
+	}
+	sm := c.sessionManager()
+	session, err := sm.Load(c.Request)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		if session, err = sm.NewSession(); err != nil {
+			return nil, err
+		}
+	}
+	c.session = session
+	return session, nil
+}
+
+// SaveSession persists the current session and sets the session cookie.
+// It must be called before writing the response body.
+func (c *Context) SaveSession() error {
+	if c.session == nil {
+		return nil // Nothing was loaded or changed
+	}
+	return c.sessionManager().Save(c.Writer, c.session)
+}
+
+// RegenerateSession issues a new session ID for the current session, keeping its data.
+// Call it on login to prevent session fixation.
+func (c *Context) RegenerateSession() error {
+	session, err := c.Session()
+	if err != nil {
+		return err
+	}
+	return c.sessionManager().Regenerate(c.Writer, session)
+}
+
+// DestroySession deletes the current session and clears the session cookie.
+func (c *Context) DestroySession() error {
+	session, err := c.Session()
+	if err != nil {
+		return err
+	}
+	err = c.sessionManager().Destroy(c.Writer, session)
+	c.session = nil
+	return err
+}
+
+// sessionManager returns the SessionManager that owns the context's session.
+func (c *Context) sessionManager() *SessionManager {
+	if c.sessionMan != nil {
+		return c.sessionMan
+	}
+	return c.engine.SessionMan
+}
+
 // JSON sends a JSON response with the given status code and data.
 func (c *Context) JSON(statusCode int, data interface{}) error {
 	c.Writer.Header().Set("Content-Type", "application/json")
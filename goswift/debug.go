@@ -7,6 +7,7 @@ import (
 	"reflect" // Added for getFunctionName
 	"runtime"
 	"runtime/pprof"
+	"strings"
 	"time"
 )
 
@@ -26,20 +27,35 @@ func DebugRoutesHandler(c *Context) error {
 	return c.JSON(http.StatusOK, routesInfo)
 }
 
-// DebugConfigHandler exposes active configuration values.
+// debugConfigSecretMarkers are parts of config keys whose values DebugConfigHandler hides.
+var debugConfigSecretMarkers = []string{"SECRET", "KEY", "PASSWORD", "TOKEN", "CREDENTIAL", "DSN"}
+
+// DebugConfigHandler exposes active configuration values. Values of keys that look sensitive
+// (e.g. JWT_SECRET, OIDC_CLIENT_SECRET, *_PASSWORD) are replaced with "[REDACTED]".
 func DebugConfigHandler(c *Context) error {
-	// Access config values directly (ConfigManager already has Get/Set)
-	// For security, ensure sensitive config values are not exposed in production.
-	// This example exposes all, but you'd filter in a real app.
 	configValues := make(map[string]string)
 	c.engine.Config.mu.RLock() // Access internal map safely
 	for k, v := range c.engine.Config.values {
+		if v != "" && debugConfigIsSecret(k) {
+			v = "[REDACTED]"
+		}
 		configValues[k] = v
 	}
 	c.engine.Config.mu.RUnlock()
 	return c.JSON(http.StatusOK, configValues)
 }
 
+// debugConfigIsSecret reports whether a config key names a sensitive value.
+func debugConfigIsSecret(key string) bool {
+	key = strings.ToUpper(key)
+	for _, marker := range debugConfigSecretMarkers {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
+
 // DebugMemoryHandler exposes current memory usage statistics.
 func DebugMemoryHandler(c *Context) error {
 	var m runtime.MemStats
@@ -0,0 +1,39 @@
+// go-swift/goswift/debug_test.go
+package goswift
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Sensitive configuration values never leave the server.
+func TestDebugConfigHandlerRedactsSecrets(t *testing.T) {
+	app := New()
+	app.Config.Set("JWT_SECRET", "s3cret")
+	app.Config.Set("oidc_client_secret", "s3cret")
+	app.Config.Set("SMTP_PASSWORD", "s3cret")
+	app.Config.Set("JWT_ISSUER", "quikdocs")
+	app.Config.Set("API_KEY", "")
+	app.GET("/debug/config", DebugConfigHandler).Handler()
+
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/config", nil))
+	var got map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("got %d %s: %v", rec.Code, rec.Body.String(), err)
+	}
+	want := map[string]string{
+		"JWT_SECRET":         "[REDACTED]",
+		"oidc_client_secret": "[REDACTED]",
+		"SMTP_PASSWORD":      "[REDACTED]",
+		"JWT_ISSUER":         "quikdocs",
+		"API_KEY":            "", // Shows that it is unset
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
@@ -2,6 +2,7 @@
 package goswift
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 )
@@ -34,6 +35,11 @@ func (e *HTTPError) Error() string {
 	return fmt.Sprintf("HTTP Error %d: %s", e.StatusCode, e.Message)
 }
 
+// Unwrap returns the original error, so errors.Is and errors.As see through HTTPErrors.
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
 // defaultErrorHandler is the default function for handling errors returned by handlers.
 // It sends an appropriate HTTP response based on the error type.
 func defaultErrorHandler(err error, c *Context) {
@@ -43,7 +49,7 @@ func defaultErrorHandler(err error, c *Context) {
 	if httpErr, ok := err.(*HTTPError); ok {
 		statusCode = httpErr.StatusCode
 		message = httpErr.Message
-		if httpErr.Err != nil {
+		if httpErr.Err != nil && !errors.Is(httpErr.Err, ErrNoCredentials) { // Missing credentials are routine
 			c.engine.Logger.Error("Handler error (HTTPError): %v", httpErr.Err)
 		}
 	} else {
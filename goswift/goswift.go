@@ -5,11 +5,13 @@ import (
 	"context" // For graceful shutdown
 	"fmt"     // For error messages
 	"io/fs"   // Corrected: Explicitly import io/fs for StaticFS signature
+	"net"
 	"net/http"
 	"os"     // For signal handling
 	"os/signal" // For signal handling
 	"path/filepath" // For Static file serving
 	"strings" // For Static file serving
+	"sync"
 	"syscall" // For signal handling
 	"time"
 )
@@ -32,6 +34,17 @@ type Engine struct {
 	errorHandler func(err error, c *Context)
 	// New: HTTP server instance for graceful shutdown
 	httpServer *http.Server
+	// Reverse proxies whose forwarding headers are trusted (see SetTrustedProxies)
+	trustedProxies  []*net.IPNet
+	forwardedHeader string // Header the trusted proxies append the client address to (see SetForwardedHeader)
+	// Cancelled when the server starts shutting down, ending long-lived streams (see Context.SSE)
+	shutdownCtx    context.Context
+	shutdownCancel context.CancelFunc
+	// Run during shutdown, after the server stopped accepting requests (see OnShutdown)
+	shutdownMu    sync.Mutex
+	shutdownHooks []func(ctx context.Context)
+	// Defaults for WebSocket connections opened with Context.Upgrade
+	WebSocket WebSocketConfig
 }
 
 // New creates and initializes a new GoSwift Engine.
@@ -52,6 +65,26 @@ func New() *Engine {
 	e.httpServer = &http.Server{
 		Handler: e, // The Engine itself implements http.Handler
 	}
+	e.shutdownCtx, e.shutdownCancel = context.WithCancel(context.Background())
+	e.httpServer.RegisterOnShutdown(e.shutdownCancel) // Shutdown waits for handlers, so end streams first
+	e.Logger.SetDebug(e.Config.Get("LOG_DEBUG") == "true")
+	// Session cookie and lifetime settings can come from configuration (see SessionConfigFromConfig)
+	if sessionConfig, err := SessionConfigFromConfig(e.Config); err != nil {
+		e.Logger.Error("Using default session settings: %v", err)
+	} else {
+		e.SessionMan.SetConfig(sessionConfig)
+	}
+	// Trusted proxies can be provided as a comma-separated list, e.g. TRUSTED_PROXIES=10.0.0.0/8
+	if proxies := e.Config.Get("TRUSTED_PROXIES"); proxies != "" {
+		if err := e.SetTrustedProxies(splitList(proxies)...); err != nil {
+			e.Logger.Error("Ignoring TRUSTED_PROXIES: %v", err)
+		}
+	}
+	if header := e.Config.Get("FORWARDED_HEADER"); header != "" {
+		if err := e.SetForwardedHeader(header); err != nil {
+			e.Logger.Error("Ignoring FORWARDED_HEADER: %v", err)
+		}
+	}
 	return e
 }
 
@@ -60,6 +93,15 @@ func (e *Engine) Use(mw MiddlewareFunc) {
 	e.middleware = append(e.middleware, mw)
 }
 
+// OnShutdown registers fn to run when Run shuts the server down, after it stopped accepting
+// requests and before the task queue stops. fn gets the shutdown deadline's context, e.g. for
+// draining long-lived connections that the HTTP server does not track.
+func (e *Engine) OnShutdown(fn func(ctx context.Context)) {
+	e.shutdownMu.Lock()
+	defer e.shutdownMu.Unlock()
+	e.shutdownHooks = append(e.shutdownHooks, fn)
+}
+
 // SetErrorHandler allows customizing the global error handling logic.
 func (e *Engine) SetErrorHandler(handler func(err error, c *Context)) {
 	e.errorHandler = handler
@@ -150,10 +192,13 @@ func (e *Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	c.engine = e // Provide access to the engine (for logger, config, session manager etc.)
 
 	// Find the handler and path parameters
-	handler, params := e.router.MatchRoute(r.Method, r.URL.Path)
+	handler, params, rt := e.router.match(r.Method, r.URL.Path)
 
 	// Set path parameters in the context
 	c.SetPathParams(params)
+	if rt != nil {
+		c.routeBodyLimit = rt.bodyLimit // Lets a global BodyLimit honour the route's override
+	}
 
 	// If no handler is found, return a 404 Not Found error
 	if handler == nil {
@@ -166,6 +211,10 @@ func (e *Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Execute the chained handler and handle any returned errors
 	if err := finalHandler(c); err != nil {
+		if c.Writer.hijacked { // After a WebSocket upgrade there is no HTTP response left to write
+			e.Logger.Error("Handler error after WebSocket upgrade on %s: %v", r.URL.Path, err)
+			return
+		}
 		e.errorHandler(err, c)
 	}
 }
@@ -179,7 +228,9 @@ func (e *Engine) Run(addr string) error {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM) // Listen for Ctrl+C and kill signals
 
+	done := make(chan struct{}) // Closed once shutdown completed, so Run returns only then
 	go func() {
+		defer close(done)
 		<-quit // Block until a signal is received
 		e.Logger.Info("Shutting down server...")
 
@@ -192,11 +243,22 @@ func (e *Engine) Run(addr string) error {
 			e.Logger.Error("Server shutdown failed: %v", err)
 		}
 
+		// Run shutdown hooks, e.g. draining WebSocket hubs
+		e.shutdownMu.Lock()
+		hooks := e.shutdownHooks
+		e.shutdownMu.Unlock()
+		for _, hook := range hooks {
+			hook(ctx)
+		}
+
 		// Shutdown the TaskQueue, waiting for ongoing tasks to complete
 		e.Logger.Info("Shutting down task queue...")
 		e.TaskQueue.Shutdown()
 		e.Logger.Info("Task queue shut down.")
 
+		// Stop background session cleanup
+		e.SessionMan.Close()
+
 		e.Logger.Info("Server exited gracefully.")
 	}()
 
@@ -204,6 +266,7 @@ func (e *Engine) Run(addr string) error {
 	if err := e.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("server failed to start: %w", err)
 	}
+	<-done
 	return nil
 }
 
@@ -217,6 +280,7 @@ type RouterGroup struct {
 	engine     *Engine
 	prefix     string
 	middleware []MiddlewareFunc
+	bodyLimit  int64 // Default body limit for routes in the group (0 = none)
 }
 
 // Group creates a new RouterGroup with the given prefix.
@@ -232,44 +296,46 @@ func (rg *RouterGroup) Use(mw MiddlewareFunc) {
 	rg.middleware = append(rg.middleware, mw)
 }
 
+// addRoute registers a route under the group prefix with the group's middleware and settings.
+func (rg *RouterGroup) addRoute(method, path string, handler HandlerFunc) *RouteBuilder {
+	rb := rg.engine.router.AddRoute(method, rg.prefix+path, handler).Before(rg.middleware...)
+	if rg.bodyLimit > 0 {
+		rb.BodyLimit(rg.bodyLimit)
+	}
+	return rb
+}
+
 // GET registers a GET route within the group.
 func (rg *RouterGroup) GET(path string, handler HandlerFunc) *RouteBuilder {
-	fullPath := rg.prefix + path
-	return rg.engine.router.AddRoute(http.MethodGet, fullPath, handler).Before(rg.middleware...)
+	return rg.addRoute(http.MethodGet, path, handler)
 }
 
 // POST registers a POST route within the group.
 func (rg *RouterGroup) POST(path string, handler HandlerFunc) *RouteBuilder {
-	fullPath := rg.prefix + path
-	return rg.engine.router.AddRoute(http.MethodPost, fullPath, handler).Before(rg.middleware...)
+	return rg.addRoute(http.MethodPost, path, handler)
 }
 
 // PUT registers a PUT route within the group.
 func (rg *RouterGroup) PUT(path string, handler HandlerFunc) *RouteBuilder {
-	fullPath := rg.prefix + path
-	return rg.engine.router.AddRoute(http.MethodPut, fullPath, handler).Before(rg.middleware...)
+	return rg.addRoute(http.MethodPut, path, handler)
 }
 
 // DELETE registers a DELETE route within the group.
 func (rg *RouterGroup) DELETE(path string, handler HandlerFunc) *RouteBuilder {
-	fullPath := rg.prefix + path
-	return rg.engine.router.AddRoute(http.MethodDelete, fullPath, handler).Before(rg.middleware...)
+	return rg.addRoute(http.MethodDelete, path, handler)
 }
 
 // PATCH registers a PATCH route within the group.
 func (rg *RouterGroup) PATCH(path string, handler HandlerFunc) *RouteBuilder {
-	fullPath := rg.prefix + path
-	return rg.engine.router.AddRoute(http.MethodPatch, fullPath, handler).Before(rg.middleware...)
+	return rg.addRoute(http.MethodPatch, path, handler)
 }
 
 // OPTIONS registers an OPTIONS route within the group.
 func (rg *RouterGroup) OPTIONS(path string, handler HandlerFunc) *RouteBuilder {
-	fullPath := rg.prefix + path
-	return rg.engine.router.AddRoute(http.MethodOptions, fullPath, handler).Before(rg.middleware...)
+	return rg.addRoute(http.MethodOptions, path, handler)
 }
 
 // HEAD registers a HEAD route within the group.
 func (rg *RouterGroup) HEAD(path string, handler HandlerFunc) *RouteBuilder {
-	fullPath := rg.prefix + path
-	return rg.engine.router.AddRoute(http.MethodHead, fullPath, handler).Before(rg.middleware...)
+	return rg.addRoute(http.MethodHead, path, handler)
 }
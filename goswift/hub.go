@@ -0,0 +1,525 @@
+// go-swift/goswift/hub.go
+package goswift
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHubClientClosed is returned when sending to a client that has disconnected.
+var ErrHubClientClosed = errors.New("hub client is closed")
+
+// HubMessage is a message received by a Hub: a JSON object with a "type" field, which selects
+// the handler.
+type HubMessage struct {
+	Type string
+	Data json.RawMessage // The whole message, including "type"
+}
+
+// Bind decodes the message into v.
+func (m HubMessage) Bind(v interface{}) error {
+	return json.Unmarshal(m.Data, v)
+}
+
+// HubHandlerFunc handles one type of message. Returned errors are reported to the sender as an
+// "error" message; HTTPError messages are shown as they are, other errors are logged and hidden.
+type HubHandlerFunc func(client *HubClient, msg HubMessage) error
+
+// HubMiddlewareFunc wraps message handlers, like MiddlewareFunc wraps HTTP handlers.
+type HubMiddlewareFunc func(next HubHandlerFunc) HubHandlerFunc
+
+// HubConfig controls a Hub. Zero values fall back to the defaults noted on each field.
+type HubConfig struct {
+	QueueSize    int           // Messages queued per client; a client that falls further behind is disconnected with 1013. 64 by default
+	DrainTimeout time.Duration // How long Shutdown lets clients receive their queued messages, 5s by default
+
+	OnConnect    func(client *HubClient) // Called after a client connected and joined its initial rooms
+	OnDisconnect func(client *HubClient) // Called after a client left all rooms
+
+	// OnCount, if set, is called whenever the number of clients in a room changes, and with
+	// room "" when the total changes; e.g. to update gauges. It runs under the hub's lock, so
+	// it must not call back into the Hub.
+	OnCount func(room string, count int)
+}
+
+// HubStats are a Hub's connection metrics.
+type HubStats struct {
+	Connections     int            `json:"connections"`
+	Rooms           map[string]int `json:"rooms"` // Clients per room
+	SlowDisconnects uint64         `json:"slow_disconnects"`
+}
+
+// Hub manages WebSocket clients in rooms. Each client's messages are dispatched by their "type"
+// to the handlers registered with Handle, in order, on the client's own goroutine. Messages to
+// clients go through a per-client queue, so a slow client never blocks others.
+type Hub struct {
+	config     HubConfig
+	logger     *Logger
+	handlers   map[string]HubHandlerFunc
+	middleware []HubMiddlewareFunc
+
+	mu       sync.RWMutex
+	clients  map[string]*HubClient            // map[clientID]*HubClient
+	rooms    map[string]map[string]*HubClient // map[room]map[clientID]*HubClient
+	draining bool
+
+	nextID          atomic.Uint64
+	slowDisconnects atomic.Uint64
+	writers         sync.WaitGroup // Client writer goroutines, awaited by Shutdown
+	shutdownOnce    sync.Once      // Registers Shutdown with the Engine on the first Serve
+}
+
+// NewHub creates a Hub that logs to logger. Register handlers before serving clients.
+func NewHub(config HubConfig, logger *Logger) *Hub {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 64
+	}
+	if config.DrainTimeout <= 0 {
+		config.DrainTimeout = 5 * time.Second
+	}
+	return &Hub{
+		config:   config,
+		logger:   logger,
+		handlers: make(map[string]HubHandlerFunc),
+		clients:  make(map[string]*HubClient),
+		rooms:    make(map[string]map[string]*HubClient),
+	}
+}
+
+// Handle registers the handler for a message type.
+func (h *Hub) Handle(msgType string, handler HubHandlerFunc) {
+	h.handlers[msgType] = handler
+}
+
+// Use adds middleware that wraps every message handler.
+func (h *Hub) Use(mw HubMiddlewareFunc) {
+	h.middleware = append(h.middleware, mw)
+}
+
+// Serve upgrades the request to a WebSocket, adds the client to the given rooms and dispatches
+// its messages until it disconnects. It blocks, so handlers should return its result:
+//
+//	app.GET("/api/docs/:id/ws", func(c *goswift.Context) error {
+//		return hub.Serve(c, "doc:"+c.Param("id"))
+//	}).Before(authMiddleware).Handler()
+//
+// The first Serve registers Shutdown with the Engine, so clients are drained when it shuts down.
+func (h *Hub) Serve(c *Context, rooms ...string) error {
+	if c.engine != nil {
+		h.shutdownOnce.Do(func() { c.engine.OnShutdown(func(ctx context.Context) { h.Shutdown(ctx) }) })
+	}
+	h.mu.RLock()
+	draining := h.draining
+	h.mu.RUnlock()
+	if draining {
+		return NewHTTPError(http.StatusServiceUnavailable, "Server is shutting down")
+	}
+
+	var config WebSocketConfig
+	if c.engine != nil {
+		config = c.engine.WebSocket
+	}
+	ws, err := c.upgrade(config, false) // Shutdown closes the connection after draining
+	if err != nil {
+		return err
+	}
+	userID, _ := c.UserID()
+	client := &HubClient{
+		ID:     strconv.FormatUint(h.nextID.Add(1), 10),
+		UserID: userID,
+		hub:    h,
+		ws:     ws,
+		ctx:    c,
+		rooms:  make(map[string]struct{}),
+		send:   make(chan []byte, h.config.QueueSize),
+		done:   make(chan struct{}),
+	}
+	if !h.add(client, rooms) {
+		ws.Close(WebSocketCloseGoingAway, "server shutting down")
+		return nil
+	}
+	go client.writeLoop()
+	if h.config.OnConnect != nil {
+		h.config.OnConnect(client)
+	}
+
+	client.readLoop()
+
+	h.remove(client)
+	client.close(0, "") // Nothing more to send; the connection is gone already
+	<-client.done
+	if h.config.OnDisconnect != nil {
+		h.config.OnDisconnect(client)
+	}
+	return nil
+}
+
+// add registers a client and joins its initial rooms; the caller starts its writer. It reports
+// false while draining.
+func (h *Hub) add(client *HubClient, rooms []string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.draining {
+		return false
+	}
+	h.clients[client.ID] = client
+	h.writers.Add(1) // Under the lock, so Shutdown cannot be waiting yet
+	h.countLocked("", len(h.clients))
+	for _, room := range rooms {
+		h.joinLocked(client, room)
+	}
+	h.logger.Info("Hub: Client %s (user %s) connected to rooms %v", client.ID, client.UserID, rooms)
+	return true
+}
+
+// remove unregisters a client and takes it out of all its rooms.
+func (h *Hub) remove(client *HubClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[client.ID]; !ok {
+		return
+	}
+	for room := range client.rooms {
+		h.leaveLocked(client, room)
+	}
+	delete(h.clients, client.ID)
+	h.countLocked("", len(h.clients))
+	h.logger.Info("Hub: Client %s (user %s) disconnected", client.ID, client.UserID)
+}
+
+// joinLocked adds a client to a room.
+func (h *Hub) joinLocked(client *HubClient, room string) {
+	if _, ok := client.rooms[room]; ok {
+		return
+	}
+	if h.rooms[room] == nil {
+		h.rooms[room] = make(map[string]*HubClient)
+	}
+	h.rooms[room][client.ID] = client
+	client.rooms[room] = struct{}{}
+	h.countLocked(room, len(h.rooms[room]))
+}
+
+// leaveLocked removes a client from a room.
+func (h *Hub) leaveLocked(client *HubClient, room string) {
+	if _, ok := client.rooms[room]; !ok {
+		return
+	}
+	delete(h.rooms[room], client.ID)
+	delete(client.rooms, room)
+	count := len(h.rooms[room])
+	if count == 0 {
+		delete(h.rooms, room)
+	}
+	h.countLocked(room, count)
+}
+
+// countLocked reports a changed count to OnCount.
+func (h *Hub) countLocked(room string, count int) {
+	if h.config.OnCount != nil {
+		h.config.OnCount(room, count)
+	}
+}
+
+// dispatch runs the handler for a message, reporting failures to the sender.
+func (h *Hub) dispatch(client *HubClient, data []byte) {
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.Type == "" {
+		client.sendError("", NewHTTPError(http.StatusBadRequest, "Messages must be JSON objects with a type"))
+		return
+	}
+	handler, ok := h.handlers[envelope.Type]
+	if !ok {
+		client.sendError(envelope.Type, NewHTTPError(http.StatusNotFound, "Unknown message type"))
+		return
+	}
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		handler = h.middleware[i](handler)
+	}
+	if err := handler(client, HubMessage{Type: envelope.Type, Data: data}); err != nil {
+		client.sendError(envelope.Type, err)
+	}
+}
+
+// Broadcast sends v, encoded as JSON, to every client in a room.
+func (h *Hub) Broadcast(room string, v interface{}) error {
+	return h.BroadcastExcept(room, nil, v)
+}
+
+// BroadcastExcept sends v, encoded as JSON, to every client in a room except one, typically the
+// sender of the message being relayed.
+func (h *Hub) BroadcastExcept(room string, except *HubClient, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	h.mu.RLock()
+	targets := make([]*HubClient, 0, len(h.rooms[room]))
+	for _, client := range h.rooms[room] {
+		if client != except {
+			targets = append(targets, client)
+		}
+	}
+	h.mu.RUnlock()
+	for _, client := range targets {
+		client.enqueue(data) // Slow clients are disconnected; the others still get the message
+	}
+	return nil
+}
+
+// CloseRoom closes the connections of every client in a room, e.g. once its document was deleted.
+func (h *Hub) CloseRoom(room string, code int, reason string) {
+	for _, client := range h.Clients(room) {
+		client.close(code, reason)
+	}
+}
+
+// DisconnectUser closes a user's connections to a room, e.g. after their access was revoked.
+func (h *Hub) DisconnectUser(room, userID string, code int, reason string) {
+	for _, client := range h.Clients(room) {
+		if client.UserID == userID {
+			client.close(code, reason)
+		}
+	}
+}
+
+// Clients returns the clients in a room.
+func (h *Hub) Clients(room string) []*HubClient {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	clients := make([]*HubClient, 0, len(h.rooms[room]))
+	for _, client := range h.rooms[room] {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients
+}
+
+// Stats returns the number of clients overall and per room.
+func (h *Hub) Stats() HubStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	stats := HubStats{
+		Connections:     len(h.clients),
+		Rooms:           make(map[string]int, len(h.rooms)),
+		SlowDisconnects: h.slowDisconnects.Load(),
+	}
+	for room, clients := range h.rooms {
+		stats.Rooms[room] = len(clients)
+	}
+	return stats
+}
+
+// StatsHandler serves Stats as JSON, e.g. on a debug route.
+func (h *Hub) StatsHandler(c *Context) error {
+	return c.JSON(http.StatusOK, h.Stats())
+}
+
+// Shutdown stops accepting clients, lets connected clients receive their queued messages for up
+// to DrainTimeout (or until ctx ends), then closes them with 1001 (going away).
+func (h *Hub) Shutdown(ctx context.Context) {
+	h.mu.Lock()
+	h.draining = true
+	clients := make([]*HubClient, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.Unlock()
+	if len(clients) == 0 {
+		return
+	}
+	h.logger.Info("Hub: Draining %d clients", len(clients))
+
+	for _, client := range clients {
+		client.close(WebSocketCloseGoingAway, "server shutting down")
+	}
+	drained := make(chan struct{})
+	go func() {
+		h.writers.Wait()
+		close(drained)
+	}()
+	ctx, cancel := context.WithTimeout(ctx, h.config.DrainTimeout)
+	defer cancel()
+	select {
+	case <-drained:
+		h.logger.Info("Hub: All clients drained")
+	case <-ctx.Done():
+		h.logger.Warning("Hub: Drain timed out; closing remaining connections")
+		for _, client := range clients {
+			client.ws.closeConn()
+		}
+	}
+}
+
+// --- Client ---
+
+// HubClient is a WebSocket client of a Hub.
+type HubClient struct {
+	ID     string
+	UserID string // From the handshake request's authentication, if any
+
+	hub   *Hub
+	ws    *WebSocketConn
+	ctx   *Context            // The handshake request's context, for Get and Set
+	rooms map[string]struct{} // Guarded by Hub.mu
+
+	mu          sync.Mutex
+	send        chan []byte // Closed by close; the writer then flushes it (unless abandoned) and closes the connection
+	closed      bool
+	abandon     bool // Drop queued messages instead of flushing them (slow clients)
+	closeCode   int  // Close frame to send after the queue; 0 if the connection is already gone
+	closeReason string
+	done        chan struct{} // Closed when the writer has finished
+}
+
+// Send queues v, encoded as JSON, for the client.
+func (cl *HubClient) Send(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return cl.enqueue(data)
+}
+
+// Join adds the client to a room.
+func (cl *HubClient) Join(room string) {
+	cl.hub.mu.Lock()
+	defer cl.hub.mu.Unlock()
+	if _, ok := cl.hub.clients[cl.ID]; ok { // Not after it disconnected
+		cl.hub.joinLocked(cl, room)
+	}
+}
+
+// Leave removes the client from a room.
+func (cl *HubClient) Leave(room string) {
+	cl.hub.mu.Lock()
+	defer cl.hub.mu.Unlock()
+	cl.hub.leaveLocked(cl, room)
+}
+
+// Rooms returns the rooms the client is in.
+func (cl *HubClient) Rooms() []string {
+	cl.hub.mu.RLock()
+	defer cl.hub.mu.RUnlock()
+	rooms := make([]string, 0, len(cl.rooms))
+	for room := range cl.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Get returns a value stored on the handshake request's Context, e.g. by auth middleware.
+func (cl *HubClient) Get(key string) (interface{}, bool) {
+	return cl.ctx.Get(key)
+}
+
+// Set stores a value for the client's lifetime.
+func (cl *HubClient) Set(key string, value interface{}) {
+	cl.ctx.Set(key, value)
+}
+
+// Context returns a context that is cancelled once the client's connection is closed.
+func (cl *HubClient) Context() context.Context {
+	return cl.ws.Context()
+}
+
+// Close sends the client its queued messages, then closes the connection with code and reason.
+func (cl *HubClient) Close(code int, reason string) {
+	cl.close(code, reason)
+}
+
+// enqueue queues an encoded message. If the queue is full the client is disconnected with
+// 1013 (try again later); it can reconnect and catch up from a fresh state.
+func (cl *HubClient) enqueue(data []byte) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.closed {
+		return ErrHubClientClosed
+	}
+	select {
+	case cl.send <- data:
+		return nil
+	default:
+	}
+	cl.hub.slowDisconnects.Add(1)
+	cl.hub.logger.Warning("Hub: Disconnecting slow client %s (user %s), %d messages behind", cl.ID, cl.UserID, cap(cl.send))
+	cl.abandon = true
+	cl.closeLocked(WebSocketCloseTryAgainLater, "too slow")
+	return ErrHubClientClosed
+}
+
+// close stops queueing. The writer sends what is queued, then a close frame with code, or just
+// stops if code is 0.
+func (cl *HubClient) close(code int, reason string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.closeLocked(code, reason)
+}
+
+func (cl *HubClient) closeLocked(code int, reason string) {
+	if cl.closed {
+		return
+	}
+	cl.closed = true
+	cl.closeCode, cl.closeReason = code, reason
+	close(cl.send)
+}
+
+// sendError reports a failed message to the client.
+func (cl *HubClient) sendError(msgType string, err error) {
+	message := "Internal error"
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		message = httpErr.Message
+	} else {
+		cl.hub.logger.Error("Hub: Handler for %q from client %s failed: %v", msgType, cl.ID, err)
+	}
+	cl.Send(map[string]string{"type": "error", "for": msgType, "error": message})
+}
+
+// readLoop dispatches the client's messages until its connection closes.
+func (cl *HubClient) readLoop() {
+	for {
+		_, data, err := cl.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		cl.hub.dispatch(cl, data)
+	}
+}
+
+// writeLoop sends queued messages until the queue is closed, then closes the connection.
+func (cl *HubClient) writeLoop() {
+	defer cl.hub.writers.Done()
+	defer close(cl.done)
+	for data := range cl.send {
+		cl.mu.Lock()
+		abandon := cl.abandon
+		cl.mu.Unlock()
+		if abandon {
+			break
+		}
+		if err := cl.ws.WriteMessage(WebSocketText, data); err != nil {
+			cl.ws.closeConn()
+			return
+		}
+	}
+	cl.mu.Lock()
+	code, reason := cl.closeCode, cl.closeReason
+	cl.mu.Unlock()
+	if code == 0 {
+		cl.ws.closeConn()
+		return
+	}
+	cl.ws.Close(code, reason)
+}
@@ -0,0 +1,255 @@
+// go-swift/goswift/hub_test.go
+package goswift
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// pipeResponseWriter hands the server end of a net.Pipe to a WebSocket upgrade.
+type pipeResponseWriter struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (w *pipeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn)), nil
+}
+
+// hubTestClient is the client end of a Hub connection.
+type hubTestClient struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	client *HubClient // The hub's side of the connection
+}
+
+// hubTestEngine serves hub on /ws?user=<userID>&room=<room>, reporting each connected client.
+func hubTestEngine(hub *Hub) (*Engine, <-chan *HubClient) {
+	connected := make(chan *HubClient, 8)
+	hub.config.OnConnect = func(client *HubClient) { connected <- client }
+	app := New()
+	app.GET("/ws", func(c *Context) error {
+		c.Set("userID", c.Query("user"))
+		return hub.Serve(c, c.Query("room"))
+	}).Handler()
+	return app, connected
+}
+
+// dialHub connects to app's hub as userID in room.
+func dialHub(t *testing.T, app *Engine, connected <-chan *HubClient, userID, room string) *hubTestClient {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	req := httptest.NewRequest(http.MethodGet, "/ws?user="+userID+"&room="+room, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	go app.ServeHTTP(&pipeResponseWriter{ResponseRecorder: httptest.NewRecorder(), conn: server}, req)
+
+	r := bufio.NewReader(client)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: got %d, want 101", resp.StatusCode)
+	}
+	select {
+	case hubClient := <-connected:
+		return &hubTestClient{t: t, conn: client, r: r, client: hubClient}
+	case <-time.After(2 * time.Second):
+		t.Fatal("client did not connect")
+		return nil
+	}
+}
+
+// next reads the next frame the server sent.
+func (tc *hubTestClient) next() wsServerFrame {
+	tc.t.Helper()
+	tc.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var header [2]byte
+	if _, err := io.ReadFull(tc.r, header[:]); err != nil {
+		tc.t.Fatalf("reading frame: %v", err)
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(tc.r, ext[:]); err != nil {
+			tc.t.Fatalf("reading frame: %v", err)
+		}
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(tc.r, payload); err != nil {
+		tc.t.Fatalf("reading frame: %v", err)
+	}
+	return wsServerFrame{fin: header[0]&wsTestFin != 0, opcode: header[0] & 0x0F, payload: string(payload)}
+}
+
+// nextText reads the next frame and checks that it is a text message.
+func (tc *hubTestClient) nextText() string {
+	tc.t.Helper()
+	frame := tc.next()
+	if frame.opcode != wsOpText {
+		tc.t.Fatalf("got frame %+v, want a text message", frame)
+	}
+	return frame.payload
+}
+
+// expectClose reads the next frame, checks that it closes with code, and confirms the close.
+func (tc *hubTestClient) expectClose(code int) {
+	tc.t.Helper()
+	frame := tc.next()
+	if frame.opcode != wsOpClose || len(frame.payload) < 2 || int(binary.BigEndian.Uint16([]byte(frame.payload))) != code {
+		tc.t.Fatalf("got frame %+v, want a close with code %d", frame, code)
+	}
+	tc.conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	tc.conn.Write(wsCloseFrame(code, ""))
+}
+
+// send sends a text message to the hub.
+func (tc *hubTestClient) send(text string) {
+	tc.t.Helper()
+	tc.conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	if _, err := tc.conn.Write(wsClientFrame(wsTestFin|wsOpText, text)); err != nil {
+		tc.t.Fatal(err)
+	}
+}
+
+// waitForHubClients waits until room has n clients.
+func waitForHubClients(t *testing.T, hub *Hub, room string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(hub.Clients(room)) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("room %s has %d clients, want %d", room, len(hub.Clients(room)), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// A relayed message reaches the other clients in the room, but not its sender or other rooms.
+func TestHubBroadcastExcept(t *testing.T) {
+	hub := NewHub(HubConfig{}, NewLogger())
+	hub.Handle("chat", func(client *HubClient, msg HubMessage) error {
+		return hub.BroadcastExcept("doc:1", client, msg.Data)
+	})
+	app, connected := hubTestEngine(hub)
+	alice := dialHub(t, app, connected, "alice", "doc:1")
+	bob := dialHub(t, app, connected, "bob", "doc:1")
+	carol := dialHub(t, app, connected, "carol", "doc:2")
+
+	alice.send(`{"type":"chat","text":"hi"}`)
+	if got := bob.nextText(); got != `{"type":"chat","text":"hi"}` {
+		t.Fatalf("bob got %s", got)
+	}
+
+	// Each client's next message is the marker, so nothing else was sent to it before
+	hub.Broadcast("doc:1", "marker")
+	hub.Broadcast("doc:2", "marker")
+	for name, tc := range map[string]*hubTestClient{"alice": alice, "bob": bob, "carol": carol} {
+		if got := tc.nextText(); got != `"marker"` {
+			t.Errorf("%s got %s, want the marker", name, got)
+		}
+	}
+
+	if err := hub.BroadcastExcept("doc:1", alice.client, make(chan int)); err == nil {
+		t.Error("unencodable message accepted")
+	}
+}
+
+// A client whose queue overflows is closed with 1013 and its backlog dropped; others in the
+// room keep receiving.
+func TestHubSlowClient(t *testing.T) {
+	hub := NewHub(HubConfig{QueueSize: 2}, NewLogger())
+	app, connected := hubTestEngine(hub)
+	fast := dialHub(t, app, connected, "fast", "doc:1")
+	slow := dialHub(t, app, connected, "slow", "doc:1")
+
+	// The slow client's writer blocks on message 1, messages 2 and 3 fill its queue and
+	// message 4 overflows it
+	for i := 1; i <= 5; i++ {
+		hub.Broadcast("doc:1", i)
+		if got := fast.nextText(); got != strconv.Itoa(i) {
+			t.Fatalf("fast client got %s, want %d", got, i)
+		}
+		for i == 1 && len(slow.client.send) > 0 { // Until the writer has taken message 1
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if got := hub.Stats().SlowDisconnects; got != 1 {
+		t.Fatalf("SlowDisconnects = %d, want 1", got)
+	}
+	if err := slow.client.Send("late"); err != ErrHubClientClosed {
+		t.Fatalf("Send to a disconnected client: got %v, want ErrHubClientClosed", err)
+	}
+
+	if got := slow.nextText(); got != "1" {
+		t.Fatalf("slow client got %s, want 1", got)
+	}
+	slow.expectClose(WebSocketCloseTryAgainLater)
+	waitForHubClients(t, hub, "doc:1", 1)
+}
+
+// Shutdown lets clients receive their queued messages before closing them with 1001, and
+// refuses new clients.
+func TestHubShutdownDrains(t *testing.T) {
+	hub := NewHub(HubConfig{DrainTimeout: 5 * time.Second}, NewLogger())
+	app, connected := hubTestEngine(hub)
+	client := dialHub(t, app, connected, "alice", "doc:1")
+
+	hub.Broadcast("doc:1", "first")
+	hub.Broadcast("doc:1", "second")
+	done := make(chan struct{})
+	go func() {
+		hub.Shutdown(context.Background())
+		close(done)
+	}()
+
+	for _, want := range []string{`"first"`, `"second"`} {
+		if got := client.nextText(); got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	}
+	client.expectClose(WebSocketCloseGoingAway)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown did not return once the client was drained")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ws?user=bob&room=doc:1", nil)
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("connecting while draining: got %d, want 503", rec.Code)
+	}
+}
+
+// A client that does not read during the drain is cut off after DrainTimeout.
+func TestHubShutdownDrainTimeout(t *testing.T) {
+	hub := NewHub(HubConfig{DrainTimeout: 100 * time.Millisecond}, NewLogger())
+	app, connected := hubTestEngine(hub)
+	client := dialHub(t, app, connected, "alice", "doc:1")
+	hub.Broadcast("doc:1", "unread")
+
+	start := time.Now()
+	hub.Shutdown(context.Background())
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Shutdown took %v", elapsed)
+	}
+	client.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(client.r); err != nil {
+		t.Fatalf("connection not closed: %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+// go-swift/goswift/idempotency.go
+package goswift
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the request header carrying the client-chosen idempotency key.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyMaxBody is how much of a request body Idempotency buffers on routes without
+// their own BodyLimit. Larger bodies get 413 Request Entity Too Large.
+const DefaultIdempotencyMaxBody = 1 << 20
+
+// IdempotencyRecord is the state stored for an idempotency key.
+type IdempotencyRecord struct {
+	Fingerprint string // Hash of method, path and body of the first request
+	Completed   bool   // False while the first request is still being processed
+	StatusCode  int
+	Header      http.Header
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// IdempotencyStore persists idempotency records.
+// Implementations must make Reserve atomic so concurrent duplicates are detected.
+type IdempotencyStore interface {
+	// Reserve claims key for a new request. If the key is already known, the existing
+	// record is returned and reserved is false.
+	Reserve(key, fingerprint string, ttl time.Duration) (existing *IdempotencyRecord, reserved bool, err error)
+	// Complete stores the final response for a previously reserved key.
+	Complete(key string, record *IdempotencyRecord, ttl time.Duration) error
+	// Release drops a reservation so the request can be retried (e.g. after a server error).
+	Release(key string) error
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore. Expired records are evicted lazily.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]*IdempotencyRecord
+	lastSweep time.Time
+}
+
+// NewMemoryIdempotencyStore creates an empty in-memory idempotency store.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records:   make(map[string]*IdempotencyRecord),
+		lastSweep: time.Now(),
+	}
+}
+
+// Reserve implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Reserve(key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute { // Evict expired records at most once a minute
+		for k, rec := range s.records {
+			if now.After(rec.ExpiresAt) {
+				delete(s.records, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if rec, ok := s.records[key]; ok && now.Before(rec.ExpiresAt) {
+		copied := *rec
+		return &copied, false, nil
+	}
+	s.records[key] = &IdempotencyRecord{Fingerprint: fingerprint, ExpiresAt: now.Add(ttl)}
+	return nil, true, nil
+}
+
+// Complete implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Complete(key string, record *IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *record
+	copied.Completed = true
+	copied.ExpiresAt = time.Now().Add(ttl)
+	s.records[key] = &copied
+	return nil
+}
+
+// Release implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// captureWriter tees the response body so it can be stored for replay.
+type captureWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Idempotency makes POST and PATCH requests carrying an Idempotency-Key header safe to retry.
+// Keys are scoped to the authenticated user ("userID" in the context), so place it after auth middleware.
+// The first successful response (status < 500) is stored for ttl and replayed for repeated keys;
+// a duplicate arriving while the first is still running gets 409 Conflict, and a key reused
+// with a different payload gets 422 Unprocessable Entity. Errors returned by the handler are
+// not stored, so the client may retry them.
+//
+// The body is buffered to fingerprint it, up to the route's BodyLimit or DefaultIdempotencyMaxBody.
+func Idempotency(store IdempotencyStore, ttl time.Duration) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) (err error) {
+			key := c.Request.Header.Get(IdempotencyKeyHeader)
+			if key == "" || (c.Request.Method != http.MethodPost && c.Request.Method != http.MethodPatch) {
+				return next(c)
+			}
+			if len(key) > 255 {
+				return NewHTTPError(http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			}
+
+			var payload []byte
+			if c.Request.Body != nil {
+				limit := c.routeBodyLimit
+				if limit <= 0 {
+					limit = DefaultIdempotencyMaxBody
+				}
+				if c.Request.ContentLength > limit {
+					c.Request.Close = true
+					return NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the %d byte limit", limit))
+				}
+				payload, err = io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) { // Ours, or a smaller global BodyLimit's
+					return NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the %d byte limit", maxErr.Limit), err)
+				}
+				if err != nil {
+					return NewHTTPError(http.StatusBadRequest, "Failed to read request body", err)
+				}
+				c.Request.Body = io.NopCloser(bytes.NewReader(payload)) // Let the handler read it again
+				c.rawBody = c.Request.Body                              // A route BodyLimit after this wraps the copy, not the drained original
+			}
+
+			userID, _ := c.Get("userID")
+			storeKey, _ := userID.(string)
+			storeKey += "\x00" + key
+			sum := sha256.Sum256([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n" + string(payload)))
+			fingerprint := hex.EncodeToString(sum[:])
+
+			existing, reserved, err := store.Reserve(storeKey, fingerprint, ttl)
+			if err != nil {
+				return NewHTTPError(http.StatusInternalServerError, "Internal Server Error", err)
+			}
+			if !reserved {
+				switch {
+				case existing.Fingerprint != fingerprint:
+					return NewHTTPError(http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+				case !existing.Completed:
+					return NewHTTPError(http.StatusConflict, "A request with this Idempotency-Key is already in progress")
+				}
+				return replayIdempotentResponse(c, existing)
+			}
+
+			// Release the key if the handler panics, then let RecoveryMiddleware deal with it
+			defer func() {
+				if r := recover(); r != nil {
+					store.Release(storeKey)
+					panic(r)
+				}
+			}()
+
+			capture := &captureWriter{ResponseWriter: c.Writer.ResponseWriter}
+			c.Writer.ResponseWriter = capture
+			err = next(c)
+			c.Writer.ResponseWriter = capture.ResponseWriter
+
+			if err != nil || c.Status() >= http.StatusInternalServerError {
+				if relErr := store.Release(storeKey); relErr != nil {
+					c.engine.Logger.Error("Idempotency: failed to release key: %v", relErr)
+				}
+				return err
+			}
+
+			record := &IdempotencyRecord{
+				Fingerprint: fingerprint,
+				StatusCode:  c.Status(),
+				Header:      c.Writer.Header().Clone(),
+				Body:        capture.body.Bytes(),
+			}
+			if err := store.Complete(storeKey, record, ttl); err != nil {
+				c.engine.Logger.Error("Idempotency: failed to store response: %v", err)
+			}
+			return nil
+		}
+	}
+}
+
+// replayIdempotentResponse writes a stored response back to the client.
+func replayIdempotentResponse(c *Context, rec *IdempotencyRecord) error {
+	header := c.Writer.Header()
+	for name, values := range rec.Header {
+		if name == "X-Request-Id" || name == "X-Trace-Id" {
+			continue // Keep the identifiers of the current request
+		}
+		header[name] = append([]string(nil), values...)
+	}
+	header.Set("Idempotent-Replayed", "true")
+	c.Writer.WriteHeader(rec.StatusCode)
+	_, err := c.Writer.Write(rec.Body)
+	return err
+}
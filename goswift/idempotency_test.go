@@ -0,0 +1,153 @@
+// go-swift/goswift/idempotency_test.go
+package goswift
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A route BodyLimit after Idempotency must still see the body Idempotency buffered.
+func TestIdempotencyKeepsBodyForRouteBodyLimit(t *testing.T) {
+	app := New()
+	app.Use(BodyLimit(1 << 10))
+	app.POST("/docs", func(c *Context) error {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusCreated, "got:"+string(body))
+	}).Before(Idempotency(NewMemoryIdempotencyStore(), time.Hour)).BodyLimit(1 << 20).Handler()
+
+	for i, want := range []string{"got:hello", "got:hello"} { // The second request is replayed
+		req := httptest.NewRequest(http.MethodPost, "/docs", strings.NewReader("hello"))
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+		if rec.Code != http.StatusCreated || rec.Body.String() != want {
+			t.Fatalf("request %d: got %d %q, want 201 %q", i, rec.Code, rec.Body.String(), want)
+		}
+	}
+}
+
+// The route limit still applies to the buffered body.
+func TestIdempotencyRouteBodyLimitStillEnforced(t *testing.T) {
+	app := New()
+	app.POST("/docs", func(c *Context) error {
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusCreated)
+	}).Before(Idempotency(NewMemoryIdempotencyStore(), time.Hour)).BodyLimit(4).Handler()
+
+	req := httptest.NewRequest(http.MethodPost, "/docs", strings.NewReader("too long"))
+	req.ContentLength = -1 // Unknown length, so the limit is hit while reading
+	req.Header.Set(IdempotencyKeyHeader, "key-1")
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got %d, want 413", rec.Code)
+	}
+}
+
+// idempotencyTestEngine serves POST /docs through Idempotency; the handler signals on started
+// and waits for release before answering, if those are set.
+func idempotencyTestEngine(calls *int, started chan<- struct{}, release <-chan struct{}) *Engine {
+	app := New()
+	app.POST("/docs", func(c *Context) error {
+		*calls++
+		if started != nil {
+			started <- struct{}{}
+			<-release
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return err
+		}
+		c.Writer.Header().Set("Location", "/docs/1")
+		return c.String(http.StatusCreated, "created:"+string(body))
+	}).Before(Idempotency(NewMemoryIdempotencyStore(), time.Hour)).Handler()
+	return app
+}
+
+// idempotentPost sends POST /docs with the given key and body.
+func idempotentPost(app *Engine, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/docs", strings.NewReader(body))
+	req.Header.Set(IdempotencyKeyHeader, key)
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	return rec
+}
+
+// A repeated key replays the stored response without running the handler again; a key reused
+// with another payload is rejected.
+func TestIdempotencyReplay(t *testing.T) {
+	calls := 0
+	app := idempotencyTestEngine(&calls, nil, nil)
+
+	first := idempotentPost(app, "key-1", "a")
+	replayed := idempotentPost(app, "key-1", "a")
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls)
+	}
+	if replayed.Code != http.StatusCreated || replayed.Body.String() != first.Body.String() || replayed.Header().Get("Location") != "/docs/1" {
+		t.Fatalf("replay: got %d %q %v, want the first response %d %q", replayed.Code, replayed.Body.String(), replayed.Header(), first.Code, first.Body.String())
+	}
+	if replayed.Header().Get("Idempotent-Replayed") != "true" || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatal("only the replay should carry Idempotent-Replayed")
+	}
+
+	if rec := idempotentPost(app, "key-1", "b"); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("same key, different payload: got %d, want 422", rec.Code)
+	}
+	if rec := idempotentPost(app, "key-2", "b"); rec.Code != http.StatusCreated || calls != 2 {
+		t.Fatalf("new key: got %d after %d calls, want 201 from a second call", rec.Code, calls)
+	}
+}
+
+// A duplicate arriving while the first request is still running gets 409; once the first
+// completes, the duplicate is replayed.
+func TestIdempotencyConcurrentDuplicate(t *testing.T) {
+	calls := 0
+	started, release := make(chan struct{}), make(chan struct{})
+	app := idempotencyTestEngine(&calls, started, release)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- idempotentPost(app, "key-1", "a") }()
+	<-started
+	if rec := idempotentPost(app, "key-1", "a"); rec.Code != http.StatusConflict {
+		t.Fatalf("in-flight duplicate: got %d, want 409", rec.Code)
+	}
+	close(release)
+	if rec := <-done; rec.Code != http.StatusCreated {
+		t.Fatalf("first request: got %d, want 201", rec.Code)
+	}
+	if rec := idempotentPost(app, "key-1", "a"); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("after completion: got %d, want a replayed 201", rec.Code)
+	}
+}
+
+// Without a route BodyLimit the buffered body is capped at DefaultIdempotencyMaxBody.
+func TestIdempotencyDefaultBodyCap(t *testing.T) {
+	calls := 0
+	app := idempotencyTestEngine(&calls, nil, nil)
+	for _, length := range []int64{DefaultIdempotencyMaxBody + 1, -1} { // Announced, and found while reading
+		req := httptest.NewRequest(http.MethodPost, "/docs", strings.NewReader(strings.Repeat("x", DefaultIdempotencyMaxBody+1)))
+		req.ContentLength = length
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("Content-Length %d: got %d, want 413", length, rec.Code)
+		}
+	}
+	if calls != 0 {
+		t.Fatalf("handler ran %d times for oversized bodies", calls)
+	}
+	if rec := idempotentPost(app, "key-1", strings.Repeat("x", DefaultIdempotencyMaxBody)); rec.Code != http.StatusCreated {
+		t.Fatalf("body at the cap: got %d, want 201", rec.Code)
+	}
+}
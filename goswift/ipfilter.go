@@ -0,0 +1,113 @@
+// go-swift/goswift/ipfilter.go
+package goswift
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ipFilter holds the parsed allow/deny lists used by the IPFilter middleware.
+type ipFilter struct {
+	mu    sync.RWMutex
+	allow []*net.IPNet
+	deny  []*net.IPNet
+
+	// Optional ConfigManager source; lists are re-parsed when the values change.
+	config    *ConfigManager
+	allowKey  string
+	denyKey   string
+	allowSpec string
+	denySpec  string
+}
+
+// IPFilter restricts access based on the client IP (as resolved by c.RealIP()).
+// Deny entries always win; if the allow list is non-empty, the client must match it.
+// Entries may be CIDR ranges or bare IPs. It panics on an invalid entry, like route registration does.
+func IPFilter(allow, deny []string) MiddlewareFunc {
+	f := &ipFilter{}
+	if err := f.set(allow, deny); err != nil {
+		panic(fmt.Sprintf("IPFilter: %v", err))
+	}
+	return f.middleware()
+}
+
+// IPFilterFromConfig is like IPFilter but reads comma-separated allow and deny lists
+// from the ConfigManager (or environment) under the given keys.
+// The lists are reloaded whenever the configured values change; an invalid update is
+// logged and the previous lists are kept.
+func IPFilterFromConfig(cm *ConfigManager, allowKey, denyKey string) MiddlewareFunc {
+	f := &ipFilter{config: cm, allowKey: allowKey, denyKey: denyKey}
+	f.allowSpec, f.denySpec = cm.Get(allowKey), cm.Get(denyKey)
+	if err := f.set(splitList(f.allowSpec), splitList(f.denySpec)); err != nil {
+		panic(fmt.Sprintf("IPFilter: %v", err))
+	}
+	return f.middleware()
+}
+
+// set replaces the allow and deny lists.
+func (f *ipFilter) set(allow, deny []string) error {
+	allowNets, err := parseCIDRs(allow)
+	if err != nil {
+		return fmt.Errorf("allow list: %w", err)
+	}
+	denyNets, err := parseCIDRs(deny)
+	if err != nil {
+		return fmt.Errorf("deny list: %w", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.allow, f.deny = allowNets, denyNets
+	return nil
+}
+
+// reload re-reads the lists from the ConfigManager if their values have changed.
+func (f *ipFilter) reload(logger *Logger) {
+	allowSpec, denySpec := f.config.Get(f.allowKey), f.config.Get(f.denyKey)
+	f.mu.RLock()
+	unchanged := allowSpec == f.allowSpec && denySpec == f.denySpec
+	f.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	if err := f.set(splitList(allowSpec), splitList(denySpec)); err != nil {
+		logger.Error("IPFilter: keeping previous lists, invalid configuration: %v", err)
+	} else {
+		logger.Info("IPFilter: reloaded allow=%q deny=%q", allowSpec, denySpec)
+	}
+	// Remember the specs either way so an invalid value is only reported once
+	f.mu.Lock()
+	f.allowSpec, f.denySpec = allowSpec, denySpec
+	f.mu.Unlock()
+}
+
+// permits reports whether the given IP passes the filter.
+func (f *ipFilter) permits(ip net.IP) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if ipInNets(ip, f.deny) {
+		return false
+	}
+	return len(f.allow) == 0 || ipInNets(ip, f.allow)
+}
+
+// middleware returns the MiddlewareFunc enforcing the filter.
+func (f *ipFilter) middleware() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if f.config != nil {
+				f.reload(c.engine.Logger)
+			}
+
+			clientIP := c.RealIP()
+			ip := net.ParseIP(clientIP)
+			if ip == nil || !f.permits(ip) {
+				c.engine.Logger.Warning("IPFilter: blocked %s %s from %s", c.Request.Method, c.Request.URL.Path, clientIP)
+				return NewHTTPError(http.StatusForbidden, "Forbidden")
+			}
+			return next(c)
+		}
+	}
+}
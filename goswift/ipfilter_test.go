@@ -0,0 +1,119 @@
+// go-swift/goswift/ipfilter_test.go
+package goswift
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// ipFilterTestEngine serves GET /debug through filter, with 10.0.0.0/8 as trusted proxies.
+func ipFilterTestEngine(t *testing.T, filter MiddlewareFunc) *Engine {
+	t.Helper()
+	app := New()
+	if err := app.SetTrustedProxies("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	group := app.Group("/debug")
+	group.Use(filter)
+	group.GET("", func(c *Context) error {
+		return c.String(http.StatusOK, "ok")
+	}).Handler()
+	return app
+}
+
+// ipFilterStatus sends GET /debug from peer with the given extra headers and returns the status.
+func ipFilterStatus(app *Engine, peer string, headers map[string]string) int {
+	req := httptest.NewRequest(http.MethodGet, "/debug", nil)
+	req.RemoteAddr = peer
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// Deny entries win over allow entries, and a non-empty allow list admits nobody else.
+func TestIPFilterPrecedence(t *testing.T) {
+	app := ipFilterTestEngine(t, IPFilter([]string{"127.0.0.1", "192.168.0.0/16", "::1"}, []string{"192.168.66.0/24"}))
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    int
+	}{
+		{"allowed IP", "127.0.0.1:1234", nil, http.StatusOK},
+		{"allowed range", "192.168.1.5:1234", nil, http.StatusOK},
+		{"IPv6", "[::1]:1234", nil, http.StatusOK},
+		{"denied inside an allowed range", "192.168.66.7:1234", nil, http.StatusForbidden},
+		{"not allowed", "203.0.113.9:1234", nil, http.StatusForbidden},
+		{"client behind a trusted proxy", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.168.1.5"}, http.StatusOK},
+		{"denied client behind a trusted proxy", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.168.66.7"}, http.StatusForbidden},
+		{"spoofed header from an untrusted peer", "203.0.113.9:1234", map[string]string{"X-Forwarded-For": "127.0.0.1"}, http.StatusForbidden},
+		{"spoofed Forwarded next to X-Forwarded-For", "10.0.0.1:1234", map[string]string{"Forwarded": "for=127.0.0.1", "X-Forwarded-For": "203.0.113.9"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := ipFilterStatus(app, tt.peer, tt.headers); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	open := ipFilterTestEngine(t, IPFilter(nil, []string{"203.0.113.0/24"}))
+	if got := ipFilterStatus(open, "198.51.100.1:1234", nil); got != http.StatusOK {
+		t.Errorf("empty allow list: got %d, want 200", got)
+	}
+	if got := ipFilterStatus(open, "203.0.113.9:1234", nil); got != http.StatusForbidden {
+		t.Errorf("empty allow list, denied IP: got %d, want 403", got)
+	}
+}
+
+// IPFilterFromConfig picks up changed lists on the next request and keeps the previous lists
+// when an update is invalid.
+func TestIPFilterFromConfigReload(t *testing.T) {
+	cm := NewConfigManager()
+	cm.Set("IPFILTER_TEST_ALLOW", "127.0.0.1")
+	app := ipFilterTestEngine(t, IPFilterFromConfig(cm, "IPFILTER_TEST_ALLOW", "IPFILTER_TEST_DENY"))
+
+	if got := ipFilterStatus(app, "192.0.2.1:1234", nil); got != http.StatusForbidden {
+		t.Fatalf("before reload: got %d, want 403", got)
+	}
+	cm.Set("IPFILTER_TEST_ALLOW", "127.0.0.1, 192.0.2.0/24")
+	if got := ipFilterStatus(app, "192.0.2.1:1234", nil); got != http.StatusOK {
+		t.Fatalf("after allowing the range: got %d, want 200", got)
+	}
+	cm.Set("IPFILTER_TEST_DENY", "192.0.2.1")
+	if got := ipFilterStatus(app, "192.0.2.1:1234", nil); got != http.StatusForbidden {
+		t.Fatalf("after denying the IP: got %d, want 403", got)
+	}
+	cm.Set("IPFILTER_TEST_DENY", "not-an-ip")
+	if got := ipFilterStatus(app, "192.0.2.1:1234", nil); got != http.StatusForbidden {
+		t.Fatalf("invalid update: got %d, want the previous lists to apply", got)
+	}
+	if got := ipFilterStatus(app, "192.0.2.2:1234", nil); got != http.StatusOK {
+		t.Fatalf("invalid update, other IP: got %d, want 200", got)
+	}
+}
+
+// Blocked requests reach the engine's error handler as a 403 HTTPError.
+func TestIPFilterErrorHandler(t *testing.T) {
+	app := ipFilterTestEngine(t, IPFilter([]string{"127.0.0.1"}, nil))
+	var handled error
+	app.SetErrorHandler(func(err error, c *Context) {
+		handled = err
+		c.String(http.StatusTeapot, "custom")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/debug", nil)
+	req.RemoteAddr = "203.0.113.9:1234"
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	var httpErr *HTTPError
+	if !errors.As(handled, &httpErr) || httpErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("error handler got %v, want a 403 HTTPError", handled)
+	}
+	if rec.Code != http.StatusTeapot || rec.Body.String() != "custom" {
+		t.Fatalf("got %d %q, want the error handler's response", rec.Code, rec.Body.String())
+	}
+}
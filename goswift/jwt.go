@@ -2,66 +2,765 @@
 package goswift
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
-// Define a secret key for signing JWTs.
-// In a real application, this should be a strong, randomly generated key
-// loaded from environment variables or a secure configuration system.
-var jwtSecret = []byte("supersecretjwtkeythatshouldbemoresecureinproduction")
+// ErrJWTSecretMissing is returned by NewJWTServiceFromConfig when an HMAC algorithm is
+// configured without JWT_SECRET.
+var ErrJWTSecretMissing = errors.New("JWT_SECRET is required for HMAC algorithms")
 
 // Claims defines the JWT claims structure.
+// Application-specific claims are not part of the struct; read them with Decode (or ClaimsAs).
 type Claims struct {
 	UserID string `json:"user_id"`
+	// Purpose marks restricted tokens (see GeneratePurposeToken); empty for access tokens.
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
+
+	raw json.RawMessage // Full payload, kept for Decode
 }
 
-// GenerateJWT generates a new JWT for the given user ID.
-func GenerateJWT(userID string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) // Token valid for 24 hours
-	claims := &Claims{
+// UnmarshalJSON decodes the standard claims and keeps the raw payload for Decode.
+func (cl *Claims) UnmarshalJSON(data []byte) error {
+	type plain Claims // Avoid recursing into this method
+	if err := json.Unmarshal(data, (*plain)(cl)); err != nil {
+		return err
+	}
+	cl.raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// Decode unmarshals the full token payload, including custom claims, into v.
+func (cl *Claims) Decode(v interface{}) error {
+	if cl.raw == nil {
+		return errors.New("claims were not parsed from a token")
+	}
+	return json.Unmarshal(cl.raw, v)
+}
+
+// JWTKey is a signing and/or verification key identified by a key ID ("kid").
+type JWTKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// SignKey is the HMAC secret or private key; nil for verification-only keys.
+	SignKey interface{}
+	// VerifyKey is the HMAC secret or public key.
+	VerifyKey interface{}
+}
+
+// NewHMACKey creates a symmetric key for HS256, HS384 or HS512.
+func NewHMACKey(kid, alg string, secret []byte) (*JWTKey, error) {
+	method, ok := jwt.GetSigningMethod(alg).(*jwt.SigningMethodHMAC)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not an HMAC algorithm", alg)
+	}
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("HMAC secret for key '%s' must be at least 32 bytes", kid)
+	}
+	return &JWTKey{ID: kid, Method: method, SignKey: secret, VerifyKey: secret}, nil
+}
+
+// NewAsymmetricKey creates a key from an RSA, ECDSA or Ed25519 private or public key.
+// Private keys can sign and verify; public keys only verify.
+// If alg is empty it is inferred from the key (RS256, ES256/384/512 by curve, or EdDSA).
+func NewAsymmetricKey(kid, alg string, key interface{}) (*JWTKey, error) {
+	k := &JWTKey{ID: kid}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		k.SignKey, k.VerifyKey = key, &key.PublicKey
+	case *ecdsa.PrivateKey:
+		k.SignKey, k.VerifyKey = key, &key.PublicKey
+	case ed25519.PrivateKey:
+		k.SignKey, k.VerifyKey = key, key.Public()
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		k.VerifyKey = key
+	default:
+		return nil, fmt.Errorf("unsupported key type %T for key '%s'", key, kid)
+	}
+
+	if alg == "" {
+		alg = inferJWTAlgorithm(k.VerifyKey)
+	}
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return nil, fmt.Errorf("unknown JWT algorithm '%s'", alg)
+	}
+	// Make sure the algorithm family matches the key type
+	switch k.VerifyKey.(type) {
+	case *rsa.PublicKey:
+		_, rsaOK := method.(*jwt.SigningMethodRSA)
+		_, pssOK := method.(*jwt.SigningMethodRSAPSS)
+		if !rsaOK && !pssOK {
+			return nil, fmt.Errorf("algorithm '%s' cannot be used with an RSA key", alg)
+		}
+	case *ecdsa.PublicKey:
+		if _, ok := method.(*jwt.SigningMethodECDSA); !ok || alg != inferJWTAlgorithm(k.VerifyKey) {
+			return nil, fmt.Errorf("algorithm '%s' does not match the ECDSA key curve", alg)
+		}
+	case ed25519.PublicKey:
+		if _, ok := method.(*jwt.SigningMethodEd25519); !ok {
+			return nil, fmt.Errorf("algorithm '%s' cannot be used with an Ed25519 key", alg)
+		}
+	}
+	k.Method = method
+	return k, nil
+}
+
+// ParseJWTKeyPEM parses a PEM-encoded private key, public key or certificate into a JWTKey.
+func ParseJWTKeyPEM(kid, alg string, data []byte) (*JWTKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found for key '%s'", kid)
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block '%s' for key '%s'", block.Type, kid)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key '%s': %w", kid, err)
+	}
+	return NewAsymmetricKey(kid, alg, key)
+}
+
+// inferJWTAlgorithm picks the conventional algorithm for a public key.
+func inferJWTAlgorithm(pub interface{}) string {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return "RS256"
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return "ES256"
+		case elliptic.P384():
+			return "ES384"
+		case elliptic.P521():
+			return "ES512"
+		}
+	case ed25519.PublicKey:
+		return "EdDSA"
+	}
+	return ""
+}
+
+// JWTService issues and validates JWTs.
+// It holds a set of keys indexed by "kid": one of them signs new tokens, all of them verify,
+// so keys can be rotated by adding the new key, switching the signing key, and removing the
+// old key once its tokens have expired.
+type JWTService struct {
+	mu         sync.RWMutex
+	keys       map[string]*JWTKey
+	signingKID string
+
+	Issuer   string
+	Audience []string      // Tokens must carry at least one of these audiences
+	TTL      time.Duration // Lifetime of issued access tokens
+	Leeway   time.Duration // Allowed clock skew when validating time-based claims
+
+	RefreshTTL   time.Duration     // Lifetime of refresh tokens
+	RefreshStore RefreshTokenStore // Where refresh tokens are kept (in memory by default)
+	Denylist     TokenDenylist     // Revoked access token IDs, checked by Validate
+}
+
+// NewJWTService creates a JWTService without keys. Add at least one signing key with AddKey.
+// Refresh tokens and the access token denylist are kept in memory unless replaced.
+func NewJWTService(issuer string, audience []string, ttl time.Duration) *JWTService {
+	return &JWTService{
+		keys:         make(map[string]*JWTKey),
+		Issuer:       issuer,
+		Audience:     audience,
+		TTL:          ttl,
+		Leeway:       30 * time.Second,
+		RefreshTTL:   30 * 24 * time.Hour,
+		RefreshStore: NewMemoryRefreshTokenStore(),
+		Denylist:     NewMemoryTokenDenylist(),
+	}
+}
+
+// NewJWTServiceFromConfig builds a JWTService from the ConfigManager (or environment).
+//
+// Recognised keys:
+//   - JWT_ISSUER, JWT_AUDIENCE (comma-separated)
+//   - JWT_TTL (access tokens, default 15m), JWT_REFRESH_TTL (default 720h), JWT_LEEWAY (Go durations)
+//   - JWT_ALGORITHM (default HS256) and JWT_KEY_ID (default "default") for the signing key
+//   - JWT_SECRET for HS* algorithms, JWT_PRIVATE_KEY or JWT_PRIVATE_KEY_FILE (PEM) otherwise
+//   - JWT_PREVIOUS_SECRETS ("kid=secret,...") and JWT_VERIFY_KEY_FILES ("kid=path.pem,...")
+//     for keys that are still accepted during rotation
+func NewJWTServiceFromConfig(cm *ConfigManager) (*JWTService, error) {
+	issuer := configOr(cm, "JWT_ISSUER", "quikdocs-goswift")
+	audience := splitList(configOr(cm, "JWT_AUDIENCE", "quikdocs-users"))
+	ttl, err := time.ParseDuration(configOr(cm, "JWT_TTL", "15m")) // Short-lived; clients renew with refresh tokens
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT_TTL: %w", err)
+	}
+	s := NewJWTService(issuer, audience, ttl)
+	if v := cm.Get("JWT_LEEWAY"); v != "" {
+		if s.Leeway, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid JWT_LEEWAY: %w", err)
+		}
+	}
+	if v := cm.Get("JWT_REFRESH_TTL"); v != "" {
+		if s.RefreshTTL, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid JWT_REFRESH_TTL: %w", err)
+		}
+	}
+
+	alg := configOr(cm, "JWT_ALGORITHM", "HS256")
+	kid := configOr(cm, "JWT_KEY_ID", "default")
+	var signingKey *JWTKey
+	if strings.HasPrefix(alg, "HS") {
+		secret := cm.Get("JWT_SECRET")
+		if secret == "" {
+			return nil, ErrJWTSecretMissing
+		}
+		signingKey, err = NewHMACKey(kid, alg, []byte(secret))
+	} else {
+		pemData := []byte(cm.Get("JWT_PRIVATE_KEY"))
+		if path := cm.Get("JWT_PRIVATE_KEY_FILE"); len(pemData) == 0 && path != "" {
+			if pemData, err = os.ReadFile(path); err != nil {
+				return nil, fmt.Errorf("failed to read JWT_PRIVATE_KEY_FILE: %w", err)
+			}
+		}
+		if len(pemData) == 0 {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY or JWT_PRIVATE_KEY_FILE is required for %s", alg)
+		}
+		signingKey, err = ParseJWTKeyPEM(kid, alg, pemData)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if signingKey.SignKey == nil {
+		return nil, fmt.Errorf("signing key '%s' is a public key", kid)
+	}
+	if err := s.AddKey(signingKey); err != nil {
+		return nil, err
+	}
+
+	// Keys from before a rotation, accepted for verification only
+	prevAlg := alg
+	if !strings.HasPrefix(prevAlg, "HS") {
+		prevAlg = "HS256"
+	}
+	for _, entry := range splitList(cm.Get("JWT_PREVIOUS_SECRETS")) {
+		prevKID, secret, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid JWT_PREVIOUS_SECRETS entry, expected kid=secret")
+		}
+		key, err := NewHMACKey(prevKID, prevAlg, []byte(secret))
+		if err != nil {
+			return nil, err
+		}
+		key.SignKey = nil
+		if err := s.AddKey(key); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range splitList(cm.Get("JWT_VERIFY_KEY_FILES")) {
+		prevKID, path, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid JWT_VERIFY_KEY_FILES entry '%s', expected kid=path", entry)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read verification key '%s': %w", prevKID, err)
+		}
+		key, err := ParseJWTKeyPEM(prevKID, "", data)
+		if err != nil {
+			return nil, err
+		}
+		key.SignKey = nil
+		if err := s.AddKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// configOr returns a config value or a default when it is unset.
+func configOr(cm *ConfigManager, key, def string) string {
+	if v := cm.Get(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// AddKey registers a key. The first key with a SignKey becomes the signing key.
+func (s *JWTService) AddKey(key *JWTKey) error {
+	if key.ID == "" {
+		return errors.New("JWT key requires an ID")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.keys[key.ID]; exists {
+		return fmt.Errorf("JWT key '%s' already registered", key.ID)
+	}
+	s.keys[key.ID] = key
+	if s.signingKID == "" && key.SignKey != nil {
+		s.signingKID = key.ID
+	}
+	return nil
+}
+
+// SetSigningKey selects the key used to sign new tokens.
+func (s *JWTService) SetSigningKey(kid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[kid]
+	if !ok {
+		return fmt.Errorf("JWT key '%s' not found", kid)
+	}
+	if key.SignKey == nil {
+		return fmt.Errorf("JWT key '%s' cannot sign", kid)
+	}
+	s.signingKID = kid
+	return nil
+}
+
+// RemoveKey removes a key; tokens signed with it no longer validate. The signing key cannot be removed.
+func (s *JWTService) RemoveKey(kid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if kid == s.signingKID {
+		return fmt.Errorf("JWT key '%s' is the signing key", kid)
+	}
+	delete(s.keys, kid)
+	return nil
+}
+
+// sign signs the claims with the current signing key.
+func (s *JWTService) sign(claims jwt.Claims) (string, error) {
+	s.mu.RLock()
+	key := s.keys[s.signingKID]
+	s.mu.RUnlock()
+	if key == nil {
+		return "", errors.New("no JWT signing key configured")
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.SignKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT: %w", err)
+	}
+	return tokenString, nil
+}
+
+// Generate issues a new token for the given user ID.
+func (s *JWTService) Generate(userID string) (string, error) {
+	return s.sign(s.newClaims(userID))
+}
+
+// GenerateWithClaims issues a token carrying custom claims in addition to the standard ones.
+// custom must marshal to a JSON object; registered claims (exp, iss, sub, ...) and user_id
+// are always set by the service and cannot be overridden.
+func (s *JWTService) GenerateWithClaims(userID string, custom interface{}) (string, error) {
+	merged := jwt.MapClaims{}
+	if custom != nil {
+		data, err := json.Marshal(custom)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode custom claims: %w", err)
+		}
+		if err := json.Unmarshal(data, &merged); err != nil {
+			return "", fmt.Errorf("custom claims must be a JSON object: %w", err)
+		}
+	}
+
+	data, err := json.Marshal(s.newClaims(userID))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode claims: %w", err)
+	}
+	var standard map[string]interface{}
+	if err := json.Unmarshal(data, &standard); err != nil {
+		return "", fmt.Errorf("failed to encode claims: %w", err)
+	}
+	for k, v := range standard {
+		merged[k] = v
+	}
+	return s.sign(merged)
+}
+
+// newClaims builds the standard claims for a new token.
+func (s *JWTService) newClaims(userID string) *Claims {
+	now := time.Now()
+	return &Claims{
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    "quikdocs-goswift",
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.TTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    s.Issuer,
 			Subject:   userID,
-			ID:        fmt.Sprintf("%d", time.Now().UnixNano()), // Unique ID for the token
-			Audience:  []string{"quikdocs-users"},
+			ID:        uuid.NewString(), // Unique ID for the token
+			Audience:  s.Audience,
 		},
 	}
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtSecret)
-	if err != nil {
-		return "", fmt.Errorf("failed to sign JWT: %w", err)
+// keyFor resolves the verification key for a parsed token from its "kid" header.
+func (s *JWTService) keyFor(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	s.mu.RLock()
+	if kid == "" {
+		kid = s.signingKID // Tokens issued before kid headers were added
 	}
-	return tokenString, nil
+	key, ok := s.keys[kid]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID '%s'", kid)
+	}
+	// Verify the signing method matches the key, preventing algorithm confusion
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.VerifyKey, nil
 }
 
-// ValidateJWT validates a JWT string and returns the claims if valid.
-func ValidateJWT(tokenString string) (*Claims, error) {
+// Validate parses a token, verifies its signature, expiry, issuer and audience, checks that it
+// has not been revoked, and returns its claims.
+func (s *JWTService) Validate(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	if err := s.parse(tokenString, claims); err != nil {
+		return nil, err
+	}
+	if err := s.checkRevoked(claims.ID); err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("a '%s' token cannot be used for authentication", claims.Purpose)
+	}
+	return claims, nil
+}
+
+// GeneratePurposeToken issues a short-lived token restricted to one purpose, such as the
+// "mfa pending" step of a login. Validate rejects it, so it cannot authenticate requests;
+// check it with ValidatePurpose instead.
+func (s *JWTService) GeneratePurposeToken(userID, purpose string, ttl time.Duration) (string, error) {
+	if purpose == "" {
+		return "", errors.New("token purpose is required")
+	}
+	claims := s.newClaims(userID)
+	claims.Purpose = purpose
+	claims.ExpiresAt = jwt.NewNumericDate(claims.IssuedAt.Add(ttl))
+	return s.sign(claims)
+}
+
+// ValidatePurpose validates a token issued by GeneratePurposeToken for the given purpose.
+func (s *JWTService) ValidatePurpose(tokenString, purpose string) (*Claims, error) {
 	claims := &Claims{}
+	if err := s.parse(tokenString, claims); err != nil {
+		return nil, err
+	}
+	if err := s.checkRevoked(claims.ID); err != nil {
+		return nil, err
+	}
+	if purpose == "" || claims.Purpose != purpose {
+		return nil, fmt.Errorf("token is not a '%s' token", purpose)
+	}
+	return claims, nil
+}
+
+// RevokeClaims puts a validated token on the denylist until it expires, e.g. to make a
+// purpose token single-use.
+func (s *JWTService) RevokeClaims(claims *Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return errors.New("token has no ID or expiry")
+	}
+	return s.Denylist.Add(claims.ID, claims.ExpiresAt.Add(s.Leeway))
+}
+
+// checkRevoked returns an error if the token ID is on the denylist.
+func (s *JWTService) checkRevoked(tokenID string) error {
+	if s.Denylist == nil || tokenID == "" {
+		return nil
+	}
+	revoked, err := s.Denylist.Contains(tokenID)
+	if err != nil {
+		return fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	if revoked {
+		return errors.New("JWT has been revoked")
+	}
+	return nil
+}
+
+// parse verifies a token and decodes its claims into claims.
+func (s *JWTService) parse(tokenString string, claims jwt.Claims) error {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(s.algorithms()),
+		jwt.WithIssuer(s.Issuer),
+		jwt.WithLeeway(s.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	token, err := parser.ParseWithClaims(tokenString, claims, s.keyFor)
+	if err != nil {
+		return fmt.Errorf("invalid JWT: %w", err)
+	}
+	if !token.Valid {
+		return fmt.Errorf("JWT is invalid")
+	}
+
+	// Accept the token if it names any of our audiences
+	audience, err := claims.GetAudience()
+	if err != nil {
+		return fmt.Errorf("invalid JWT audience: %w", err)
+	}
+	for _, want := range s.Audience {
+		for _, got := range audience {
+			if got == want {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("JWT audience %v not accepted", audience)
+}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		// Verify the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// algorithms lists the algorithms of all registered keys.
+func (s *JWTService) algorithms() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := make(map[string]bool)
+	var algs []string
+	for _, key := range s.keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
 		}
-		return jwtSecret, nil
+	}
+	return algs
+}
+
+// JWK is a JSON Web Key (RFC 7517) describing a public key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the service. HMAC keys are secret and never included.
+func (s *JWTService) JWKS() JWKSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range s.keys {
+		if jwk, ok := publicJWK(key); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid }) // Stable output
+	return set
+}
+
+// JWKSHandler serves the service's public keys, typically at /.well-known/jwks.json.
+func (s *JWTService) JWKSHandler(c *Context) error {
+	c.Writer.Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, s.JWKS())
+}
+
+// publicJWK converts an asymmetric key to its public JWK representation.
+func publicJWK(key *JWTKey) (JWK, bool) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+	switch pub := key.VerifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(pub)
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
+
+// PublicKey converts the JWK into an *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, errN := b64(k.N)
+		e, errE := b64(k.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA key '%s'", k.Kid)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s' for key '%s'", k.Crv, k.Kid)
+		}
+		x, errX := b64(k.X)
+		y, errY := b64(k.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("invalid EC key '%s'", k.Kid)
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("invalid EC key '%s': point is not on the curve", k.Kid)
+		}
+		return pub, nil
+	case "OKP":
+		x, err := b64(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid OKP key '%s'", k.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%s' for key '%s'", k.Kty, k.Kid)
+}
+
+var (
+	defaultJWTService     *JWTService
+	defaultJWTServiceOnce sync.Once
+)
+
+// DefaultJWTService returns the service used by GenerateJWT, ValidateJWT and JWTAuthMiddleware(nil).
+// It is configured from the environment (see NewJWTServiceFromConfig). Without JWT_SECRET it
+// falls back to a random per-process secret, so tokens do not survive restarts and are not
+// shared between instances; any other configuration error panics.
+func DefaultJWTService() *JWTService {
+	defaultJWTServiceOnce.Do(func() {
+		defaultJWTService = newDefaultJWTService(NewConfigManager(), NewLogger())
 	})
+	return defaultJWTService
+}
+
+// newDefaultJWTService builds the default service from cm, generating a secret if none is set.
+func newDefaultJWTService(cm *ConfigManager, logger *Logger) *JWTService {
+	s, err := NewJWTServiceFromConfig(cm)
+	if err == nil {
+		return s
+	}
+	if !errors.Is(err, ErrJWTSecretMissing) {
+		panic(fmt.Sprintf("invalid JWT configuration: %v", err)) // Tokens would be signed with a key nobody else has
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("failed to generate JWT secret: %v", err))
+	}
+	logger.Warning("JWT_SECRET is not set; signing tokens with a random per-process secret")
+	cm.Set("JWT_SECRET", hex.EncodeToString(secret)) // cm is private to the default service
+	if s, err = NewJWTServiceFromConfig(cm); err != nil {
+		panic(fmt.Sprintf("invalid JWT configuration: %v", err))
+	}
+	return s
+}
+
+// GenerateJWTWith issues a token with typed custom claims, e.g.
+//
+//	GenerateJWTWith(svc, user.ID, AppClaims{Roles: []string{"admin"}, Tenant: "acme"})
+//
+// A nil service uses DefaultJWTService().
+func GenerateJWTWith[T any](s *JWTService, userID string, custom T) (string, error) {
+	if s == nil {
+		s = DefaultJWTService()
+	}
+	return s.GenerateWithClaims(userID, custom)
+}
 
+// ValidateJWTWith validates a token and decodes its custom claims into T.
+// A nil service uses DefaultJWTService().
+func ValidateJWTWith[T any](s *JWTService, tokenString string) (*Claims, T, error) {
+	var custom T
+	if s == nil {
+		s = DefaultJWTService()
+	}
+	claims, err := s.Validate(tokenString)
 	if err != nil {
-		return nil, fmt.Errorf("invalid JWT: %w", err)
+		return nil, custom, err
+	}
+	if err := claims.Decode(&custom); err != nil {
+		return nil, custom, fmt.Errorf("failed to decode custom claims: %w", err)
 	}
+	return claims, custom, nil
+}
 
-	if !token.Valid {
-		return nil, fmt.Errorf("JWT is invalid")
+// ClaimsAs decodes the custom claims of the token that authenticated the request into T.
+func ClaimsAs[T any](c *Context) (T, error) {
+	var custom T
+	claims, err := c.Claims()
+	if err != nil {
+		return custom, err
+	}
+	if err := claims.Decode(&custom); err != nil {
+		return custom, NewHTTPError(http.StatusUnauthorized, "Invalid token claims", err)
 	}
+	return custom, nil
+}
 
-	return claims, nil
+// GenerateJWT generates a new JWT for the given user ID using the default service.
+func GenerateJWT(userID string) (string, error) {
+	return DefaultJWTService().Generate(userID)
+}
+
+// ValidateJWT validates a JWT string with the default service and returns the claims if valid.
+func ValidateJWT(tokenString string) (*Claims, error) {
+	return DefaultJWTService().Validate(tokenString)
 }
@@ -0,0 +1,337 @@
+// go-swift/goswift/jwt_test.go
+package goswift
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testHMACSecret is long enough for NewHMACKey.
+var testHMACSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// testJWTService returns a service signing with the given keys' first key.
+func testJWTService(t *testing.T, keys ...*JWTKey) *JWTService {
+	t.Helper()
+	s := NewJWTService("app", []string{"app"}, time.Minute)
+	for _, key := range keys {
+		if err := s.AddKey(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// mustJWTKey panics, failing the test, if a key could not be created.
+func mustJWTKey(key *JWTKey, err error) *JWTKey {
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// tokenKID returns the kid header of a token without verifying it.
+func tokenKID(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+// Tokens signed with a previous key keep validating after the signing key is rotated, until
+// the previous key is removed.
+func TestJWTKeyRotation(t *testing.T) {
+	oldKey := mustJWTKey(NewHMACKey("k1", "HS256", testHMACSecret))
+	s := testJWTService(t, oldKey)
+	oldToken, err := s.Generate("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey := mustJWTKey(NewHMACKey("k2", "HS256", []byte("fedcba9876543210fedcba9876543210")))
+	if err := s.AddKey(newKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSigningKey("k2"); err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := s.Generate("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := tokenKID(t, newToken); kid != "k2" {
+		t.Fatalf("new token signed with %q, want k2", kid)
+	}
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if claims, err := s.Validate(token); err != nil || claims.UserID != "user-1" {
+			t.Fatalf("%s token: Validate = %v, %v", name, claims, err)
+		}
+	}
+
+	if err := s.RemoveKey("k2"); err == nil {
+		t.Fatal("removing the signing key succeeded")
+	}
+	if err := s.RemoveKey("k1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Validate(oldToken); err == nil {
+		t.Fatal("token of a removed key still validates")
+	}
+}
+
+// A service configured with JWT_PREVIOUS_SECRETS accepts tokens of the previous secret but signs
+// with the new one.
+func TestJWTKeyRotationFromConfig(t *testing.T) {
+	before := NewConfigManager()
+	before.Set("JWT_SECRET", string(testHMACSecret))
+	before.Set("JWT_KEY_ID", "2025")
+	old, err := NewJWTServiceFromConfig(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := old.Generate("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := NewConfigManager()
+	after.Set("JWT_SECRET", "fedcba9876543210fedcba9876543210")
+	after.Set("JWT_KEY_ID", "2026")
+	after.Set("JWT_PREVIOUS_SECRETS", "2025="+string(testHMACSecret))
+	rotated, err := NewJWTServiceFromConfig(after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.Validate(oldToken); err != nil {
+		t.Fatalf("token of the previous secret: %v", err)
+	}
+	newToken, err := rotated.Generate("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := tokenKID(t, newToken); kid != "2026" {
+		t.Fatalf("new token signed with %q, want 2026", kid)
+	}
+	if _, err := old.Validate(newToken); err == nil {
+		t.Fatal("the old service accepted a token of the new secret")
+	}
+}
+
+// JWKS lists the public half of every asymmetric key, sorted by kid, and never HMAC secrets.
+func TestJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testJWTService(t,
+		mustJWTKey(NewAsymmetricKey("c-ec", "", ecKey)),
+		mustJWTKey(NewAsymmetricKey("a-rsa", "", &rsaKey.PublicKey)), // Verification only
+		mustJWTKey(NewAsymmetricKey("b-ed", "", edKey)),
+		mustJWTKey(NewHMACKey("d-hmac", "HS256", testHMACSecret)),
+	)
+
+	rec := httptest.NewRecorder()
+	ctx := &Context{Request: httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil), Writer: &responseWriter{ResponseWriter: rec}, engine: New()}
+	if err := s.JWKSHandler(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.Header().Get("Cache-Control"); got == "" {
+		t.Error("JWKS response is not cacheable")
+	}
+	var set JWKSet
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		kid, kty, alg string
+		public        interface{ Equal(x crypto.PublicKey) bool }
+	}{
+		{"a-rsa", "RSA", "RS256", &rsaKey.PublicKey},
+		{"b-ed", "OKP", "EdDSA", edKey.Public().(ed25519.PublicKey)},
+		{"c-ec", "EC", "ES256", &ecKey.PublicKey},
+	}
+	if len(set.Keys) != len(want) {
+		t.Fatalf("got %d keys %+v, want %d", len(set.Keys), set.Keys, len(want))
+	}
+	for i, w := range want {
+		jwk := set.Keys[i]
+		if jwk.Kid != w.kid || jwk.Kty != w.kty || jwk.Alg != w.alg || jwk.Use != "sig" {
+			t.Errorf("key %d: got %+v, want kid %s kty %s alg %s", i, jwk, w.kid, w.kty, w.alg)
+			continue
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			t.Errorf("%s: %v", w.kid, err)
+		} else if !w.public.Equal(pub) {
+			t.Errorf("%s: public key does not round-trip", w.kid)
+		}
+	}
+	if strings.Contains(rec.Body.String(), "d-hmac") {
+		t.Error("JWKS exposes the HMAC key")
+	}
+}
+
+// Tokens whose algorithm does not match the key named by their kid are rejected, including the
+// classic HS256-signed-with-the-RSA-public-key attack.
+func TestJWTRejectsAlgorithmConfusion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	s := testJWTService(t,
+		mustJWTKey(NewAsymmetricKey("rsa", "RS256", rsaKey)),
+		mustJWTKey(NewHMACKey("hmac", "HS256", testHMACSecret)),
+	)
+	if _, err := s.Validate(mustSign(t, s, "rsa", jwt.SigningMethodRS256, rsaKey)); err != nil {
+		t.Fatalf("a genuine token was rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		kid    string
+		method jwt.SigningMethod
+		key    interface{}
+	}{
+		{"HS256 with the RSA public key as secret", "rsa", jwt.SigningMethodHS256, pubPEM},
+		{"HS256 with the RSA public key DER as secret", "rsa", jwt.SigningMethodHS256, pubDER},
+		{"HS512 for an HS256 key", "hmac", jwt.SigningMethodHS512, testHMACSecret},
+		{"RS256 for an HMAC key", "hmac", jwt.SigningMethodRS256, rsaKey},
+		{"unknown kid", "other", jwt.SigningMethodHS256, testHMACSecret},
+		{"alg none", "hmac", jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType},
+	}
+	for _, tt := range tests {
+		if _, err := s.Validate(mustSign(t, s, tt.kid, tt.method, tt.key)); err == nil {
+			t.Errorf("%s: token accepted", tt.name)
+		}
+	}
+}
+
+// mustSign signs valid claims for s with an arbitrary method, key and kid.
+func mustSign(t *testing.T, s *JWTService, kid string, method jwt.SigningMethod, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, s.newClaims("user-1"))
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// Without JWT_SECRET the default service signs with a random secret; any other configuration
+// error panics instead of being papered over.
+func TestDefaultJWTServiceConfig(t *testing.T) {
+	s := newDefaultJWTService(NewConfigManager(), NewLogger())
+	token, err := s.Generate("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Validate(token); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config map[string]string
+	}{
+		{"malformed JWT_TTL", map[string]string{"JWT_TTL": "soon"}},
+		{"missing key file", map[string]string{"JWT_ALGORITHM": "RS256", "JWT_PRIVATE_KEY_FILE": "/nonexistent/key.pem"}},
+		{"no private key", map[string]string{"JWT_ALGORITHM": "ES256"}},
+		{"short secret", map[string]string{"JWT_SECRET": "short"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := NewConfigManager()
+			for k, v := range tt.config {
+				cm.Set(k, v)
+			}
+			defer func() {
+				if recover() == nil {
+					t.Fatal("no panic")
+				}
+			}()
+			newDefaultJWTService(cm, NewLogger())
+		})
+	}
+}
+
+// ClaimsAs decodes custom and standard claims of the authenticating token; a missing token
+// or claims of the wrong shape are 401s.
+func TestClaimsAs(t *testing.T) {
+	type appClaims struct {
+		Subject string   `json:"sub"`
+		Roles   []string `json:"roles"`
+		Tenant  string   `json:"tenant"`
+	}
+	s := testJWTService(t, mustJWTKey(NewHMACKey("k1", "HS256", testHMACSecret)))
+	claimsContext := func(custom interface{}) *Context {
+		c := newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		if custom == nil {
+			return c
+		}
+		token, err := s.GenerateWithClaims("user-1", custom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := s.Validate(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Set("claims", claims)
+		return c
+	}
+
+	got, err := ClaimsAs[appClaims](claimsContext(map[string]interface{}{"roles": []string{"admin"}, "tenant": "acme"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != "user-1" || got.Tenant != "acme" || len(got.Roles) != 1 || got.Roles[0] != "admin" {
+		t.Fatalf("ClaimsAs = %+v", got)
+	}
+
+	tests := []struct {
+		name string
+		c    *Context
+	}{
+		{"no token", claimsContext(nil)},
+		{"wrong shape", claimsContext(map[string]interface{}{"tenant": 42})},
+	}
+	for _, tt := range tests {
+		_, err := ClaimsAs[appClaims](tt.c)
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: got %v, want a 401 HTTPError", tt.name, err)
+		}
+	}
+}
@@ -15,6 +15,7 @@ const (
 	INFO LogLevel = iota
 	WARNING
 	ERROR
+	DEBUG // Only written once enabled with SetDebug
 )
 
 // String returns the string representation of a LogLevel.
@@ -26,6 +27,8 @@ func (l LogLevel) String() string {
 		return "WARN"
 	case ERROR:
 		return "ERROR"
+	case DEBUG:
+		return "DEBUG"
 	default:
 		return "UNKNOWN"
 	}
@@ -34,7 +37,8 @@ func (l LogLevel) String() string {
 // Logger provides a simple logging utility.
 type Logger struct {
 	*log.Logger
-	mu sync.Mutex
+	mu    sync.Mutex
+	debug bool
 }
 
 // NewLogger creates a new Logger instance.
@@ -54,6 +58,18 @@ func (l *Logger) Warning(format string, args ...interface{}) {
 	l.log(WARNING, format, args...)
 }
 
+// Debug logs a debug message, if debug logging is enabled.
+func (l *Logger) Debug(format string, args ...interface{}) {
+	l.log(DEBUG, format, args...)
+}
+
+// SetDebug enables or disables debug messages.
+func (l *Logger) SetDebug(enabled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.debug = enabled
+}
+
 // Error logs an error message.
 func (l *Logger) Error(format string, args ...interface{}) {
 	l.log(ERROR, format, args...)
@@ -63,5 +79,8 @@ func (l *Logger) Error(format string, args ...interface{}) {
 func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if level == DEBUG && !l.debug {
+		return
+	}
 	l.Printf("[%s] %s", level.String(), fmt.Sprintf(format, args...))
 }
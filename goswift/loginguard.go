@@ -0,0 +1,272 @@
+// go-swift/goswift/loginguard.go
+package goswift
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LoginGuardConfig controls throttling and lockout of failed logins. Zero values fall back
+// to the defaults noted on each field.
+type LoginGuardConfig struct {
+	FreeAttempts   int           // Failures per username allowed before backoff starts, 3 by default
+	IPFreeAttempts int           // Failures per IP allowed before backoff starts, 10 by default
+	BaseDelay      time.Duration // First backoff delay, doubling with each further failure; 1s by default
+	MaxDelay       time.Duration // Upper bound for the backoff delay, 5m by default
+
+	// IPs get higher limits than usernames, as many users may share one (NAT, proxies)
+	LockoutThreshold   int           // Failures for one username that lock it, 10 by default
+	IPLockoutThreshold int           // Failures from one IP that lock it, 50 by default
+	LockoutDuration    time.Duration // How long a lockout lasts, 15m by default
+
+	Window time.Duration // Failures are forgotten after this long without another one, 1h by default
+}
+
+// loginRecord tracks failed logins for a username or an IP.
+type loginRecord struct {
+	failures    int
+	pending     int // Attempts let through by Check that have not failed or succeeded yet
+	lastFailure time.Time
+	lastAttempt time.Time // When Check last let an attempt through
+	lockedUntil time.Time
+}
+
+// loginAttemptTimeout is how long an attempt stays pending at most. Attempts that are never
+// settled (e.g. the handler failed in between) stop counting after this.
+const loginAttemptTimeout = time.Minute
+
+// LoginGuard throttles password guessing. It counts failed logins per username and per client IP;
+// after the free attempts each further attempt must wait an exponentially growing delay, and
+// at the lockout threshold the username or IP is locked out for LockoutDuration.
+//
+// Unknown usernames are tracked like existing ones, so responses don't reveal which accounts exist.
+// Every decision is logged as a "security event=..." line of key=value pairs.
+type LoginGuard struct {
+	config    LoginGuardConfig
+	logger    *Logger
+	mu        sync.Mutex
+	records   map[string]*loginRecord // map["user:<name>" or "ip:<addr>"]*loginRecord
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewLoginGuard creates a LoginGuard that logs security events to logger.
+func NewLoginGuard(config LoginGuardConfig, logger *Logger) *LoginGuard {
+	if config.FreeAttempts <= 0 {
+		config.FreeAttempts = 3
+	}
+	if config.IPFreeAttempts <= 0 {
+		config.IPFreeAttempts = 10
+	}
+	if config.BaseDelay <= 0 {
+		config.BaseDelay = time.Second
+	}
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = 5 * time.Minute
+	}
+	if config.LockoutThreshold <= 0 {
+		config.LockoutThreshold = 10
+	}
+	if config.IPLockoutThreshold <= 0 {
+		config.IPLockoutThreshold = 50
+	}
+	if config.LockoutDuration <= 0 {
+		config.LockoutDuration = 15 * time.Minute
+	}
+	if config.Window <= 0 {
+		config.Window = time.Hour
+	}
+	return &LoginGuard{
+		config:  config,
+		logger:  logger,
+		records: make(map[string]*loginRecord),
+		now:     time.Now,
+	}
+}
+
+// Check returns a 429 Too Many Requests HTTPError, with a Retry-After header, if the username or
+// the client IP must wait before trying again. Call it before verifying the password.
+//
+// An attempt that is let through counts as pending until Failure, Success or Abandon settles it,
+// so concurrent attempts are throttled as if it had already failed.
+func (g *LoginGuard) Check(c *Context, username string) error {
+	ip := c.RealIP()
+	wait := g.Reserve(username, ip)
+	if wait <= 0 {
+		return nil
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	g.event("login_throttled", username, ip, "retry_after=%d", seconds)
+	c.Writer.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return NewHTTPError(http.StatusTooManyRequests, "Too many failed login attempts, please try again later")
+}
+
+// Failure records a failed login for the username from the client IP.
+func (g *LoginGuard) Failure(c *Context, username string) {
+	g.RecordFailure(username, c.RealIP())
+}
+
+// Success clears the failures recorded for the username after a completed login.
+func (g *LoginGuard) Success(c *Context, username string) {
+	g.RecordSuccess(username, c.RealIP())
+}
+
+// Abandon settles an attempt that neither failed nor completed a login, such as a correct
+// password that still needs a second factor.
+func (g *LoginGuard) Abandon(c *Context, username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.settleLocked("user:" + username)
+	g.settleLocked("ip:" + c.RealIP())
+}
+
+// Wait returns how long the username and IP must wait before the next attempt; 0 if none.
+func (g *LoginGuard) Wait(username, ip string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	return max(g.waitLocked("user:"+username, g.config.FreeAttempts, now), g.waitLocked("ip:"+ip, g.config.IPFreeAttempts, now))
+}
+
+// Reserve is like Wait, but when no wait is needed it also counts an attempt as pending for
+// the username and IP, until RecordFailure or RecordSuccess settles it.
+func (g *LoginGuard) Reserve(username, ip string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	userKey, ipKey := "user:"+username, "ip:"+ip
+	wait := max(g.waitLocked(userKey, g.config.FreeAttempts, now), g.waitLocked(ipKey, g.config.IPFreeAttempts, now))
+	if wait > 0 {
+		return wait
+	}
+	g.sweepLocked(now)
+	for _, key := range []string{userKey, ipKey} {
+		record, ok := g.records[key]
+		if !ok {
+			record = &loginRecord{}
+			g.records[key] = record
+		}
+		record.pending++
+		record.lastAttempt = now
+	}
+	return 0
+}
+
+// waitLocked returns the wait imposed by one record.
+func (g *LoginGuard) waitLocked(key string, freeAttempts int, now time.Time) time.Duration {
+	record, ok := g.records[key]
+	if !ok {
+		return 0
+	}
+	if now.Before(record.lockedUntil) {
+		return record.lockedUntil.Sub(now)
+	}
+	if now.Sub(record.lastAttempt) > loginAttemptTimeout {
+		record.pending = 0
+	}
+	attempts := record.failures + record.pending
+	if attempts < freeAttempts {
+		return 0
+	}
+	last := record.lastFailure
+	if record.pending > 0 && record.lastAttempt.After(last) {
+		last = record.lastAttempt
+	}
+	return max(last.Add(g.delay(attempts-freeAttempts)).Sub(now), 0)
+}
+
+// settleLocked ends one pending attempt for key.
+func (g *LoginGuard) settleLocked(key string) {
+	if record, ok := g.records[key]; ok && record.pending > 0 {
+		record.pending--
+	}
+}
+
+// delay returns the backoff after the given number of failures beyond the free attempts.
+func (g *LoginGuard) delay(exponent int) time.Duration {
+	if exponent >= 30 { // Avoid overflowing the shift below
+		return g.config.MaxDelay
+	}
+	return min(g.config.BaseDelay<<exponent, g.config.MaxDelay)
+}
+
+// RecordFailure records a failed login and locks the username or IP once it reaches its threshold.
+func (g *LoginGuard) RecordFailure(username, ip string) {
+	g.mu.Lock()
+	now := g.now()
+	g.sweepLocked(now)
+	userFailures, userLocked := g.failLocked("user:"+username, g.config.LockoutThreshold, now)
+	ipFailures, ipLocked := g.failLocked("ip:"+ip, g.config.IPLockoutThreshold, now)
+	g.mu.Unlock()
+
+	g.event("login_failure", username, ip, "user_failures=%d ip_failures=%d", userFailures, ipFailures)
+	if userLocked {
+		g.event("account_locked", username, ip, "duration=%s", g.config.LockoutDuration)
+	}
+	if ipLocked {
+		g.event("ip_locked", username, ip, "duration=%s", g.config.LockoutDuration)
+	}
+}
+
+// failLocked counts a failure for key and reports the new count and whether it caused a lockout.
+func (g *LoginGuard) failLocked(key string, threshold int, now time.Time) (int, bool) {
+	record, ok := g.records[key]
+	if !ok {
+		record = &loginRecord{}
+		g.records[key] = record
+	}
+	if now.Sub(record.lastFailure) > g.config.Window {
+		record.failures = 0
+	}
+	if record.pending > 0 {
+		record.pending--
+	}
+	record.failures++
+	record.lastFailure = now
+	if record.failures%threshold == 0 { // Lock again at every multiple of the threshold
+		record.lockedUntil = now.Add(g.config.LockoutDuration)
+		return record.failures, true
+	}
+	return record.failures, false
+}
+
+// RecordSuccess clears the username's failures. The IP's failures are kept, so a guesser cannot
+// reset them by logging into an account of their own between attempts; they expire after Window.
+func (g *LoginGuard) RecordSuccess(username, ip string) {
+	g.mu.Lock()
+	delete(g.records, "user:"+username)
+	g.settleLocked("ip:" + ip)
+	g.mu.Unlock()
+	g.event("login_success", username, ip, "")
+}
+
+// sweepLocked drops expired records, at most once a minute, so that guessing random
+// usernames cannot grow the map without bound.
+func (g *LoginGuard) sweepLocked(now time.Time) {
+	if now.Sub(g.lastSweep) < time.Minute {
+		return
+	}
+	g.lastSweep = now
+	for key, record := range g.records {
+		if now.Sub(record.lastFailure) > g.config.Window && now.Sub(record.lastAttempt) > g.config.Window && now.After(record.lockedUntil) {
+			delete(g.records, key)
+		}
+	}
+}
+
+// event logs a security event as key=value pairs, like the authorization audit log.
+func (g *LoginGuard) event(name, username, ip, format string, args ...interface{}) {
+	line := fmt.Sprintf("security event=%s user=%q ip=%s", name, username, ip)
+	if format != "" {
+		line += " " + fmt.Sprintf(format, args...)
+	}
+	if name == "login_success" {
+		g.logger.Info("%s", line)
+	} else {
+		g.logger.Warning("%s", line)
+	}
+}
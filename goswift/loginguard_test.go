@@ -0,0 +1,162 @@
+// go-swift/goswift/loginguard_test.go
+package goswift
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLoginGuard returns a LoginGuard whose clock only moves when the returned function is called.
+func testLoginGuard(config LoginGuardConfig) (*LoginGuard, func(time.Duration)) {
+	g := NewLoginGuard(config, NewLogger())
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+	g.lastSweep = now
+	return g, func(d time.Duration) { now = now.Add(d) }
+}
+
+// After the free attempts each failure doubles the wait, up to MaxDelay.
+func TestLoginGuardBackoff(t *testing.T) {
+	g, advance := testLoginGuard(LoginGuardConfig{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 4 * time.Second})
+	for i, want := range []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		if got := g.Wait("alice", "192.0.2.1"); got != want {
+			t.Fatalf("after %d failures: wait %v, want %v", i, got, want)
+		}
+		advance(want)
+		if wait := g.Reserve("alice", "192.0.2.1"); wait != 0 {
+			t.Fatalf("after waiting %v: still %v to wait", want, wait)
+		}
+		g.RecordFailure("alice", "192.0.2.1")
+	}
+	if got := g.Wait("bob", "192.0.2.2"); got != 0 {
+		t.Fatalf("other user and IP: wait %v, want 0", got)
+	}
+}
+
+// Attempts let through but not yet settled count, so parallel guesses cannot all pass Check
+// before the first failure is recorded.
+func TestLoginGuardReservesAttempts(t *testing.T) {
+	g, advance := testLoginGuard(LoginGuardConfig{FreeAttempts: 3})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if g.Reserve("alice", "192.0.2.1") == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 3 {
+		t.Fatalf("%d concurrent attempts allowed, want 3", allowed)
+	}
+
+	// Settled attempts stop counting as pending
+	g.RecordSuccess("alice", "192.0.2.1")
+	g.mu.Lock()
+	pending := g.records["ip:192.0.2.1"].pending
+	g.mu.Unlock()
+	if pending != 2 {
+		t.Fatalf("IP has %d pending attempts after one success, want 2", pending)
+	}
+
+	// Attempts never settled stop counting after loginAttemptTimeout
+	advance(loginAttemptTimeout + time.Second)
+	if wait := g.Reserve("carol", "192.0.2.1"); wait != 0 {
+		t.Fatalf("stale attempts still throttle: wait %v", wait)
+	}
+}
+
+// Check answers 429 with Retry-After once the username must wait.
+func TestLoginGuardCheck(t *testing.T) {
+	g, _ := testLoginGuard(LoginGuardConfig{FreeAttempts: 1, BaseDelay: 1500 * time.Millisecond})
+	check := func() (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		return rec, g.Check(&Context{Request: req, Writer: &responseWriter{ResponseWriter: rec}, engine: New()}, "alice")
+	}
+	if _, err := check(); err != nil {
+		t.Fatal(err)
+	}
+	g.RecordFailure("alice", "192.0.2.1")
+
+	rec, err := check()
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("got %v, want a 429 HTTPError", err)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" { // Rounded up
+		t.Fatalf("Retry-After = %q, want 2", got)
+	}
+}
+
+// Reaching the threshold locks the username for LockoutDuration, and again at every multiple.
+func TestLoginGuardLockout(t *testing.T) {
+	g, advance := testLoginGuard(LoginGuardConfig{FreeAttempts: 10, LockoutThreshold: 3, LockoutDuration: time.Minute})
+	for i := 0; i < 3; i++ {
+		g.Reserve("alice", "192.0.2.1")
+		g.RecordFailure("alice", "192.0.2.1")
+	}
+	if got := g.Wait("alice", "192.0.2.99"); got != time.Minute {
+		t.Fatalf("locked username: wait %v, want 1m", got)
+	}
+	advance(time.Minute)
+	if got := g.Wait("alice", "192.0.2.99"); got != 0 {
+		t.Fatalf("after the lockout: wait %v, want 0", got)
+	}
+	for i := 0; i < 3; i++ {
+		g.RecordFailure("alice", "192.0.2.1")
+	}
+	if got := g.Wait("alice", "192.0.2.99"); got != time.Minute {
+		t.Fatalf("at twice the threshold: wait %v, want 1m", got)
+	}
+}
+
+// A successful login clears the username's failures but not the IP's.
+func TestLoginGuardSuccessResetsUsername(t *testing.T) {
+	g, _ := testLoginGuard(LoginGuardConfig{FreeAttempts: 1, IPFreeAttempts: 1})
+	g.RecordFailure("alice", "192.0.2.1")
+	g.RecordSuccess("alice", "192.0.2.1")
+	if got := g.Wait("alice", "192.0.2.2"); got != 0 {
+		t.Fatalf("username after success: wait %v, want 0", got)
+	}
+	if got := g.Wait("bob", "192.0.2.1"); got == 0 {
+		t.Fatal("IP failures were cleared by a success")
+	}
+}
+
+// Failures are forgotten after Window, and their records swept.
+func TestLoginGuardWindowAndSweep(t *testing.T) {
+	g, advance := testLoginGuard(LoginGuardConfig{FreeAttempts: 2, LockoutThreshold: 3, Window: time.Hour})
+	g.RecordFailure("alice", "192.0.2.1")
+	g.RecordFailure("alice", "192.0.2.1")
+	advance(time.Hour + time.Second)
+	g.RecordFailure("alice", "192.0.2.1") // Would lock at 3 if the old failures still counted
+	if got := g.Wait("alice", "192.0.2.1"); got != 0 {
+		t.Fatalf("old failures still count: wait %v", got)
+	}
+
+	g.RecordFailure("guess-1", "192.0.2.2")
+	advance(time.Hour + time.Second)
+	g.RecordFailure("bob", "192.0.2.3") // Triggers the sweep
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range []string{"user:alice", "user:guess-1", "ip:192.0.2.2"} {
+		if _, ok := g.records[key]; ok {
+			t.Errorf("expired record %s was not swept", key)
+		}
+	}
+	if _, ok := g.records["user:bob"]; !ok {
+		t.Error("live record was swept")
+	}
+}
@@ -4,7 +4,10 @@ package goswift
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httputil" // For HTTP Proxy
@@ -49,7 +52,7 @@ func LoggerMiddleware() MiddlewareFunc {
 			}
 
 			c.engine.Logger.Info("%s %s %s %s - %d %s",
-				logPrefix, c.Request.Method, c.Request.URL.Path, c.Request.RemoteAddr, statusCode, duration)
+				logPrefix, c.Request.Method, c.Request.URL.Path, c.RealIP(), statusCode, duration)
 			return err
 		}
 	}
@@ -73,61 +76,170 @@ func RecoveryMiddleware() MiddlewareFunc {
 }
 
 // AuthMiddleware checks for a valid session and sets the authenticated user ID in the context.
+// The session is also made available through c.Session(), and its idle expiry is slid forward.
 // If no valid session is found, it redirects to the login page.
 func AuthMiddleware(sessionManager *SessionManager, redirectPath string) MiddlewareFunc {
 	return func(next HandlerFunc) HandlerFunc {
 		return func(c *Context) error {
-			sessionID, err := sessionManager.GetSessionIDFromRequest(c.Request)
+			session, err := sessionManager.Load(c.Request)
 			if err != nil {
-				c.engine.Logger.Error("AuthMiddleware: Error getting session ID from request: %v", err)
+				c.engine.Logger.Error("AuthMiddleware: Error getting session from request: %v", err)
 				sessionManager.ClearSessionCookie(c.Writer) // Clear potentially bad cookie
 				c.Redirect(http.StatusFound, redirectPath)
 				return nil // Response handled by redirect
 			}
 
-			session := sessionManager.GetSession(sessionID)
-			if session == nil {
-				// No valid session, redirect to login
+			if session == nil || session.UserID == "" {
+				// No valid (authenticated) session, redirect to login
 				sessionManager.ClearSessionCookie(c.Writer) // Ensure old/expired cookie is cleared
 				c.Redirect(http.StatusFound, redirectPath)
 				return nil // Response handled by redirect
 			}
 
+			// Sliding expiry: record activity before the handler starts writing the response
+			if err := sessionManager.Touch(c.Writer, session); err != nil {
+				c.engine.Logger.Warning("AuthMiddleware: failed to extend session: %v", err)
+			}
+
 			// Session is valid, store UserID in context for handler access
+			c.session, c.sessionMan = session, sessionManager
 			c.Set("userID", session.UserID)
+			c.Set("authMethod", "session")
 			return next(c) // Continue to the next handler
 		}
 	}
 }
 
-// JWTAuthMiddleware validates a JWT from the Authorization header and sets the UserID in context.
-func JWTAuthMiddleware() MiddlewareFunc {
+// ErrNoCredentials is wrapped by the 401 errors auth middleware returns when a request carries
+// no credentials of its kind at all (as opposed to invalid ones). AnyAuth uses it to try the next method.
+var ErrNoCredentials = errors.New("no credentials provided")
+
+// AnyAuth accepts a request authenticated by any of the given auth middlewares, tried in order.
+// The next method is only tried when the previous one found no credentials; invalid credentials
+// are rejected straight away. "authMethod" in the context tells handlers which method succeeded.
+//
+//	api.Use(goswift.AnyAuth(goswift.JWTAuthMiddleware(jwtService), goswift.APIKeyAuth(apiKeys)))
+func AnyAuth(methods ...MiddlewareFunc) MiddlewareFunc {
 	return func(next HandlerFunc) HandlerFunc {
 		return func(c *Context) error {
-			authHeader := c.Request.Header.Get("Authorization")
-			if authHeader == "" {
-				return NewHTTPError(http.StatusUnauthorized, "Authorization header required")
+			reached := false // Set once a method has authenticated the request
+			inner := func(c *Context) error {
+				reached = true
+				return next(c)
+			}
+			for _, method := range methods {
+				err := method(inner)(c)
+				if reached || !errors.Is(err, ErrNoCredentials) {
+					return err // Success, a handler error, or rejected credentials
+				}
 			}
+			return NewHTTPError(http.StatusUnauthorized, "Authentication required", ErrNoCredentials)
+		}
+	}
+}
+
+// JWTAuthMiddleware validates a JWT with the given service and stores the user ID ("userID"),
+// token ID ("tokenID"), full claims ("claims", see c.Claims()) and "authMethod" ("jwt") in the context.
+// A nil service uses DefaultJWTService().
+//
+// lookups controls where the token is read from, tried in order; each entry is one of
+// "header:<name>", "cookie:<name>" or "query:<name>". The default is "header:Authorization",
+// which expects the "Bearer <token>" scheme. Query lookup exists for clients such as
+// EventSource that cannot set headers; prefer limiting it to the routes that need it.
+func JWTAuthMiddleware(service *JWTService, lookups ...string) MiddlewareFunc {
+	if service == nil {
+		service = DefaultJWTService()
+	}
+	if len(lookups) == 0 {
+		lookups = []string{"header:Authorization"}
+	}
+	extractors := make([]tokenExtractor, len(lookups))
+	for i, lookup := range lookups {
+		extractor, err := newTokenExtractor(lookup)
+		if err != nil {
+			panic(fmt.Sprintf("JWTAuthMiddleware: %v", err))
+		}
+		extractors[i] = extractor
+	}
 
-			parts := strings.Split(authHeader, " ")
-			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-				return NewHTTPError(http.StatusUnauthorized, "Authorization header must be in 'Bearer <token>' format")
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			var tokenString string
+			for _, extract := range extractors {
+				token, err := extract(c)
+				if err != nil {
+					return err
+				}
+				if token != "" {
+					tokenString = token
+					break
+				}
+			}
+			if tokenString == "" {
+				if len(lookups) == 1 && lookups[0] == "header:Authorization" {
+					return NewHTTPError(http.StatusUnauthorized, "Authorization header required", ErrNoCredentials)
+				}
+				return NewHTTPError(http.StatusUnauthorized, "Authentication token required", ErrNoCredentials)
 			}
 
-			tokenString := parts[1]
-			claims, err := ValidateJWT(tokenString)
+			claims, err := service.Validate(tokenString)
 			if err != nil {
 				c.engine.Logger.Warning("JWT validation failed: %v", err)
 				return NewHTTPError(http.StatusUnauthorized, "Invalid or expired token")
 			}
 
-			// Token is valid, set UserID in context
+			// Token is valid, expose the user, token ID (for revocation on logout) and claims
 			c.Set("userID", claims.UserID)
+			c.Set("tokenID", claims.ID)
+			c.Set("claims", claims)
+			c.Set("authMethod", "jwt")
 			return next(c)
 		}
 	}
 }
 
+// tokenExtractor reads a token from the request; it returns "" if the source is absent.
+type tokenExtractor func(c *Context) (string, error)
+
+// newTokenExtractor builds an extractor from a "source:name" lookup spec.
+func newTokenExtractor(lookup string) (tokenExtractor, error) {
+	source, name, ok := strings.Cut(lookup, ":")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid token lookup '%s', expected source:name", lookup)
+	}
+	switch source {
+	case "header":
+		if strings.EqualFold(name, "Authorization") {
+			return func(c *Context) (string, error) {
+				authHeader := c.Request.Header.Get("Authorization")
+				if authHeader == "" {
+					return "", nil
+				}
+				parts := strings.Split(authHeader, " ")
+				if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+					return "", NewHTTPError(http.StatusUnauthorized, "Authorization header must be in 'Bearer <token>' format")
+				}
+				return parts[1], nil
+			}, nil
+		}
+		return func(c *Context) (string, error) {
+			return c.Request.Header.Get(name), nil
+		}, nil
+	case "cookie":
+		return func(c *Context) (string, error) {
+			cookie, err := c.Request.Cookie(name)
+			if err != nil {
+				return "", nil // Missing cookie: try the next source
+			}
+			return cookie.Value, nil
+		}, nil
+	case "query":
+		return func(c *Context) (string, error) {
+			return c.Query(name), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown token lookup source '%s'", source)
+}
 
 // TimeoutMiddleware sets a request timeout. If the handler exceeds the timeout, a 504 is returned.
 func TimeoutMiddleware(timeout time.Duration) MiddlewareFunc {
@@ -164,22 +276,77 @@ func TimeoutMiddleware(timeout time.Duration) MiddlewareFunc {
 	}
 }
 
-// BasicAuth authenticates requests using HTTP Basic Authentication.
-// It takes a username and password and returns a HandlerFunc if authentication succeeds,
-// otherwise it returns a 401 Unauthorized response.
+// BasicAuthValidator decides whether a Basic Authentication username/password pair is valid.
+// Returning an error aborts the request with a 500 Internal Server Error.
+type BasicAuthValidator func(user, pass string, c *Context) (bool, error)
+
+// BasicAuth authenticates requests using HTTP Basic Authentication against a single credential.
+// expectedPassword may be plaintext or a hash produced by HashPassword.
+// On success the authenticated username is stored in the context under "username";
+// otherwise a 401 Unauthorized response is returned.
 func BasicAuth(expectedUsername, expectedPassword string, realm string) MiddlewareFunc {
+	return BasicAuthUsers(map[string]string{expectedUsername: expectedPassword}, realm)
+}
+
+// BasicAuthUsers authenticates requests against a map of username to password.
+// Passwords may be plaintext or hashes produced by HashPassword; both are compared in constant time.
+func BasicAuthUsers(users map[string]string, realm string) MiddlewareFunc {
+	accounts := make(map[string]string, len(users)) // Copy so later changes to the map have no effect
+	var dummy string // Compared against for unknown users so they cost the same as known ones
+	for user, pass := range users {
+		accounts[user] = pass
+		if dummy == "" && isPasswordHash(pass) {
+			dummy = pass
+		}
+	}
+
+	return BasicAuthWithValidator(func(user, pass string, c *Context) (bool, error) {
+		expected, ok := accounts[user]
+		if !ok {
+			checkBasicPassword(pass, dummy)
+			return false, nil
+		}
+		return checkBasicPassword(pass, expected), nil
+	}, realm)
+}
+
+// BasicAuthWithValidator authenticates requests using HTTP Basic Authentication and a custom validator,
+// e.g. one that looks users up in a database.
+func BasicAuthWithValidator(validator BasicAuthValidator, realm string) MiddlewareFunc {
 	return func(next HandlerFunc) HandlerFunc {
 		return func(c *Context) error {
 			user, pass, ok := c.Request.BasicAuth()
-			if !ok || user != expectedUsername || pass != expectedPassword {
-				c.Writer.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, realm))
-				return NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+			if ok {
+				valid, err := validator(user, pass, c)
+				if err != nil {
+					return NewHTTPError(http.StatusInternalServerError, "Internal Server Error", err)
+				}
+				if valid {
+					c.Set("username", user) // Expose the authenticated user to handlers
+					c.Set("authMethod", "basic")
+					return next(c)
+				}
 			}
-			return next(c)
+			c.Writer.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, realm))
+			if !ok {
+				return NewHTTPError(http.StatusUnauthorized, "Unauthorized", ErrNoCredentials)
+			}
+			return NewHTTPError(http.StatusUnauthorized, "Unauthorized")
 		}
 	}
 }
 
+// checkBasicPassword compares a supplied password with a plaintext or hashed expected value
+// without leaking timing information about the expected value.
+func checkBasicPassword(pass, expected string) bool {
+	if isPasswordHash(expected) {
+		return CheckPasswordHash(pass, expected)
+	}
+	// Hash both sides first so the comparison does not leak the expected length
+	given, want := sha256.Sum256([]byte(pass)), sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(given[:], want[:]) == 1
+}
+
 // CORSMiddleware provides Cross-Origin Resource Sharing (CORS) support.
 // allowedOrigins can be "*" for any origin, or a comma-separated list of specific origins.
 func CORSMiddleware(allowedOrigins string) MiddlewareFunc {
@@ -201,7 +368,7 @@ func CORSMiddleware(allowedOrigins string) MiddlewareFunc {
 			// Handle preflight OPTIONS requests
 			if c.Request.Method == http.MethodOptions {
 				c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, OPTIONS")
-				c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID") // Add X-Request-ID
+				c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID, Idempotency-Key, X-API-Key")
 				c.Writer.Header().Set("Access-Control-Max-Age", "86400") // Cache preflight for 24 hours
 				c.Writer.WriteHeader(http.StatusNoContent)
 				return nil // Preflight handled
@@ -281,10 +448,21 @@ func Proxy(targetURL string) MiddlewareFunc {
 
 			proxy := httputil.NewSingleHostReverseProxy(remote)
 
+			// Only pass on forwarding headers we received from a trusted proxy;
+			// otherwise a client could spoof its address to the upstream service.
+			trusted := c.fromTrustedProxy()
+			scheme, host := c.Scheme(), c.Host()
+
 			// Modify the request before sending it to the target
 			proxy.Director = func(req *http.Request) {
-				req.Header.Add("X-Forwarded-For", req.RemoteAddr)
-				req.Header.Add("X-Origin-Host", req.Host)
+				if !trusted {
+					req.Header.Del("X-Forwarded-For")
+					req.Header.Del("Forwarded")
+				}
+				// ReverseProxy appends the peer address to X-Forwarded-For itself
+				req.Header.Set("X-Forwarded-Proto", scheme)
+				req.Header.Set("X-Forwarded-Host", host)
+				req.Header.Set("X-Origin-Host", req.Host)
 				req.URL.Scheme = remote.Scheme
 				req.URL.Host = remote.Host
 				req.Host = remote.Host // Important for target server's Host header
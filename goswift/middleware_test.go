@@ -0,0 +1,286 @@
+// go-swift/goswift/middleware_test.go
+package goswift
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// basicAuthEngine serves GET /r behind auth, answering with the authenticated username.
+func basicAuthEngine(auth MiddlewareFunc) *Engine {
+	app := New()
+	app.GET("/r", func(c *Context) error {
+		username, _ := c.Get("username")
+		return c.String(http.StatusOK, "%v", username)
+	}).Before(auth).Handler()
+	return app
+}
+
+// basicAuthRequest sends GET /r with the given credentials, or none if user is empty.
+func basicAuthRequest(app *Engine, user, pass string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/r", nil)
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	return rec
+}
+
+// Plaintext and hashed entries both authenticate; near misses and hashes used as passwords do not.
+func TestBasicAuthUsers(t *testing.T) {
+	bobHash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]string{"alice": "secret", "bob": bobHash}
+	app := basicAuthEngine(BasicAuthUsers(users, "admin"))
+	users["mallory"] = "added later" // The middleware keeps its own copy
+
+	tests := []struct {
+		name, user, pass string
+		want             int
+	}{
+		{"plaintext", "alice", "secret", http.StatusOK},
+		{"plaintext, wrong", "alice", "hunter2", http.StatusUnauthorized},
+		{"plaintext, prefix", "alice", "secre", http.StatusUnauthorized},
+		{"plaintext, longer", "alice", "secret2", http.StatusUnauthorized},
+		{"plaintext, empty", "alice", "", http.StatusUnauthorized},
+		{"hashed", "bob", "hunter2", http.StatusOK},
+		{"hashed, wrong", "bob", "secret", http.StatusUnauthorized},
+		{"hashed, the hash itself", "bob", bobHash, http.StatusUnauthorized},
+		{"unknown user", "carol", "secret", http.StatusUnauthorized},
+		{"added after construction", "mallory", "added later", http.StatusUnauthorized},
+		{"no credentials", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := basicAuthRequest(app, tt.user, tt.pass)
+			if rec.Code != tt.want {
+				t.Fatalf("got %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusOK {
+				if rec.Body.String() != tt.user {
+					t.Fatalf("username = %q, want %q", rec.Body.String(), tt.user)
+				}
+				return
+			}
+			if got, want := rec.Header().Get("WWW-Authenticate"), `Basic realm="admin", charset="UTF-8"`; got != want {
+				t.Fatalf("WWW-Authenticate = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// With hashed entries, unknown users are checked against a hash too, so they are not
+// noticeably faster to reject than known users with a wrong password.
+func TestBasicAuthUnknownUserTiming(t *testing.T) {
+	hash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := basicAuthEngine(BasicAuthUsers(map[string]string{"bob": hash}, "admin"))
+	fastest := func(user string) time.Duration {
+		best := time.Duration(1<<63 - 1)
+		for i := 0; i < 3; i++ {
+			start := time.Now()
+			basicAuthRequest(app, user, "wrong")
+			if d := time.Since(start); d < best {
+				best = d
+			}
+		}
+		return best
+	}
+	known, unknown := fastest("bob"), fastest("carol")
+	if unknown < known/3 {
+		t.Fatalf("unknown user rejected in %v, known user in %v", unknown, known)
+	}
+}
+
+func TestCheckBasicPassword(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		pass, expected string
+		want           bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "Secret", false},
+		{"secret", "secret\x00", false},
+		{"", "", true},
+		{"secret", hash, true},
+		{"Secret", hash, false},
+		{hash, hash, false},
+	}
+	for _, tt := range tests {
+		if got := checkBasicPassword(tt.pass, tt.expected); got != tt.want {
+			t.Errorf("checkBasicPassword(%q, %q) = %v, want %v", tt.pass, tt.expected, got, tt.want)
+		}
+	}
+}
+
+// Validator errors are server errors and never reach the handler; rejections are 401s.
+func TestBasicAuthWithValidator(t *testing.T) {
+	errDown := errors.New("database down")
+	validator := func(user, pass string, c *Context) (bool, error) {
+		if c == nil || c.Request == nil {
+			t.Error("validator called without the request context")
+		}
+		switch user {
+		case "broken":
+			return false, errDown
+		case "alice":
+			return pass == "secret", nil
+		}
+		return false, nil
+	}
+	app := New()
+	var handlerErr error
+	app.SetErrorHandler(func(err error, c *Context) {
+		handlerErr = err
+		defaultErrorHandler(err, c)
+	})
+	app.GET("/r", func(c *Context) error {
+		username, _ := c.Get("username")
+		return c.String(http.StatusOK, "%v", username)
+	}).Before(BasicAuthWithValidator(validator, "admin")).Handler()
+
+	if rec := basicAuthRequest(app, "alice", "secret"); rec.Code != http.StatusOK || rec.Body.String() != "alice" {
+		t.Fatalf("valid credentials: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := basicAuthRequest(app, "alice", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("rejected credentials: got %d, want 401", rec.Code)
+	}
+
+	rec := basicAuthRequest(app, "broken", "secret")
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("validator error: got %d, want 500", rec.Code)
+	}
+	if !errors.Is(handlerErr, errDown) {
+		t.Fatalf("error handler got %v, want it to wrap the validator's error", handlerErr)
+	}
+	if rec.Header().Get("WWW-Authenticate") != "" {
+		t.Fatal("validator error asked for credentials")
+	}
+
+	basicAuthRequest(app, "", "")
+	if !errors.Is(handlerErr, ErrNoCredentials) {
+		t.Fatalf("no credentials: error handler got %v, want ErrNoCredentials", handlerErr)
+	}
+}
+
+// jwtLookupRequest sends GET /r to app with the given request tweaks and returns the response.
+func jwtLookupRequest(app *Engine, prepare func(req *http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/r", nil)
+	prepare(req)
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	return rec
+}
+
+// Token sources are tried in the configured order; the first token found is the one validated.
+func TestJWTAuthMiddlewareLookupOrder(t *testing.T) {
+	s := testJWTService(t, mustJWTKey(NewHMACKey("k1", "HS256", testHMACSecret)))
+	tokens := make(map[string]string)
+	for _, user := range []string{"header", "cookie", "query"} {
+		token, err := s.Generate(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[user] = token
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+	}
+	cookie := func(token string) func(*http.Request) {
+		return func(req *http.Request) { req.AddCookie(&http.Cookie{Name: "access_token", Value: token}) }
+	}
+	query := func(token string) func(*http.Request) {
+		return func(req *http.Request) { req.URL.RawQuery = "token=" + token }
+	}
+
+	var lastErr error
+	newApp := func(lookups ...string) *Engine {
+		app := New()
+		app.SetErrorHandler(func(err error, c *Context) {
+			lastErr = err
+			defaultErrorHandler(err, c)
+		})
+		app.GET("/r", func(c *Context) error {
+			userID, err := c.UserID()
+			if err != nil {
+				return err
+			}
+			return c.String(http.StatusOK, "%s", userID)
+		}).Before(JWTAuthMiddleware(s, lookups...)).Handler()
+		return app
+	}
+	all := newApp("header:Authorization", "cookie:access_token", "query:token")
+	tests := []struct {
+		name     string
+		app      *Engine
+		sources  []func(*http.Request)
+		wantUser string // Empty for a 401
+	}{
+		{"all sources", all, []func(*http.Request){bearer(tokens["header"]), cookie(tokens["cookie"]), query(tokens["query"])}, "header"},
+		{"cookie before query", all, []func(*http.Request){cookie(tokens["cookie"]), query(tokens["query"])}, "cookie"},
+		{"query last", all, []func(*http.Request){query(tokens["query"])}, "query"},
+		{"invalid first token", all, []func(*http.Request){cookie("not-a-token"), query(tokens["query"])}, ""},
+		{"malformed header", all, []func(*http.Request){
+			func(req *http.Request) { req.Header.Set("Authorization", "Basic "+tokens["header"]) },
+			cookie(tokens["cookie"]),
+		}, ""},
+		{"default ignores query", newApp(), []func(*http.Request){query(tokens["query"])}, ""},
+		{"default header", newApp(), []func(*http.Request){bearer(tokens["header"])}, "header"},
+		{"custom header", newApp("header:X-Access-Token"), []func(*http.Request){
+			func(req *http.Request) { req.Header.Set("X-Access-Token", tokens["header"]) },
+		}, "header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := jwtLookupRequest(tt.app, func(req *http.Request) {
+				for _, source := range tt.sources {
+					source(req)
+				}
+			})
+			if tt.wantUser == "" {
+				if rec.Code != http.StatusUnauthorized {
+					t.Fatalf("got %d %q, want 401", rec.Code, rec.Body.String())
+				}
+				return
+			}
+			if rec.Code != http.StatusOK || rec.Body.String() != tt.wantUser {
+				t.Fatalf("got %d %q, want 200 %q", rec.Code, rec.Body.String(), tt.wantUser)
+			}
+		})
+	}
+
+	// Only requests without any token fall through to the next method of AnyAuth
+	jwtLookupRequest(all, func(*http.Request) {})
+	if !errors.Is(lastErr, ErrNoCredentials) {
+		t.Errorf("no token: got %v, want ErrNoCredentials", lastErr)
+	}
+	jwtLookupRequest(all, cookie("not-a-token"))
+	if errors.Is(lastErr, ErrNoCredentials) {
+		t.Errorf("invalid token reported as missing: %v", lastErr)
+	}
+}
+
+func TestJWTAuthMiddlewareInvalidLookup(t *testing.T) {
+	s := testJWTService(t, mustJWTKey(NewHMACKey("k1", "HS256", testHMACSecret)))
+	for _, lookup := range []string{"header", "header:", "form:token"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("lookup %q: no panic", lookup)
+				}
+			}()
+			JWTAuthMiddleware(s, lookup)
+		}()
+	}
+}
@@ -0,0 +1,367 @@
+// go-swift/goswift/netbroker.go
+package goswift
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// brokerFrame is the wire format between NetBroker and BrokerServer: one JSON object per line.
+type brokerFrame struct {
+	Op      string `json:"op"` // "sub", "unsub" or "pub"
+	Topic   string `json:"topic"`
+	Payload []byte `json:"payload,omitempty"`
+}
+
+// brokerWriteTimeout bounds how long a write to a broker connection may block.
+const brokerWriteTimeout = 5 * time.Second
+
+// ParseBrokerURL splits a broker URL such as "tcp://127.0.0.1:7070" or "unix:///run/quikdocs.sock"
+// into the network and address for net.Dial and net.Listen.
+func ParseBrokerURL(rawURL string) (network, addr string, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid broker URL '%s': %w", rawURL, err)
+	}
+	switch u.Scheme {
+	case "tcp", "tcp4", "tcp6":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("broker URL '%s' has no host", rawURL)
+		}
+		return u.Scheme, u.Host, nil
+	case "unix":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("broker URL '%s' has no socket path", rawURL)
+		}
+		return "unix", u.Path, nil
+	default:
+		return "", "", fmt.Errorf("unsupported broker URL scheme '%s' (use tcp or unix)", u.Scheme)
+	}
+}
+
+// --- Server ---
+
+// BrokerServer is a small pub/sub hub for NetBrokers. Every instance of an application connects
+// to the same hub, which forwards each published message to the connections subscribed to its
+// topic. It has no authentication, so listen on localhost, a Unix socket or a private network.
+type BrokerServer struct {
+	Logger    *Logger
+	QueueSize int // Messages buffered per connection; a connection that falls further behind is dropped. 1024 by default.
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*brokerServerConn]struct{}
+	closed    bool
+}
+
+// brokerServerConn is a NetBroker connected to the hub.
+type brokerServerConn struct {
+	conn   net.Conn
+	topics map[string]bool  // Guarded by BrokerServer.mu
+	out    chan brokerFrame // Never closed, so forward can always send without checking
+	done   chan struct{}    // Closed by close, stopping the writer goroutine
+	once   sync.Once
+}
+
+// close closes the connection and stops its writer goroutine.
+func (bc *brokerServerConn) close() {
+	bc.once.Do(func() {
+		bc.conn.Close()
+		close(bc.done)
+	})
+}
+
+// NewBrokerServer creates a hub that logs connections and dropped peers to logger.
+func NewBrokerServer(logger *Logger) *BrokerServer {
+	return &BrokerServer{
+		Logger:    logger,
+		QueueSize: 1024,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*brokerServerConn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the network address and serves until Close.
+func (s *BrokerServer) ListenAndServe(network, addr string) error {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close. It always returns a non-nil error;
+// after Close, ErrBrokerClosed.
+func (s *BrokerServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrBrokerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	s.Logger.Info("Broker: Listening on %s", l.Addr())
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closed {
+				return ErrBrokerClosed
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle serves one connection: it reads frames and forwards published messages.
+func (s *BrokerServer) handle(conn net.Conn) {
+	bc := &brokerServerConn{
+		conn:   conn,
+		topics: make(map[string]bool),
+		out:    make(chan brokerFrame, s.QueueSize),
+		done:   make(chan struct{}),
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[bc] = struct{}{}
+	s.mu.Unlock()
+	s.Logger.Info("Broker: Peer %s connected", conn.RemoteAddr())
+
+	go func() {
+		enc := json.NewEncoder(conn)
+		for {
+			select {
+			case frame := <-bc.out:
+				conn.SetWriteDeadline(time.Now().Add(brokerWriteTimeout))
+				if err := enc.Encode(frame); err != nil {
+					bc.close()
+					return
+				}
+			case <-bc.done:
+				return
+			}
+		}
+	}()
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var frame brokerFrame
+		if err := dec.Decode(&frame); err != nil {
+			break
+		}
+		switch frame.Op {
+		case "sub":
+			s.mu.Lock()
+			bc.topics[frame.Topic] = true
+			s.mu.Unlock()
+		case "unsub":
+			s.mu.Lock()
+			delete(bc.topics, frame.Topic)
+			s.mu.Unlock()
+		case "pub":
+			s.forward(frame)
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.conns, bc)
+	s.mu.Unlock()
+	bc.close()
+	s.Logger.Info("Broker: Peer %s disconnected", conn.RemoteAddr())
+}
+
+// forward queues a published message for every connection subscribed to its topic.
+func (s *BrokerServer) forward(frame brokerFrame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for bc := range s.conns {
+		if !bc.topics[frame.Topic] {
+			continue
+		}
+		select {
+		case bc.out <- frame:
+		default:
+			// The peer cannot keep up; dropping it makes the gap visible (it logs and reconnects)
+			s.Logger.Warning("Broker: Dropping peer %s, %d messages behind", bc.conn.RemoteAddr(), s.QueueSize)
+			delete(s.conns, bc)
+			bc.close()
+		}
+	}
+}
+
+// Close stops the listeners and disconnects all peers.
+func (s *BrokerServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for bc := range s.conns {
+		bc.close()
+	}
+	s.conns = make(map[*brokerServerConn]struct{})
+	return nil
+}
+
+// --- Client ---
+
+// NetBroker is a Broker connected to a BrokerServer over TCP or a Unix socket. If the connection
+// drops it reconnects in the background and restores its subscriptions; messages published
+// meanwhile fail with an error, and messages for this process are lost.
+type NetBroker struct {
+	network, addr string
+	logger        *Logger
+	handlers      brokerHandlers
+
+	mu     sync.Mutex // Guards conn and serializes writes, so subscription frames keep their order
+	conn   net.Conn
+	enc    *json.Encoder
+	closed bool
+}
+
+// DialBroker connects to the BrokerServer at the network address.
+func DialBroker(network, addr string, logger *Logger) (*NetBroker, error) {
+	b := &NetBroker{network: network, addr: addr, logger: logger}
+	conn, err := net.DialTimeout(network, addr, brokerWriteTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to broker: %w", err)
+	}
+	b.conn, b.enc = conn, json.NewEncoder(conn)
+	go b.run(conn)
+	return b, nil
+}
+
+// run dispatches messages from conn, then reconnects until the broker is closed.
+func (b *NetBroker) run(conn net.Conn) {
+	for {
+		dec := json.NewDecoder(bufio.NewReader(conn))
+		for {
+			var frame brokerFrame
+			if err := dec.Decode(&frame); err != nil {
+				break
+			}
+			if frame.Op == "pub" {
+				b.handlers.dispatch(frame.Topic, frame.Payload)
+			}
+		}
+
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return
+		}
+		b.conn, b.enc = nil, nil
+		b.mu.Unlock()
+		conn.Close()
+		b.logger.Warning("Broker: Lost connection to %s, reconnecting", b.addr)
+
+		if conn = b.reconnect(); conn == nil {
+			return
+		}
+	}
+}
+
+// reconnect dials with exponential backoff and resubscribes. It returns nil once the broker is closed.
+func (b *NetBroker) reconnect() net.Conn {
+	delay := 100 * time.Millisecond
+	for {
+		time.Sleep(delay)
+		delay = min(delay*2, 5*time.Second)
+
+		conn, err := net.DialTimeout(b.network, b.addr, brokerWriteTimeout)
+		if err != nil {
+			continue
+		}
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		b.conn, b.enc = conn, json.NewEncoder(conn)
+		for _, topic := range b.handlers.topics() {
+			if err := b.sendLocked(brokerFrame{Op: "sub", Topic: topic}); err != nil {
+				break // The reader notices the broken connection and reconnects again
+			}
+		}
+		b.mu.Unlock()
+		b.logger.Info("Broker: Reconnected to %s", b.addr)
+		return conn
+	}
+}
+
+// sendLocked writes a frame to the connection.
+func (b *NetBroker) sendLocked(frame brokerFrame) error {
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	if b.conn == nil {
+		return errors.New("broker is not connected")
+	}
+	b.conn.SetWriteDeadline(time.Now().Add(brokerWriteTimeout))
+	if err := b.enc.Encode(frame); err != nil {
+		b.conn.Close() // Let the reader reconnect
+		return fmt.Errorf("failed to write to broker: %w", err)
+	}
+	return nil
+}
+
+// Publish implements Broker.
+func (b *NetBroker) Publish(topic string, payload []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sendLocked(brokerFrame{Op: "pub", Topic: topic, Payload: payload})
+}
+
+// Subscribe implements Broker. While disconnected, the subscription is registered locally and
+// sent to the server on reconnect.
+func (b *NetBroker) Subscribe(topic string, handler BrokerHandler) (BrokerSubscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return BrokerSubscription{}, ErrBrokerClosed
+	}
+	sub, first := b.handlers.add(topic, handler)
+	if first && b.conn != nil {
+		b.sendLocked(brokerFrame{Op: "sub", Topic: topic}) // On failure, reconnecting resubscribes
+	}
+	return sub, nil
+}
+
+// Unsubscribe implements Broker.
+func (b *NetBroker) Unsubscribe(sub BrokerSubscription) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if last := b.handlers.remove(sub); last && b.conn != nil && !b.closed {
+		b.sendLocked(brokerFrame{Op: "unsub", Topic: sub.Topic}) // On failure, reconnecting leaves it out anyway
+	}
+	return nil
+}
+
+// Close implements Broker.
+func (b *NetBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	if b.conn != nil {
+		return b.conn.Close()
+	}
+	return nil
+}
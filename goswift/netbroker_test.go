@@ -0,0 +1,178 @@
+// go-swift/goswift/netbroker_test.go
+package goswift
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startBrokerServer serves a BrokerServer on a new listener and returns it with its address.
+func startBrokerServer(t *testing.T, network, addr string) (*BrokerServer, string) {
+	t.Helper()
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewBrokerServer(NewLogger())
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return server, l.Addr().String()
+}
+
+// waitForBrokerSubscribers waits until n peers of the server are subscribed to topic.
+func waitForBrokerSubscribers(t *testing.T, server *BrokerServer, topic string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second) // Covers the NetBroker's reconnect backoff
+	for {
+		server.mu.Lock()
+		got := 0
+		for bc := range server.conns {
+			if bc.topics[topic] {
+				got++
+			}
+		}
+		server.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d peers subscribed to %s, want %d", got, topic, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// brokerTestEngine is an application instance: an Engine whose SSEManager uses a NetBroker.
+// GET /events streams topic "t"; POST /events broadcasts the request body on it.
+func brokerTestEngine(t *testing.T, network, addr string) *httptest.Server {
+	t.Helper()
+	broker, err := DialBroker(network, addr, NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { broker.Close() })
+	sm := NewSSEManagerWithBroker(NewLogger(), broker)
+
+	app := New()
+	app.GET("/events", func(c *Context) error {
+		return sm.Serve(c, "t")
+	}).Handler()
+	app.POST("/events", func(c *Context) error {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return err
+		}
+		sm.Broadcast("t", string(body))
+		return c.NoContent(http.StatusNoContent)
+	}).Handler()
+	srv := httptest.NewServer(app)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// streamSSE connects to an event stream and returns its events; the channel closes when the
+// stream ends.
+func streamSSE(t *testing.T, url string) <-chan SSEEvent {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	events := make(chan SSEEvent, 16)
+	go func() {
+		defer close(events)
+		r := bufio.NewReader(resp.Body)
+		var e SSEEvent
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && e != SSEEvent{}:
+				events <- e
+				e = SSEEvent{}
+			case strings.HasPrefix(line, "id: "):
+				e.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				e.Data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return events
+}
+
+// nextSSEEvent returns the next event of a stream.
+func nextSSEEvent(t *testing.T, events <-chan SSEEvent) SSEEvent {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return SSEEvent{}
+}
+
+// Two engines sharing one BrokerServer deliver each other's broadcasts, also after the server
+// restarts and their NetBrokers reconnect.
+func TestNetBrokerSharedBetweenEngines(t *testing.T) {
+	tests := []struct {
+		network string
+		addr    func(t *testing.T) string
+	}{
+		{"tcp", func(t *testing.T) string { return "127.0.0.1:0" }},
+		{"unix", func(t *testing.T) string { return filepath.Join(t.TempDir(), "broker.sock") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			server, addr := startBrokerServer(t, tt.network, tt.addr(t))
+
+			engine1 := brokerTestEngine(t, tt.network, addr)
+			engine2 := brokerTestEngine(t, tt.network, addr)
+			stream1 := streamSSE(t, engine1.URL+"/events")
+			stream2 := streamSSE(t, engine2.URL+"/events")
+			waitForBrokerSubscribers(t, server, sseBrokerPrefix+"t", 2)
+
+			broadcast := func(engine *httptest.Server, message string) {
+				t.Helper()
+				resp, err := http.Post(engine.URL+"/events", "text/plain", strings.NewReader(message))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusNoContent {
+					t.Fatalf("broadcast: got %d", resp.StatusCode)
+				}
+				e1, e2 := nextSSEEvent(t, stream1), nextSSEEvent(t, stream2)
+				if e1.Data != message || e2.Data != message {
+					t.Fatalf("got %q and %q, want %q on both engines", e1.Data, e2.Data, message)
+				}
+				if e1.ID != e2.ID {
+					t.Fatalf("event IDs differ between engines: %q and %q", e1.ID, e2.ID)
+				}
+			}
+			broadcast(engine1, "from engine 1")
+			broadcast(engine2, "from engine 2")
+
+			// Restart the server on the same address; both NetBrokers reconnect and resubscribe
+			server.Close()
+			restarted, _ := startBrokerServer(t, tt.network, addr)
+			waitForBrokerSubscribers(t, restarted, sseBrokerPrefix+"t", 2)
+			broadcast(engine1, "after reconnect 1")
+			broadcast(engine2, "after reconnect 2")
+		})
+	}
+}
@@ -0,0 +1,548 @@
+// go-swift/goswift/oauth2.go
+package goswift
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// oidcStateCookie binds a pending login to the browser that started it.
+const oidcStateCookie = "goswift_oidc_state"
+
+// oidcLoginTimeout is how long a user has to complete a login at the provider.
+const oidcLoginTimeout = 10 * time.Minute
+
+// OIDCConfig configures an OpenID Connect provider.
+type OIDCConfig struct {
+	Name         string // Short provider name, recorded on identities (e.g. "google")
+	Issuer       string // Issuer URL; discovery is fetched from Issuer + "/.well-known/openid-configuration"
+	ClientID     string
+	ClientSecret string   // Empty for public clients, which rely on PKCE alone
+	RedirectURL  string   // Absolute URL of the callback route
+	Scopes       []string // Defaults to openid, profile and email
+	// HTTPClient is used for discovery, JWKS and token requests. Defaults to a client with a 10s timeout.
+	HTTPClient *http.Client
+}
+
+// OIDCConfigFromConfig reads an OIDCConfig from OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET,
+// OIDC_REDIRECT_URL, OIDC_SCOPES (comma-separated) and OIDC_PROVIDER_NAME.
+func OIDCConfigFromConfig(cm *ConfigManager) OIDCConfig {
+	return OIDCConfig{
+		Name:         configOr(cm, "OIDC_PROVIDER_NAME", "oidc"),
+		Issuer:       cm.Get("OIDC_ISSUER"),
+		ClientID:     cm.Get("OIDC_CLIENT_ID"),
+		ClientSecret: cm.Get("OIDC_CLIENT_SECRET"),
+		RedirectURL:  cm.Get("OIDC_REDIRECT_URL"),
+		Scopes:       splitList(cm.Get("OIDC_SCOPES")),
+	}
+}
+
+// OIDCIdentity is the verified result of a login.
+type OIDCIdentity struct {
+	Provider      string
+	Issuer        string
+	Subject       string // Stable user identifier at the provider; link accounts on Issuer + Subject
+	Email         string
+	EmailVerified bool
+	Name          string
+	Username      string                 // preferred_username claim, if any
+	Claims        map[string]interface{} // All ID token claims
+	AccessToken   string
+	RefreshToken  string
+	IDToken       string
+}
+
+// OIDCLinkFunc maps a verified identity to a local user ID, typically by looking up
+// (or creating) the account linked to Issuer + Subject. Returning an error aborts the login;
+// an HTTPError is passed to the client as-is.
+type OIDCLinkFunc func(c *Context, identity *OIDCIdentity) (userID string, err error)
+
+// oidcDiscovery is the subset of the provider metadata we use.
+type oidcDiscovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// oidcPending is a login started by AuthCodeURL and not yet completed.
+type oidcPending struct {
+	nonce     string
+	verifier  string // PKCE code verifier
+	returnTo  string
+	expiresAt time.Time
+}
+
+// OIDCProvider implements the OpenID Connect authorization code flow with PKCE.
+// Provider metadata and signing keys are fetched lazily and cached.
+type OIDCProvider struct {
+	config OIDCConfig
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]crypto.PublicKey // map[kid]key
+	keysFetched time.Time
+	pending     map[string]oidcPending // map[state]oidcPending
+}
+
+// NewOIDCProvider creates a provider. Discovery happens on first use, so the provider
+// does not need to be reachable at startup.
+func NewOIDCProvider(config OIDCConfig) (*OIDCProvider, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("OIDC issuer, client ID and redirect URL are required")
+	}
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	if config.Name == "" {
+		config.Name = "oidc"
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCProvider{
+		config:  config,
+		keys:    make(map[string]crypto.PublicKey),
+		pending: make(map[string]oidcPending),
+	}, nil
+}
+
+// Name returns the provider name.
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL starts a login: it records a fresh state, nonce and PKCE verifier, binds the
+// state to the browser with a short-lived cookie and returns the provider URL to redirect to.
+// returnTo is kept for the callback (e.g. the page to show after login); it must be a local path.
+func (p *OIDCProvider) AuthCodeURL(c *Context, returnTo string) (string, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+	state, err1 := randomToken()
+	nonce, err2 := randomToken()
+	verifier, err3 := randomToken()
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return "", fmt.Errorf("failed to generate login state: %w", err)
+	}
+	returnTo = localReturnTo(returnTo) // Never redirect off-site after login: the tokens go there
+
+	now := time.Now()
+	p.mu.Lock()
+	for s, pending := range p.pending { // Drop abandoned logins
+		if now.After(pending.expiresAt) {
+			delete(p.pending, s)
+		}
+	}
+	p.pending[state] = oidcPending{nonce: nonce, verifier: verifier, returnTo: returnTo, expiresAt: now.Add(oidcLoginTimeout)}
+	p.mu.Unlock()
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(oidcLoginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode, // Must survive the top-level redirect back from the provider
+	})
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return discovery.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// LoginHandler redirects the browser to the provider. The optional "return_to" query
+// parameter is passed through to the callback.
+func (p *OIDCProvider) LoginHandler(c *Context) error {
+	authURL, err := p.AuthCodeURL(c, c.Query("return_to"))
+	if err != nil {
+		c.engine.Logger.Error("OIDC %s: failed to start login: %v", p.config.Name, err)
+		return NewHTTPError(http.StatusBadGateway, "Identity provider unavailable", err)
+	}
+	c.Redirect(http.StatusFound, authURL)
+	return nil
+}
+
+// Exchange completes a login on the callback route: it checks the state against the browser
+// cookie, redeems the code (with the PKCE verifier) and verifies the ID token.
+// It also returns the returnTo path given to AuthCodeURL.
+func (p *OIDCProvider) Exchange(c *Context) (*OIDCIdentity, string, error) {
+	if errCode := c.Query("error"); errCode != "" {
+		return nil, "", NewHTTPError(http.StatusUnauthorized, "Login was not completed: "+errCode)
+	}
+	state, code := c.Query("state"), c.Query("code")
+	cookie, err := c.Request.Cookie(oidcStateCookie)
+	if state == "" || code == "" || err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		return nil, "", NewHTTPError(http.StatusBadRequest, "Invalid login state")
+	}
+	http.SetCookie(c.Writer, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+
+	p.mu.Lock()
+	pending, ok := p.pending[state]
+	delete(p.pending, state) // States are single-use
+	p.mu.Unlock()
+	if !ok || time.Now().After(pending.expiresAt) {
+		return nil, "", NewHTTPError(http.StatusBadRequest, "Login expired, please try again")
+	}
+
+	tokens, err := p.redeemCode(code, pending.verifier)
+	if err != nil {
+		return nil, "", NewHTTPError(http.StatusBadGateway, "Failed to complete login", err)
+	}
+	identity, err := p.VerifyIDToken(tokens.IDToken, pending.nonce)
+	if err != nil {
+		return nil, "", NewHTTPError(http.StatusUnauthorized, "Invalid ID token", err)
+	}
+	identity.AccessToken = tokens.AccessToken
+	identity.RefreshToken = tokens.RefreshToken
+	return identity, pending.returnTo, nil
+}
+
+// oidcTokenResponse is the token endpoint response (RFC 6749 section 5.1).
+type oidcTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	Error        string `json:"error"`
+	ErrorDesc    string `json:"error_description"`
+}
+
+// redeemCode exchanges an authorization code at the token endpoint.
+func (p *OIDCProvider) redeemCode(code, verifier string) (*oidcTokenResponse, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	var tokens oidcTokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("invalid token response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("token request rejected (status %d): %s %s", resp.StatusCode, tokens.Error, tokens.ErrorDesc)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no id_token; is the openid scope requested?")
+	}
+	return &tokens, nil
+}
+
+// oidcIDClaims are the ID token claims we interpret; all claims are also kept in a map.
+type oidcIDClaims struct {
+	Nonce         string `json:"nonce"`
+	AuthorizedBy  string `json:"azp"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Username      string `json:"preferred_username"`
+	jwt.RegisteredClaims
+}
+
+// VerifyIDToken checks an ID token's signature against the provider's JWKS and validates its
+// issuer, audience, expiry and nonce (OpenID Connect Core section 3.1.3.7).
+func (p *OIDCProvider) VerifyIDToken(idToken, nonce string) (*OIDCIdentity, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	algs := discovery.SigningAlgs
+	if len(algs) == 0 {
+		algs = []string{"RS256"} // The default required by the spec
+	}
+	var allowed []string
+	for _, alg := range algs {
+		if alg != "none" && !strings.HasPrefix(alg, "HS") { // Only public-key signatures can be verified via JWKS
+			allowed = append(allowed, alg)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, errors.New("provider advertises no supported ID token signing algorithms")
+	}
+
+	var claims oidcIDClaims
+	_, err = jwt.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.keyFor(kid)
+	},
+		jwt.WithValidMethods(allowed),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedBy != p.config.ClientID {
+		return nil, errors.New("ID token was issued to another client")
+	}
+	if nonce != "" && subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+
+	all := make(map[string]interface{})
+	parts := strings.Split(idToken, ".")
+	if payload, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil {
+		json.Unmarshal(payload, &all)
+	}
+	return &OIDCIdentity{
+		Provider:      p.config.Name,
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		Username:      claims.Username,
+		Claims:        all,
+		IDToken:       idToken,
+	}, nil
+}
+
+// getDiscovery returns the cached provider metadata, fetching it on first use.
+func (p *OIDCProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	discovery := p.discovery
+	p.mu.Unlock()
+	if discovery != nil {
+		return discovery, nil
+	}
+
+	discovery = &oidcDiscovery{}
+	if err := p.getJSON(p.config.Issuer+"/.well-known/openid-configuration", discovery); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("OIDC discovery issuer '%s' does not match '%s'", discovery.Issuer, p.config.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is missing required endpoints")
+	}
+
+	p.mu.Lock()
+	p.discovery = discovery
+	p.mu.Unlock()
+	return discovery, nil
+}
+
+// keyFor returns the provider key with the given ID, refreshing the JWKS when the key is
+// unknown (the provider rotated keys). Refreshes are limited to one per minute.
+func (p *OIDCProvider) keyFor(kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.lookupKey(kid)
+	stale := time.Since(p.keysFetched) > time.Minute
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown signing key '%s'", kid)
+	}
+
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	var set JWKSet
+	if err := p.getJSON(discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			continue // Skip key types we don't support rather than failing the whole set
+		}
+		keys[jwk.Kid] = pub
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+	p.keysFetched = time.Now()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key '%s'", kid)
+}
+
+// lookupKey finds a cached key. Tokens without a kid are accepted only if the set has a single key.
+// The caller must hold p.mu.
+func (p *OIDCProvider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// getJSON fetches and decodes a JSON document.
+func (p *OIDCProvider) getJSON(target string, v interface{}) error {
+	resp, err := p.config.HTTPClient.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// SessionCallback returns a callback handler that completes the login, links the identity
+// to a local user and logs the user in with a regenerated session, then redirects to the
+// returnTo path given at login.
+func (p *OIDCProvider) SessionCallback(link OIDCLinkFunc) HandlerFunc {
+	return func(c *Context) error {
+		identity, returnTo, userID, err := p.complete(c, link)
+		if err != nil {
+			return err
+		}
+		session, err := c.Session()
+		if err != nil {
+			return NewHTTPError(http.StatusInternalServerError, "Failed to create session", err)
+		}
+		session.UserID = userID
+		session.Set("authProvider", identity.Provider)
+		if err := c.RegenerateSession(); err != nil { // Also saves the session
+			return NewHTTPError(http.StatusInternalServerError, "Failed to create session", err)
+		}
+		c.Redirect(http.StatusFound, returnTo)
+		return nil
+	}
+}
+
+// JWTCallback returns a callback handler that completes the login, links the identity to a
+// local user and issues a token pair from service (nil uses DefaultJWTService()).
+// The tokens are passed to the returnTo page in the URL fragment, which browsers do not send
+// to servers, as access_token, refresh_token and expires_in.
+func (p *OIDCProvider) JWTCallback(link OIDCLinkFunc, service *JWTService) HandlerFunc {
+	if service == nil {
+		service = DefaultJWTService()
+	}
+	return func(c *Context) error {
+		_, returnTo, userID, err := p.complete(c, link)
+		if err != nil {
+			return err
+		}
+		pair, err := service.IssueTokens(userID)
+		if err != nil {
+			return NewHTTPError(http.StatusInternalServerError, "Failed to generate token", err)
+		}
+		fragment := url.Values{
+			"access_token":  {pair.AccessToken},
+			"refresh_token": {pair.RefreshToken},
+			"expires_in":    {fmt.Sprint(pair.ExpiresIn)},
+		}
+		c.Writer.Header().Set("Cache-Control", "no-store")
+		c.Redirect(http.StatusFound, returnTo+"#"+fragment.Encode())
+		return nil
+	}
+}
+
+// complete runs Exchange and the link callback, logging the outcome.
+func (p *OIDCProvider) complete(c *Context, link OIDCLinkFunc) (*OIDCIdentity, string, string, error) {
+	identity, returnTo, err := p.Exchange(c)
+	if err != nil {
+		c.engine.Logger.Warning("OIDC %s: login failed: %v", p.config.Name, err)
+		return nil, "", "", err
+	}
+	userID, err := link(c, identity)
+	if err != nil {
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) {
+			err = NewHTTPError(http.StatusInternalServerError, "Failed to link account", err)
+		}
+		c.engine.Logger.Warning("OIDC %s: account linking failed for subject %s: %v", p.config.Name, identity.Subject, err)
+		return nil, "", "", err
+	}
+	c.engine.Logger.Info("OIDC %s: subject %s logged in as user %s", p.config.Name, identity.Subject, userID)
+	return identity, returnTo, userID, nil
+}
+
+// localReturnTo returns returnTo if it is a path on this site, or "/" otherwise. Browsers read
+// "/\evil.com" as "//evil.com" and drop tabs and newlines, so backslashes and control characters
+// are refused along with anything that has a scheme or host. So are fragments, since JWTCallback
+// appends the tokens as one.
+func localReturnTo(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") ||
+		strings.ContainsAny(returnTo, "\\#") || strings.IndexFunc(returnTo, unicode.IsControl) >= 0 {
+		return "/"
+	}
+	u, err := url.Parse(returnTo)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return "/"
+	}
+	return returnTo
+}
+
+// randomToken returns 32 random bytes, base64url-encoded.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
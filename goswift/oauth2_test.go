@@ -0,0 +1,255 @@
+// go-swift/goswift/oauth2_test.go
+package goswift
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockOIDCServer is a minimal OpenID provider: its authorize endpoint approves every login
+// straight away, and its token endpoint checks the PKCE verifier before issuing an ID token.
+type mockOIDCServer struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+
+	mu       sync.Mutex
+	codes    map[string]url.Values // map[code]authorize request
+	badNonce bool                  // Issue ID tokens with the wrong nonce
+}
+
+func newMockOIDCServer(t *testing.T, clientID string) *mockOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDCServer{key: key, clientID: clientID, codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwk, _ := publicJWK(&JWTKey{ID: "mock", Method: jwt.SigningMethodRS256, VerifyKey: &key.PublicKey})
+		json.NewEncoder(w).Encode(JWKSet{Keys: []JWK{jwk}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		code, _ := randomToken()
+		m.mu.Lock()
+		m.codes[code] = r.URL.Query()
+		m.mu.Unlock()
+		target := r.URL.Query().Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {r.URL.Query().Get("state")}}.Encode()
+		http.Redirect(w, r, target, http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.mu.Lock()
+		auth, ok := m.codes[r.PostForm.Get("code")]
+		delete(m.codes, r.PostForm.Get("code"))
+		badNonce := m.badNonce
+		m.mu.Unlock()
+		challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || auth.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		nonce := auth.Get("nonce")
+		if badNonce {
+			nonce = "forged"
+		}
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            m.URL,
+			"aud":            m.clientID,
+			"sub":            "subject-1",
+			"email":          "alice@example.com",
+			"email_verified": true,
+			"nonce":          nonce,
+			"iat":            now.Unix(),
+			"exp":            now.Add(time.Minute).Unix(),
+		})
+		token.Header["kid"] = "mock"
+		idToken, _ := token.SignedString(m.key)
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// oidcTestApp serves the login and callback routes of a provider backed by mock.
+func oidcTestApp(t *testing.T, mock *mockOIDCServer) (*Engine, *JWTService, *OIDCIdentity) {
+	provider, err := NewOIDCProvider(OIDCConfig{
+		Name:        "mock",
+		Issuer:      mock.URL,
+		ClientID:    mock.clientID,
+		RedirectURL: "http://app.test/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewJWTService("app", []string{"app"}, time.Minute)
+	key, _ := NewHMACKey("k1", "HS256", []byte("0123456789abcdef0123456789abcdef"))
+	service.AddKey(key)
+
+	linked := &OIDCIdentity{}
+	app := New()
+	app.GET("/login", provider.LoginHandler).Handler()
+	app.GET("/callback", provider.JWTCallback(func(c *Context, identity *OIDCIdentity) (string, error) {
+		*linked = *identity
+		return "user-1", nil
+	}, service)).Handler()
+	return app, service, linked
+}
+
+// startOIDCLogin starts a login and follows the provider's redirect, returning the callback
+// request path and the state cookie.
+func startOIDCLogin(t *testing.T, app *Engine, returnTo string) (string, *http.Cookie) {
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login?return_to="+url.QueryEscape(returnTo), nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: got %d: %s", rec.Code, rec.Body.String())
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcStateCookie {
+		t.Fatalf("login: expected the state cookie, got %v", cookies)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback.RequestURI(), cookies[0]
+}
+
+func callOIDCCallback(app *Engine, path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOIDCLoginIssuesTokens(t *testing.T) {
+	mock := newMockOIDCServer(t, "client-1")
+	app, service, linked := oidcTestApp(t, mock)
+
+	path, cookie := startOIDCLogin(t, app, "/docs?tab=shared")
+	rec := callOIDCCallback(app, path, cookie)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback: got %d: %s", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+	target, fragment, _ := strings.Cut(location, "#")
+	if target != "/docs?tab=shared" {
+		t.Fatalf("redirected to %q, want the return_to path", location)
+	}
+	values, _ := url.ParseQuery(fragment)
+	claims, err := service.Validate(values.Get("access_token"))
+	if err != nil || claims.UserID != "user-1" {
+		t.Fatalf("access token: %v, %+v", err, claims)
+	}
+	if linked.Subject != "subject-1" || linked.Email != "alice@example.com" || !linked.EmailVerified || linked.Provider != "mock" {
+		t.Fatalf("unexpected identity %+v", linked)
+	}
+
+	// States are single-use
+	if rec := callOIDCCallback(app, path, cookie); rec.Code != http.StatusBadRequest {
+		t.Fatalf("replayed callback: got %d, want 400", rec.Code)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(mock *mockOIDCServer, path string, cookie *http.Cookie) (string, *http.Cookie)
+		want  int
+	}{
+		{"missing state cookie", func(_ *mockOIDCServer, path string, _ *http.Cookie) (string, *http.Cookie) {
+			return path, nil
+		}, http.StatusBadRequest},
+		{"cookie from another login", func(_ *mockOIDCServer, path string, cookie *http.Cookie) (string, *http.Cookie) {
+			return path, &http.Cookie{Name: cookie.Name, Value: "other"}
+		}, http.StatusBadRequest},
+		{"provider error", func(_ *mockOIDCServer, _ string, cookie *http.Cookie) (string, *http.Cookie) {
+			return "/callback?error=access_denied&state=" + cookie.Value, cookie
+		}, http.StatusUnauthorized},
+		{"unknown code", func(_ *mockOIDCServer, _ string, cookie *http.Cookie) (string, *http.Cookie) {
+			return "/callback?code=bogus&state=" + cookie.Value, cookie
+		}, http.StatusBadGateway},
+		{"nonce mismatch", func(mock *mockOIDCServer, path string, cookie *http.Cookie) (string, *http.Cookie) {
+			mock.mu.Lock()
+			mock.badNonce = true
+			mock.mu.Unlock()
+			return path, cookie
+		}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := newMockOIDCServer(t, "client-1")
+			app, _, _ := oidcTestApp(t, mock)
+			path, cookie := startOIDCLogin(t, app, "/")
+			path, cookie = tt.setup(mock, path, cookie)
+			if rec := callOIDCCallback(app, path, cookie); rec.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestOIDCReturnToStaysLocal(t *testing.T) {
+	tests := []struct{ returnTo, want string }{
+		{"/docs", "/docs"},
+		{"/docs/1?tab=history", "/docs/1?tab=history"},
+		{"", "/"},
+		{"docs", "/"},
+		{"//evil.com", "/"},
+		{"/\\evil.com", "/"},
+		{"/\\/evil.com", "/"},
+		{"/\t/evil.com", "/"},
+		{"/\n/evil.com", "/"},
+		{"https://evil.com", "/"},
+		{"javascript:alert(1)", "/"},
+		{"/docs#section", "/"},
+	}
+	for _, tt := range tests {
+		if got := localReturnTo(tt.returnTo); got != tt.want {
+			t.Errorf("localReturnTo(%q) = %q, want %q", tt.returnTo, got, tt.want)
+		}
+	}
+
+	// End to end: the tokens never leave the site
+	mock := newMockOIDCServer(t, "client-1")
+	app, _, _ := oidcTestApp(t, mock)
+	path, cookie := startOIDCLogin(t, app, "/\\evil.com")
+	rec := callOIDCCallback(app, path, cookie)
+	if location := rec.Header().Get("Location"); !strings.HasPrefix(location, "/#access_token=") {
+		t.Fatalf("redirected to %q", location)
+	}
+}
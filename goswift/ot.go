@@ -0,0 +1,369 @@
+// go-swift/goswift/ot.go
+package goswift
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrTextOperationLength is returned when an operation does not fit the text or operation it
+// is applied to, composed with or transformed against.
+var ErrTextOperationLength = errors.New("text operation length mismatch")
+
+// TextOp is one component of a TextOperation. Exactly one field is set. Lengths count Unicode
+// code points, not bytes.
+type TextOp struct {
+	Retain int    // Keep this many characters
+	Insert string // Insert this text
+	Delete int    // Remove this many characters
+}
+
+// TextOperation is an edit of a whole text: its components walk the text from start to end,
+// retaining, inserting and deleting characters. Build operations with Retain, Insert and Delete,
+// which keep them canonical (adjacent components merged, inserts before deletes). Like append,
+// they may reuse o's storage, so keep building on the result only.
+//
+// In JSON an operation is an array in the format of ot.js: a positive number retains, a negative
+// number deletes and a string inserts, e.g. [5, " world", -3].
+type TextOperation []TextOp
+
+// Retain returns o followed by keeping n characters.
+func (o TextOperation) Retain(n int) TextOperation {
+	if n <= 0 {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].Retain > 0 {
+		o[last].Retain += n
+		return o
+	}
+	return append(o, TextOp{Retain: n})
+}
+
+// Insert returns o followed by inserting text.
+func (o TextOperation) Insert(text string) TextOperation {
+	if text == "" {
+		return o
+	}
+	last := len(o) - 1
+	switch {
+	case last >= 0 && o[last].Insert != "":
+		o[last].Insert += text
+	case last >= 0 && o[last].Delete > 0: // Inserts go before deletes, so equal edits look the same
+		if last > 0 && o[last-1].Insert != "" {
+			o[last-1].Insert += text
+		} else {
+			o = append(o, o[last])
+			o[last] = TextOp{Insert: text}
+		}
+	default:
+		o = append(o, TextOp{Insert: text})
+	}
+	return o
+}
+
+// Delete returns o followed by removing n characters.
+func (o TextOperation) Delete(n int) TextOperation {
+	if n <= 0 {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].Delete > 0 {
+		o[last].Delete += n
+		return o
+	}
+	return append(o, TextOp{Delete: n})
+}
+
+// BaseLen returns the length of the texts o applies to.
+func (o TextOperation) BaseLen() int {
+	n := 0
+	for _, op := range o {
+		n += op.Retain + op.Delete
+	}
+	return n
+}
+
+// TargetLen returns the length of the texts o produces.
+func (o TextOperation) TargetLen() int {
+	n := 0
+	for _, op := range o {
+		n += op.Retain + utf8.RuneCountInString(op.Insert)
+	}
+	return n
+}
+
+// IsNoop reports whether o leaves texts unchanged.
+func (o TextOperation) IsNoop() bool {
+	return len(o) == 0 || (len(o) == 1 && o[0].Retain > 0)
+}
+
+// Apply returns text edited by o.
+func (o TextOperation) Apply(text string) (string, error) {
+	runes := []rune(text)
+	if len(runes) != o.BaseLen() {
+		return "", ErrTextOperationLength
+	}
+	var b strings.Builder
+	pos := 0
+	for _, op := range o {
+		switch {
+		case op.Retain > 0:
+			b.WriteString(string(runes[pos : pos+op.Retain]))
+			pos += op.Retain
+		case op.Insert != "":
+			b.WriteString(op.Insert)
+		default:
+			pos += op.Delete
+		}
+	}
+	return b.String(), nil
+}
+
+// Compose returns a single operation with the effect of o followed by next.
+func (o TextOperation) Compose(next TextOperation) (TextOperation, error) {
+	if o.TargetLen() != next.BaseLen() {
+		return nil, ErrTextOperationLength
+	}
+	var result TextOperation
+	a, b := newTextOpCursor(o), newTextOpCursor(next)
+	for !a.done() || !b.done() {
+		switch {
+		case a.peek().Delete > 0: // Deleted by o: next never sees it
+			result = result.Delete(a.take(math.MaxInt).Delete)
+		case b.peek().Insert != "": // Inserted by next: o never saw it
+			result = result.Insert(b.take(math.MaxInt).Insert)
+		default:
+			n := min(a.peek().length(), b.peek().length())
+			opA, opB := a.take(n), b.take(n)
+			switch {
+			case opB.Delete > 0: // Whatever o kept or inserted here, next removes
+				if opA.Retain > 0 {
+					result = result.Delete(n)
+				}
+			case opA.Insert != "":
+				result = result.Insert(opA.Insert)
+			default:
+				result = result.Retain(n)
+			}
+		}
+	}
+	return result, nil
+}
+
+// TransformText transforms two operations made concurrently on the same text, so that applying
+// a then bPrime gives the same text as b then aPrime. When both insert at the same position, a's
+// text comes first.
+func TransformText(a, b TextOperation) (aPrime, bPrime TextOperation, err error) {
+	if a.BaseLen() != b.BaseLen() {
+		return nil, nil, ErrTextOperationLength
+	}
+	ca, cb := newTextOpCursor(a), newTextOpCursor(b)
+	for !ca.done() || !cb.done() {
+		switch {
+		case ca.peek().Insert != "":
+			text := ca.take(math.MaxInt).Insert
+			aPrime, bPrime = aPrime.Insert(text), bPrime.Retain(utf8.RuneCountInString(text))
+		case cb.peek().Insert != "":
+			text := cb.take(math.MaxInt).Insert
+			aPrime, bPrime = aPrime.Retain(utf8.RuneCountInString(text)), bPrime.Insert(text)
+		default:
+			n := min(ca.peek().length(), cb.peek().length())
+			opA, opB := ca.take(n), cb.take(n)
+			switch {
+			case opA.Retain > 0 && opB.Retain > 0:
+				aPrime, bPrime = aPrime.Retain(n), bPrime.Retain(n)
+			case opA.Delete > 0 && opB.Retain > 0:
+				aPrime = aPrime.Delete(n)
+			case opA.Retain > 0 && opB.Delete > 0:
+				bPrime = bPrime.Delete(n)
+			} // Both deleted the same text: nothing left to do
+		}
+	}
+	return aPrime, bPrime, nil
+}
+
+// DiffText returns an operation turning from into to, replacing the part between their common
+// prefix and suffix.
+func DiffText(from, to string) TextOperation {
+	a, b := []rune(from), []rune(to)
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	return TextOperation{}.
+		Retain(prefix).
+		Insert(string(b[prefix : len(b)-suffix])).
+		Delete(len(a) - prefix - suffix).
+		Retain(suffix)
+}
+
+// MarshalJSON encodes o in the ot.js format.
+func (o TextOperation) MarshalJSON() ([]byte, error) {
+	items := make([]interface{}, 0, len(o))
+	for _, op := range o {
+		switch {
+		case op.Retain > 0:
+			items = append(items, op.Retain)
+		case op.Insert != "":
+			items = append(items, op.Insert)
+		default:
+			items = append(items, -op.Delete)
+		}
+	}
+	return json.Marshal(items)
+}
+
+// UnmarshalJSON decodes an operation in the ot.js format, merging adjacent components.
+func (o *TextOperation) UnmarshalJSON(data []byte) error {
+	var items []interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	result := TextOperation{}
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			result = result.Insert(v)
+		case float64:
+			if v != math.Trunc(v) || math.Abs(v) > math.MaxInt32 {
+				return fmt.Errorf("invalid text operation component %v", v)
+			}
+			if v > 0 {
+				result = result.Retain(int(v))
+			} else {
+				result = result.Delete(int(-v))
+			}
+		default:
+			return fmt.Errorf("invalid text operation component %v", item)
+		}
+	}
+	*o = result
+	return nil
+}
+
+// length returns how many characters of the base text (or, for inserts, of the inserted text)
+// a component covers.
+func (op TextOp) length() int {
+	if op.Insert != "" {
+		return utf8.RuneCountInString(op.Insert)
+	}
+	return op.Retain + op.Delete
+}
+
+// textOpCursor walks an operation's components, splitting them where the other operation's
+// components end.
+type textOpCursor struct {
+	ops  TextOperation
+	head TextOp // What is left of ops[0]
+}
+
+func newTextOpCursor(o TextOperation) *textOpCursor {
+	c := &textOpCursor{ops: o}
+	if len(o) > 0 {
+		c.head = o[0]
+	}
+	return c
+}
+
+func (c *textOpCursor) done() bool { return len(c.ops) == 0 }
+
+// peek returns the rest of the current component; the zero TextOp once done, which behaves
+// like neither an insert nor a delete.
+func (c *textOpCursor) peek() TextOp { return c.head }
+
+// take consumes up to n characters of the current component.
+func (c *textOpCursor) take(n int) TextOp {
+	op := c.head
+	if op.length() > n {
+		switch {
+		case op.Retain > 0:
+			c.head.Retain -= n
+			return TextOp{Retain: n}
+		case op.Delete > 0:
+			c.head.Delete -= n
+			return TextOp{Delete: n}
+		default:
+			runes := []rune(op.Insert)
+			c.head.Insert = string(runes[n:])
+			return TextOp{Insert: string(runes[:n])}
+		}
+	}
+	c.ops = c.ops[1:]
+	c.head = TextOp{}
+	if len(c.ops) > 0 {
+		c.head = c.ops[0]
+	}
+	return op
+}
+
+// OTDocument is a text edited concurrently through operational transformation. Every applied
+// operation increments the revision. Clients send operations together with the revision they
+// were made on; operations applied since are transformed in, so concurrent edits never overwrite
+// each other and every client that applies the same operations converges on the same text.
+// The last HistoryLimit operations are kept for that; older revisions must resync.
+type OTDocument struct {
+	mu       sync.Mutex
+	content  string
+	revision int
+	history  []TextOperation // history[i] turned revision revision-len(history)+i into the next
+	limit    int
+}
+
+// NewOTDocument creates a document with content at revision, keeping the last historyLimit
+// operations (1000 if historyLimit <= 0).
+func NewOTDocument(content string, revision, historyLimit int) *OTDocument {
+	if historyLimit <= 0 {
+		historyLimit = 1000
+	}
+	return &OTDocument{content: content, revision: revision, limit: historyLimit}
+}
+
+// Apply transforms op, made on revision, against the operations applied since, and applies it.
+// It returns the operation as applied, which is what other clients need, and the new revision.
+// Errors are HTTPErrors: 400 for an operation that does not fit, 409 when revision is too old
+// and the client must reload.
+func (d *OTDocument) Apply(revision int, op TextOperation) (TextOperation, int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if revision > d.revision || revision < 0 {
+		return nil, 0, NewHTTPError(http.StatusBadRequest, "Unknown revision")
+	}
+	missed := d.revision - revision
+	if missed > len(d.history) {
+		return nil, 0, NewHTTPError(http.StatusConflict, "Revision is too old, reload the document")
+	}
+	for _, applied := range d.history[len(d.history)-missed:] {
+		var err error
+		if op, _, err = TransformText(op, applied); err != nil {
+			return nil, 0, NewHTTPError(http.StatusBadRequest, "Operation does not match the revision", err)
+		}
+	}
+	content, err := op.Apply(d.content)
+	if err != nil {
+		return nil, 0, NewHTTPError(http.StatusBadRequest, "Operation does not match the revision", err)
+	}
+
+	d.content = content
+	d.revision++
+	d.history = append(d.history, op)
+	if len(d.history) > d.limit {
+		d.history = append(d.history[:0:0], d.history[len(d.history)-d.limit:]...) // Let the old array go
+	}
+	return op, d.revision, nil
+}
+
+// Snapshot returns the current content and revision.
+func (d *OTDocument) Snapshot() (content string, revision int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.content, d.revision
+}
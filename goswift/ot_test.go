@@ -0,0 +1,304 @@
+// go-swift/goswift/ot_test.go
+package goswift
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+// otTestSeeds is how many random cases each property is checked on. Failures report the seed,
+// so a case can be replayed on its own.
+const otTestSeeds = 2000
+
+// otAlphabet mixes ASCII with multi-byte characters, since lengths count code points.
+var otAlphabet = []rune("ab xé漢🙂")
+
+// randomText returns up to maxLen random characters.
+func randomText(r *rand.Rand, maxLen int) string {
+	runes := make([]rune, r.Intn(maxLen+1))
+	for i := range runes {
+		runes[i] = otAlphabet[r.Intn(len(otAlphabet))]
+	}
+	return string(runes)
+}
+
+// randomOp returns a random operation on text.
+func randomOp(r *rand.Rand, text string) TextOperation {
+	op := TextOperation{}
+	n := utf8.RuneCountInString(text)
+	for pos := 0; pos < n; {
+		k := 1 + r.Intn(n-pos)
+		switch r.Intn(3) {
+		case 0:
+			op = op.Retain(k)
+			pos += k
+		case 1:
+			op = op.Delete(k)
+			pos += k
+		default:
+			op = op.Insert(randomText(r, 3))
+		}
+	}
+	if r.Intn(2) == 0 {
+		op = op.Insert(randomText(r, 3))
+	}
+	return op
+}
+
+// applyOp applies op to text, failing the test if it does not fit.
+func applyOp(t *testing.T, seed int64, op TextOperation, text string) string {
+	t.Helper()
+	result, err := op.Apply(text)
+	if err != nil {
+		t.Fatalf("seed %d: applying %v to %q: %v", seed, op, text, err)
+	}
+	return result
+}
+
+// Concurrent operations converge once transformed: a then b' equals b then a' (TP1).
+func TestTransformTextConverges(t *testing.T) {
+	for seed := int64(0); seed < otTestSeeds; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		text := randomText(r, 12)
+		a, b := randomOp(r, text), randomOp(r, text)
+		aPrime, bPrime, err := TransformText(a, b)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		viaA := applyOp(t, seed, bPrime, applyOp(t, seed, a, text))
+		viaB := applyOp(t, seed, aPrime, applyOp(t, seed, b, text))
+		if viaA != viaB {
+			t.Fatalf("seed %d: text %q, a %v, b %v: a∘b' = %q but b∘a' = %q", seed, text, a, b, viaA, viaB)
+		}
+	}
+}
+
+// Composing operations has the effect of applying them one after the other.
+func TestComposeMatchesSequentialApply(t *testing.T) {
+	for seed := int64(0); seed < otTestSeeds; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		text := randomText(r, 12)
+		a := randomOp(r, text)
+		middle := applyOp(t, seed, a, text)
+		b := randomOp(r, middle)
+		composed, err := a.Compose(b)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if got, want := applyOp(t, seed, composed, text), applyOp(t, seed, b, middle); got != want {
+			t.Fatalf("seed %d: text %q, a %v, b %v: composed %v gives %q, want %q", seed, text, a, b, composed, got, want)
+		}
+		if composed.BaseLen() != a.BaseLen() || composed.TargetLen() != b.TargetLen() {
+			t.Fatalf("seed %d: composed lengths %d->%d, want %d->%d", seed, composed.BaseLen(), composed.TargetLen(), a.BaseLen(), b.TargetLen())
+		}
+	}
+}
+
+// Operations survive the ot.js JSON format, and DiffText turns any text into any other.
+func TestTextOperationJSONAndDiff(t *testing.T) {
+	for seed := int64(0); seed < otTestSeeds; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		text := randomText(r, 12)
+		op := randomOp(r, text)
+		data, err := json.Marshal(op)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		var decoded TextOperation
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("seed %d: decoding %s: %v", seed, data, err)
+		}
+		if len(op) > 0 && !reflect.DeepEqual(decoded, op) {
+			t.Fatalf("seed %d: %s decoded to %v, want %v", seed, data, decoded, op)
+		}
+
+		to := randomText(r, 12)
+		if got := applyOp(t, seed, DiffText(text, to), text); got != to {
+			t.Fatalf("seed %d: DiffText(%q, %q) gives %q", seed, text, to, got)
+		}
+	}
+
+	for _, bad := range []string{`[1.5]`, `[true]`, `{"retain": 1}`, `[1e12]`} {
+		var op TextOperation
+		if err := json.Unmarshal([]byte(bad), &op); err == nil {
+			t.Errorf("decoding %s: got %v, want an error", bad, op)
+		}
+	}
+}
+
+// otTestMessage is what the server sends a client: an operation applied at revision, or the
+// acknowledgement of the client's own.
+type otTestMessage struct {
+	op       TextOperation
+	revision int
+	ack      bool
+}
+
+// otTestClient is an editor following the ot.js client protocol: at most one operation is
+// awaiting acknowledgement, and edits made meanwhile are buffered into one.
+type otTestClient struct {
+	text     string
+	revision int
+	awaiting TextOperation // Sent, not yet acknowledged
+	waiting  bool
+	buffer   TextOperation // Made while waiting, not yet sent
+	buffered bool
+	outbox   []otTestMessage // To the server; revision is the one the operation was made on
+	inbox    []otTestMessage // From the server
+}
+
+// edit applies a local edit and sends or buffers it.
+func (c *otTestClient) edit(t *testing.T, seed int64, op TextOperation) {
+	c.text = applyOp(t, seed, op, c.text)
+	switch {
+	case !c.waiting:
+		c.outbox = append(c.outbox, otTestMessage{op: op, revision: c.revision})
+		c.awaiting, c.waiting = op, true
+	case c.buffered:
+		composed, err := c.buffer.Compose(op)
+		if err != nil {
+			t.Fatalf("seed %d: composing the buffer: %v", seed, err)
+		}
+		c.buffer = composed
+	default:
+		c.buffer, c.buffered = op, true
+	}
+}
+
+// receive handles a message from the server.
+func (c *otTestClient) receive(t *testing.T, seed int64, msg otTestMessage) {
+	c.revision = msg.revision
+	if msg.ack {
+		c.awaiting, c.waiting = nil, false
+		if c.buffered {
+			c.outbox = append(c.outbox, otTestMessage{op: c.buffer, revision: c.revision})
+			c.awaiting, c.waiting = c.buffer, true
+			c.buffer, c.buffered = nil, false
+		}
+		return
+	}
+	op := msg.op
+	var err error
+	if c.waiting {
+		if c.awaiting, op, err = TransformText(c.awaiting, op); err != nil {
+			t.Fatalf("seed %d: transforming against the awaited operation: %v", seed, err)
+		}
+	}
+	if c.buffered {
+		if c.buffer, op, err = TransformText(c.buffer, op); err != nil {
+			t.Fatalf("seed %d: transforming against the buffer: %v", seed, err)
+		}
+	}
+	c.text = applyOp(t, seed, op, c.text)
+}
+
+// Clients editing concurrently through an OTDocument, with messages delivered in random order
+// between them, all end up with the server's text.
+func TestOTDocumentClientsConverge(t *testing.T) {
+	for seed := int64(0); seed < otTestSeeds/4; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		initial := randomText(r, 8)
+		doc := NewOTDocument(initial, 0, 1000)
+		clients := make([]*otTestClient, 2+r.Intn(3))
+		for i := range clients {
+			clients[i] = &otTestClient{text: initial}
+		}
+
+		// serve applies a client's next operation and sends the result to every client
+		serve := func(from int) {
+			msg := clients[from].outbox[0]
+			clients[from].outbox = clients[from].outbox[1:]
+			applied, revision, err := doc.Apply(msg.revision, msg.op)
+			if err != nil {
+				t.Fatalf("seed %d: server rejected %v at revision %d: %v", seed, msg.op, msg.revision, err)
+			}
+			for i, c := range clients {
+				c.inbox = append(c.inbox, otTestMessage{op: applied, revision: revision, ack: i == from})
+			}
+		}
+		deliver := func(to int) {
+			c := clients[to]
+			msg := c.inbox[0]
+			c.inbox = c.inbox[1:]
+			c.receive(t, seed, msg)
+		}
+
+		for step := 0; step < 40; step++ {
+			i := r.Intn(len(clients))
+			c := clients[i]
+			switch r.Intn(3) {
+			case 0:
+				c.edit(t, seed, randomOp(r, c.text))
+			case 1:
+				if len(c.outbox) > 0 {
+					serve(i)
+				}
+			default:
+				if len(c.inbox) > 0 {
+					deliver(i)
+				}
+			}
+		}
+		for pending := true; pending; { // Let every message arrive
+			pending = false
+			for i, c := range clients {
+				for len(c.outbox) > 0 {
+					serve(i)
+					pending = true
+				}
+			}
+			for i, c := range clients {
+				for len(c.inbox) > 0 {
+					deliver(i)
+					pending = true
+				}
+			}
+		}
+
+		content, revision := doc.Snapshot()
+		for i, c := range clients {
+			if c.text != content || c.revision != revision {
+				t.Fatalf("seed %d: client %d has %q at revision %d, server has %q at revision %d", seed, i, c.text, c.revision, content, revision)
+			}
+		}
+	}
+}
+
+// Revisions the document no longer has history for, or never had, are rejected.
+func TestOTDocumentRejects(t *testing.T) {
+	doc := NewOTDocument("abc", 0, 2)
+	for i := 0; i < 3; i++ {
+		content, revision := doc.Snapshot()
+		if _, _, err := doc.Apply(revision, DiffText(content, content+"d")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		revision int
+		op       TextOperation
+		want     int
+	}{
+		{"history trimmed", 0, TextOperation{}.Retain(3), http.StatusConflict},
+		{"oldest kept revision", 1, TextOperation{}.Retain(4).Insert("!"), 0},
+		{"future revision", 99, TextOperation{}.Retain(7), http.StatusBadRequest},
+		{"negative revision", -1, TextOperation{}.Retain(7), http.StatusBadRequest},
+		{"wrong length", 4, TextOperation{}.Retain(3), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		_, _, err := doc.Apply(tt.revision, tt.op)
+		var httpErr *HTTPError
+		switch {
+		case tt.want == 0 && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != 0 && (!errors.As(err, &httpErr) || httpErr.StatusCode != tt.want):
+			t.Errorf("%s: got %v, want status %d", tt.name, err, tt.want)
+		}
+	}
+}
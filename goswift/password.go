@@ -0,0 +1,450 @@
+// go-swift/goswift/password.go
+package goswift
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownHashFormat is returned when verifying a hash no supported algorithm recognizes.
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords into self-describing strings: bcrypt's "$2b$..." form or
+// the PHC string format ("$argon2id$v=19$m=...,t=...,p=...$salt$hash").
+type PasswordHasher interface {
+	// Hash hashes password with the hasher's current algorithm and parameters.
+	Hash(password string) (string, error)
+	// Verify checks password against a hash produced by any supported algorithm.
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded uses another algorithm or other parameters than the
+	// hasher would use now, so it should be replaced after the next successful login.
+	NeedsRehash(encoded string) bool
+}
+
+// VerifyPassword checks password against a bcrypt or argon2id hash, whichever encoded is.
+func VerifyPassword(password, encoded string) (bool, error) {
+	switch {
+	case isBcryptHash(encoded):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		params, salt, key, err := parseArgon2id(encoded)
+		if err != nil {
+			return false, err
+		}
+		derived := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(derived, key) == 1, nil
+	}
+	return false, ErrUnknownHashFormat
+}
+
+// isBcryptHash reports whether s is in bcrypt's modular crypt format.
+func isBcryptHash(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+// --- bcrypt ---
+
+// bcryptMaxBytes is the longest password bcrypt accepts.
+const bcryptMaxBytes = 72
+
+// BcryptHasher hashes passwords with bcrypt. Note that bcrypt only accepts passwords
+// of up to 72 bytes; PasswordPolicy.MaxBytes enforces that at signup.
+type BcryptHasher struct {
+	Cost int
+}
+
+// NewBcryptHasher creates a bcrypt hasher; cost 0 uses bcrypt.DefaultCost.
+func NewBcryptHasher(cost int) (*BcryptHasher, error) {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return &BcryptHasher{Cost: cost}, nil
+}
+
+// Hash implements PasswordHasher.
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+// Verify implements PasswordHasher.
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	return VerifyPassword(password, encoded)
+}
+
+// NeedsRehash implements PasswordHasher.
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	if !isBcryptHash(encoded) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// --- argon2id ---
+
+// Argon2idParams are the argon2id cost parameters.
+type Argon2idParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Argon2idHasher hashes passwords with argon2id (RFC 9106) into PHC strings.
+type Argon2idHasher struct {
+	Params Argon2idParams
+}
+
+// NewArgon2idHasher creates an argon2id hasher with 64 MiB of memory, 3 iterations and
+// 2 lanes, within the ranges recommended by RFC 9106 and OWASP.
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{Params: Argon2idParams{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}}
+}
+
+// Hash implements PasswordHasher.
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	p := h.Params
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	b64 := base64.RawStdEncoding.EncodeToString // PHC strings use unpadded standard base64
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism, b64(salt), b64(key)), nil
+}
+
+// Verify implements PasswordHasher.
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	return VerifyPassword(password, encoded)
+}
+
+// NeedsRehash implements PasswordHasher.
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	p := h.Params
+	return params.Memory != p.Memory || params.Iterations != p.Iterations || params.Parallelism != p.Parallelism ||
+		uint32(len(salt)) < p.SaltLength || uint32(len(key)) != p.KeyLength
+}
+
+// parseArgon2id decodes an argon2id PHC string.
+func parseArgon2id(encoded string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version '%s'", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters '%s'", parts[3])
+	}
+	if params.Iterations == 0 || params.Parallelism == 0 || params.Memory < 8*uint32(params.Parallelism) {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters '%s'", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("invalid argon2 hash")
+	}
+	params.SaltLength, params.KeyLength = uint32(len(salt)), uint32(len(key))
+	return params, salt, key, nil
+}
+
+// --- Default hasher ---
+
+var (
+	defaultPasswordHasher   PasswordHasher = &BcryptHasher{Cost: bcrypt.DefaultCost}
+	defaultPasswordHasherMu sync.RWMutex
+	dummyPasswordHash       string // Made lazily with the default hasher, see CheckDummyPassword
+)
+
+// DefaultPasswordHasher returns the hasher used by HashPassword and PasswordNeedsRehash.
+func DefaultPasswordHasher() PasswordHasher {
+	defaultPasswordHasherMu.RLock()
+	defer defaultPasswordHasherMu.RUnlock()
+	return defaultPasswordHasher
+}
+
+// SetDefaultPasswordHasher replaces the hasher used by HashPassword and PasswordNeedsRehash.
+// Existing hashes keep verifying; PasswordNeedsRehash flags them for an upgrade.
+func SetDefaultPasswordHasher(h PasswordHasher) {
+	defaultPasswordHasherMu.Lock()
+	defer defaultPasswordHasherMu.Unlock()
+	defaultPasswordHasher = h
+	dummyPasswordHash = ""
+}
+
+// CheckDummyPassword verifies password against a throwaway hash made with the default hasher.
+// Call it when a login names an unknown user, so the response takes as long as for a real one
+// and timing does not reveal which usernames exist. CheckPasswordHash calls it for unusable hashes.
+func CheckDummyPassword(password string) {
+	defaultPasswordHasherMu.Lock()
+	if dummyPasswordHash == "" {
+		if hash, err := defaultPasswordHasher.Hash("dummy password for unknown users"); err == nil {
+			dummyPasswordHash = hash
+		}
+	}
+	hash := dummyPasswordHash
+	defaultPasswordHasherMu.Unlock()
+	VerifyPassword(password, hash)
+}
+
+// PasswordNeedsRehash reports whether a stored hash should be replaced with HashPassword's
+// output after a successful login.
+func PasswordNeedsRehash(encoded string) bool {
+	return DefaultPasswordHasher().NeedsRehash(encoded)
+}
+
+// PasswordHasherFromConfig builds a hasher from PASSWORD_HASHER ("bcrypt" or "argon2id"),
+// BCRYPT_COST, ARGON2_MEMORY_KIB, ARGON2_ITERATIONS and ARGON2_PARALLELISM.
+func PasswordHasherFromConfig(cm *ConfigManager) (PasswordHasher, error) {
+	switch algorithm := configOr(cm, "PASSWORD_HASHER", "bcrypt"); algorithm {
+	case "bcrypt":
+		cost, err := strconv.Atoi(configOr(cm, "BCRYPT_COST", strconv.Itoa(bcrypt.DefaultCost)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid BCRYPT_COST: %w", err)
+		}
+		return NewBcryptHasher(cost)
+	case "argon2id":
+		h := NewArgon2idHasher()
+		for key, target := range map[string]*uint32{"ARGON2_MEMORY_KIB": &h.Params.Memory, "ARGON2_ITERATIONS": &h.Params.Iterations} {
+			if v := cm.Get(key); v != "" {
+				n, err := strconv.ParseUint(v, 10, 32)
+				if err != nil || n == 0 {
+					return nil, fmt.Errorf("invalid %s '%s'", key, v)
+				}
+				*target = uint32(n)
+			}
+		}
+		if v := cm.Get("ARGON2_PARALLELISM"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 8)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("invalid ARGON2_PARALLELISM '%s'", v)
+			}
+			h.Params.Parallelism = uint8(n)
+		}
+		return h, nil
+	default:
+		return nil, fmt.Errorf("unknown PASSWORD_HASHER '%s'", algorithm)
+	}
+}
+
+// --- Password policy ---
+
+// PasswordPolicyError lists the reasons a password was rejected.
+type PasswordPolicyError struct {
+	Reasons []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return strings.Join(e.Reasons, "; ")
+}
+
+// PasswordPolicy validates new passwords, following NIST SP 800-63B: a minimum length,
+// no composition rules, and rejection of known-breached and context-specific passwords.
+type PasswordPolicy struct {
+	MinLength int // In characters
+	MaxLength int // In characters
+	MaxBytes  int // In UTF-8 bytes, 72 by default for bcrypt; 0 for no limit. Non-ASCII characters take 2 to 4 bytes each
+	// MaxUsernameSimilarity rejects passwords at least this similar to the username
+	// (0..1, by edit distance). Zero disables the check.
+	MaxUsernameSimilarity float64
+
+	breached     map[string]struct{} // Lowercased plaintext passwords
+	breachedSHA1 map[string]struct{} // Uppercase hex SHA-1 hashes, as in the Pwned Passwords downloads
+}
+
+// NewPasswordPolicy creates a policy requiring 8 to 64 characters and at most 72 bytes, and
+// rejecting passwords that are 70% or more similar to the username.
+func NewPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:             8,
+		MaxLength:             64,
+		MaxBytes:              bcryptMaxBytes,
+		MaxUsernameSimilarity: 0.7,
+		breached:              make(map[string]struct{}),
+		breachedSHA1:          make(map[string]struct{}),
+	}
+}
+
+// PasswordPolicyFromConfig creates a policy from PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH and
+// PASSWORD_BREACHED_LIST (path to a breached-password list, see LoadBreachedList). The 72-byte
+// limit only applies when PASSWORD_HASHER is bcrypt.
+func PasswordPolicyFromConfig(cm *ConfigManager) (*PasswordPolicy, error) {
+	p := NewPasswordPolicy()
+	if configOr(cm, "PASSWORD_HASHER", "bcrypt") != "bcrypt" {
+		p.MaxBytes = 0
+	}
+	for key, target := range map[string]*int{"PASSWORD_MIN_LENGTH": &p.MinLength, "PASSWORD_MAX_LENGTH": &p.MaxLength} {
+		if v := cm.Get(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid %s '%s'", key, v)
+			}
+			*target = n
+		}
+	}
+	if path := cm.Get("PASSWORD_BREACHED_LIST"); path != "" {
+		if err := p.LoadBreachedList(path); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// LoadBreachedList adds passwords from a local file, one per line. Lines may be plaintext
+// passwords or SHA-1 hashes in the Pwned Passwords format ("HASH" or "HASH:count").
+// Empty lines and lines starting with '#' are ignored.
+func (p *PasswordPolicy) LoadBreachedList(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); len(hash) == 40 && isHex(hash) {
+			p.breachedSHA1[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+		p.breached[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read breached password list: %w", err)
+	}
+	return nil
+}
+
+// isHex reports whether s consists of hexadecimal digits only.
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// Validate checks a new password for the given username. It returns a *PasswordPolicyError
+// describing every violated rule, or nil.
+func (p *PasswordPolicy) Validate(password, username string) error {
+	var reasons []string
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		reasons = append(reasons, fmt.Sprintf("password must be at least %d characters", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		reasons = append(reasons, fmt.Sprintf("password must be at most %d characters", p.MaxLength))
+	} else if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		reasons = append(reasons, fmt.Sprintf("password is too long: at most %d bytes, and non-ASCII characters count as several", p.MaxBytes))
+	}
+	if p.isBreached(password) {
+		reasons = append(reasons, "password appears in a list of breached passwords")
+	}
+	if p.MaxUsernameSimilarity > 0 && username != "" && tooSimilar(password, username, p.MaxUsernameSimilarity) {
+		reasons = append(reasons, "password is too similar to the username")
+	}
+	if len(reasons) > 0 {
+		return &PasswordPolicyError{Reasons: reasons}
+	}
+	return nil
+}
+
+// isBreached reports whether password is on the loaded breached lists.
+func (p *PasswordPolicy) isBreached(password string) bool {
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		return true
+	}
+	if len(p.breachedSHA1) == 0 {
+		return false
+	}
+	sum := sha1.Sum([]byte(password))
+	_, ok := p.breachedSHA1[strings.ToUpper(hex.EncodeToString(sum[:]))]
+	return ok
+}
+
+// tooSimilar reports whether password contains the username (or vice versa), possibly
+// reversed, or is within the similarity threshold by edit distance. Comparison ignores case.
+func tooSimilar(password, username string, threshold float64) bool {
+	pw, user := []rune(strings.ToLower(password)), []rune(strings.ToLower(username))
+	reversed := make([]rune, len(user))
+	for i, r := range user {
+		reversed[len(user)-1-i] = r
+	}
+	if len(user) >= 3 && (strings.Contains(string(pw), string(user)) || strings.Contains(string(pw), string(reversed))) {
+		return true
+	}
+	if len(pw) >= 3 && strings.Contains(string(user), string(pw)) {
+		return true
+	}
+
+	longest := len(pw)
+	if len(user) > longest {
+		longest = len(user)
+	}
+	if longest == 0 {
+		return false
+	}
+	similarity := 1 - float64(levenshtein(pw, user))/float64(longest)
+	return similarity >= threshold
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
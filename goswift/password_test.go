@@ -0,0 +1,78 @@
+// go-swift/goswift/password_test.go
+package goswift
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A password within the character limit but over bcrypt's 72 bytes is a policy violation, not
+// a hashing failure.
+func TestPasswordPolicyByteLimit(t *testing.T) {
+	bcryptPolicy, err := PasswordPolicyFromConfig(NewConfigManager())
+	if err != nil {
+		t.Fatal(err)
+	}
+	argonConfig := NewConfigManager()
+	argonConfig.Set("PASSWORD_HASHER", "argon2id")
+	argonPolicy, err := PasswordPolicyFromConfig(argonConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher, err := NewBcryptHasher(bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		password   string
+		bcryptOK   bool
+		argon2idOK bool
+	}{
+		{"64 ASCII characters", strings.Repeat("k", 64), true, true},
+		{"36 two-byte characters", strings.Repeat("é", 36), true, true},
+		{"37 two-byte characters", strings.Repeat("é", 37), false, true},
+		{"64 three-byte characters", strings.Repeat("漢", 64), false, true},
+		{"65 characters", strings.Repeat("k", 65), false, false},
+	}
+	for _, tt := range tests {
+		err := bcryptPolicy.Validate(tt.password, "alice")
+		var policyErr *PasswordPolicyError
+		if tt.bcryptOK != (err == nil) || (err != nil && !errors.As(err, &policyErr)) {
+			t.Errorf("%s: bcrypt policy returned %v", tt.name, err)
+		}
+		if err == nil {
+			if _, err := hasher.Hash(tt.password); err != nil {
+				t.Errorf("%s: passed the policy but bcrypt failed: %v", tt.name, err)
+			}
+		}
+		if err := argonPolicy.Validate(tt.password, "alice"); tt.argon2idOK != (err == nil) {
+			t.Errorf("%s: argon2id policy returned %v", tt.name, err)
+		}
+	}
+}
+
+// Empty and unknown hashes never match, and are checked against the dummy hash instead of
+// failing instantly.
+func TestCheckPasswordHashUnusable(t *testing.T) {
+	SetDefaultPasswordHasher(&BcryptHasher{Cost: bcrypt.MinCost})
+	defer SetDefaultPasswordHasher(&BcryptHasher{Cost: bcrypt.DefaultCost})
+	for _, hash := range []string{"", "not a hash", "$unknown$abc"} {
+		defaultPasswordHasherMu.Lock()
+		dummyPasswordHash = ""
+		defaultPasswordHasherMu.Unlock()
+		if CheckPasswordHash("", hash) || CheckPasswordHash("password", hash) {
+			t.Errorf("%q matched", hash)
+		}
+		defaultPasswordHasherMu.Lock()
+		checked := dummyPasswordHash != ""
+		defaultPasswordHasherMu.Unlock()
+		if !checked {
+			t.Errorf("%q was not checked against the dummy hash", hash)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+// go-swift/goswift/presence.go
+package goswift
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// presenceBrokerTopic is the broker topic all Presence instances exchange updates on.
+const presenceBrokerTopic = "presence"
+
+// PresenceEntry is a user present on a topic.
+type PresenceEntry struct {
+	UserID      string    `json:"user_id"`
+	Name        string    `json:"name,omitempty"`
+	Connections int       `json:"connections"` // E.g. open tabs, across all instances
+	Since       time.Time `json:"since"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// PresenceChange describes a user joining or leaving a topic, with the resulting list.
+type PresenceChange struct {
+	Type    string          `json:"type"` // "join" or "leave"
+	User    PresenceEntry   `json:"user"`
+	Viewers []PresenceEntry `json:"viewers"`
+}
+
+// presenceConn is one connection of a user to a topic.
+type presenceConn struct {
+	topic, userID, name string
+	joinedAt, lastSeen  time.Time
+}
+
+// presenceWireConn is a connection in a presenceMessage.
+type presenceWireConn struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	Name   string `json:"name,omitempty"`
+}
+
+// presenceMessage is what Presence instances publish on the broker.
+type presenceMessage struct {
+	Op    string             `json:"op"` // "join", "leave" or "heartbeat"
+	Topic string             `json:"topic"`
+	Conns []presenceWireConn `json:"conns"`
+}
+
+// PresenceConfig controls a Presence. Zero values fall back to the defaults noted on each field.
+type PresenceConfig struct {
+	TTL               time.Duration // Connections not heard of for this long expire, 60s by default
+	HeartbeatInterval time.Duration // How often an instance re-announces its connections, 20s by default; keep well below TTL
+
+	// OnChange, if set, is called when a user's first connection to a topic joins or their last
+	// one leaves or expires. Every instance sharing the broker calls it for every change.
+	OnChange func(topic string, change PresenceChange)
+}
+
+// Presence tracks which users are connected to which topics (e.g. who is viewing a document).
+// Connections are registered with Join and announced on the broker, so every instance sharing
+// it knows all connections. Each instance re-announces its connections every HeartbeatInterval;
+// connections not heard of for TTL, e.g. because their instance crashed, expire. Presence only
+// counts connections; whether a user may join a topic is up to the caller.
+type Presence struct {
+	config   PresenceConfig
+	broker   Broker
+	logger   *Logger
+	instance string        // Prefix of connection IDs, unique per process
+	nextID   atomic.Uint64 // Source of connection IDs
+
+	mu          sync.Mutex
+	topics      map[string]map[string]*presenceConn // map[topic]map[connID]*presenceConn, all instances
+	local       map[string]presenceWireConn         // map[connID], connections of this instance
+	localTopics map[string]string                   // map[connID]topic of local connections
+	left        map[string]time.Time                // map[connID]when it left, so a heartbeat sent before the leave cannot revive it
+
+	sub  BrokerSubscription
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewPresence creates a Presence that shares state through broker and starts its heartbeats.
+func NewPresence(broker Broker, config PresenceConfig, logger *Logger) (*Presence, error) {
+	if config.TTL <= 0 {
+		config.TTL = time.Minute
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = 20 * time.Second
+	}
+	var nonce [4]byte
+	rand.Read(nonce[:])
+	p := &Presence{
+		config:      config,
+		broker:      broker,
+		logger:      logger,
+		instance:    strconv.FormatInt(time.Now().UnixNano(), 36) + hex.EncodeToString(nonce[:]),
+		topics:      make(map[string]map[string]*presenceConn),
+		local:       make(map[string]presenceWireConn),
+		localTopics: make(map[string]string),
+		left:        make(map[string]time.Time),
+		stop:        make(chan struct{}),
+	}
+	sub, err := broker.Subscribe(presenceBrokerTopic, p.receive)
+	if err != nil {
+		return nil, err
+	}
+	p.sub = sub
+	go p.run()
+	return p, nil
+}
+
+// Join registers a connection of a user to a topic. Call the returned function when the
+// connection ends; it is safe to call more than once.
+func (p *Presence) Join(topic, userID, name string) (leave func()) {
+	conn := presenceWireConn{
+		ID:     p.instance + "-" + strconv.FormatUint(p.nextID.Add(1), 10),
+		UserID: userID,
+		Name:   name,
+	}
+	p.mu.Lock()
+	p.local[conn.ID] = conn
+	p.localTopics[conn.ID] = topic
+	p.mu.Unlock()
+	p.publish(presenceMessage{Op: "join", Topic: topic, Conns: []presenceWireConn{conn}})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			delete(p.local, conn.ID)
+			delete(p.localTopics, conn.ID)
+			p.mu.Unlock()
+			p.publish(presenceMessage{Op: "leave", Topic: topic, Conns: []presenceWireConn{conn}})
+		})
+	}
+}
+
+// List returns the users present on a topic, longest present first.
+func (p *Presence) List(topic string) []PresenceEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.listLocked(topic)
+}
+
+// listLocked aggregates a topic's connections by user.
+func (p *Presence) listLocked(topic string) []PresenceEntry {
+	byUser := make(map[string]*PresenceEntry)
+	for _, conn := range p.topics[topic] {
+		entry, ok := byUser[conn.userID]
+		if !ok {
+			entry = &PresenceEntry{UserID: conn.userID, Name: conn.name, Since: conn.joinedAt, LastSeen: conn.lastSeen}
+			byUser[conn.userID] = entry
+		}
+		entry.Connections++
+		if conn.joinedAt.Before(entry.Since) {
+			entry.Since = conn.joinedAt
+		}
+		if conn.lastSeen.After(entry.LastSeen) {
+			entry.LastSeen = conn.lastSeen
+		}
+	}
+	entries := make([]PresenceEntry, 0, len(byUser))
+	for _, entry := range byUser {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Since.Equal(entries[j].Since) {
+			return entries[i].Since.Before(entries[j].Since)
+		}
+		return entries[i].UserID < entries[j].UserID
+	})
+	return entries
+}
+
+// userConnsLocked counts a user's connections to a topic.
+func (p *Presence) userConnsLocked(topic, userID string) int {
+	n := 0
+	for _, conn := range p.topics[topic] {
+		if conn.userID == userID {
+			n++
+		}
+	}
+	return n
+}
+
+// Close stops the heartbeats and unsubscribes from the broker. Other instances expire this
+// instance's connections after TTL.
+func (p *Presence) Close() error {
+	p.once.Do(func() { close(p.stop) })
+	return p.broker.Unsubscribe(p.sub)
+}
+
+// run sends heartbeats for local connections and expires stale ones.
+func (p *Presence) run() {
+	ticker := time.NewTicker(p.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.heartbeat()
+			p.expire(time.Now())
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// heartbeat re-announces this instance's connections, one message per topic.
+func (p *Presence) heartbeat() {
+	p.mu.Lock()
+	byTopic := make(map[string][]presenceWireConn)
+	for id, conn := range p.local {
+		byTopic[p.localTopics[id]] = append(byTopic[p.localTopics[id]], conn)
+	}
+	p.mu.Unlock()
+	for topic, conns := range byTopic {
+		p.publish(presenceMessage{Op: "heartbeat", Topic: topic, Conns: conns})
+	}
+}
+
+// expire drops connections not heard of for TTL, and forgets connections that left TTL ago,
+// by when any heartbeat sent before their leave has been delivered.
+func (p *Presence) expire(now time.Time) {
+	var changes []func()
+	p.mu.Lock()
+	for id, leftAt := range p.left {
+		if now.Sub(leftAt) > p.config.TTL {
+			delete(p.left, id)
+		}
+	}
+	for topic, conns := range p.topics {
+		for id, conn := range conns {
+			if now.Sub(conn.lastSeen) > p.config.TTL {
+				p.logger.Info("Presence: Connection %s of user %s on topic %s expired", id, conn.userID, topic)
+				if change := p.removeLocked(topic, id); change != nil {
+					changes = append(changes, change)
+				}
+			}
+		}
+	}
+	p.mu.Unlock()
+	for _, change := range changes {
+		change()
+	}
+}
+
+// publish sends a message to all Presence instances, including this one.
+func (p *Presence) publish(msg presenceMessage) {
+	payload, err := json.Marshal(msg)
+	if err == nil {
+		err = p.broker.Publish(presenceBrokerTopic, payload)
+	}
+	if err != nil {
+		p.logger.Error("Presence: Failed to publish %s for topic %s: %v", msg.Op, msg.Topic, err)
+	}
+}
+
+// receive applies a message from the broker.
+func (p *Presence) receive(_ string, payload []byte) {
+	var msg presenceMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		p.logger.Error("Presence: Ignoring malformed broker message: %v", err)
+		return
+	}
+
+	var changes []func()
+	now := time.Now()
+	p.mu.Lock()
+	for _, wire := range msg.Conns {
+		var change func()
+		switch msg.Op {
+		case "join", "heartbeat":
+			change = p.addLocked(msg.Topic, wire, now)
+		case "leave":
+			p.left[wire.ID] = now
+			change = p.removeLocked(msg.Topic, wire.ID)
+		}
+		if change != nil {
+			changes = append(changes, change)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, change := range changes { // Outside the lock, so OnChange may call List
+		change()
+	}
+}
+
+// addLocked adds or refreshes a connection. It returns the OnChange call to make if this is the
+// user's first connection to the topic.
+func (p *Presence) addLocked(topic string, wire presenceWireConn, now time.Time) func() {
+	if _, ok := p.left[wire.ID]; ok { // A heartbeat snapshotted before the leave
+		return nil
+	}
+	if conn, ok := p.topics[topic][wire.ID]; ok {
+		conn.lastSeen = now
+		return nil
+	}
+	first := p.userConnsLocked(topic, wire.UserID) == 0
+	if p.topics[topic] == nil {
+		p.topics[topic] = make(map[string]*presenceConn)
+	}
+	p.topics[topic][wire.ID] = &presenceConn{topic: topic, userID: wire.UserID, name: wire.Name, joinedAt: now, lastSeen: now}
+	if !first {
+		return nil
+	}
+	return p.changeLocked(topic, "join", wire.UserID, wire.Name)
+}
+
+// removeLocked removes a connection. It returns the OnChange call to make if this was the
+// user's last connection to the topic.
+func (p *Presence) removeLocked(topic, connID string) func() {
+	conn, ok := p.topics[topic][connID]
+	if !ok {
+		return nil
+	}
+	delete(p.topics[topic], connID)
+	if len(p.topics[topic]) == 0 {
+		delete(p.topics, topic)
+	}
+	if p.userConnsLocked(topic, conn.userID) > 0 {
+		return nil
+	}
+	return p.changeLocked(topic, "leave", conn.userID, conn.name)
+}
+
+// changeLocked snapshots a change for OnChange.
+func (p *Presence) changeLocked(topic, kind, userID, name string) func() {
+	if p.config.OnChange == nil {
+		return nil
+	}
+	change := PresenceChange{Type: kind, User: PresenceEntry{UserID: userID, Name: name}, Viewers: p.listLocked(topic)}
+	for _, entry := range change.Viewers {
+		if entry.UserID == userID {
+			change.User = entry
+		}
+	}
+	return func() { p.config.OnChange(topic, change) }
+}
@@ -0,0 +1,158 @@
+// go-swift/goswift/presence_test.go
+package goswift
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// presenceRecorder collects the changes a Presence reports.
+type presenceRecorder struct {
+	mu      sync.Mutex
+	changes []string
+}
+
+func (r *presenceRecorder) onChange(topic string, change PresenceChange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changes = append(r.changes, change.Type+" "+change.User.UserID)
+}
+
+func (r *presenceRecorder) list() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.changes...)
+}
+
+// testPresence returns a Presence on broker that records its changes and is closed after the test.
+func testPresence(t *testing.T, broker Broker, config PresenceConfig) (*Presence, *presenceRecorder) {
+	t.Helper()
+	recorder := &presenceRecorder{}
+	config.OnChange = recorder.onChange
+	p, err := NewPresence(broker, config, NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p, recorder
+}
+
+// presenceViewers returns a topic's viewers as "userID:connections".
+func presenceViewers(p *Presence, topic string) []string {
+	var viewers []string
+	for _, entry := range p.List(topic) {
+		viewers = append(viewers, entry.UserID+":"+strconv.Itoa(entry.Connections))
+	}
+	return viewers
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Only a user's first connection joins and their last one leaves.
+func TestPresenceJoinLeave(t *testing.T) {
+	p, recorder := testPresence(t, NewMemoryBroker(), PresenceConfig{HeartbeatInterval: time.Hour})
+
+	leaveA1 := p.Join("doc", "alice", "Alice")
+	leaveA2 := p.Join("doc", "alice", "Alice")
+	leaveB := p.Join("doc", "bob", "Bob")
+	if got, want := presenceViewers(p, "doc"), []string{"alice:2", "bob:1"}; !equalStrings(got, want) {
+		t.Fatalf("viewers = %v, want %v", got, want)
+	}
+
+	leaveA1()
+	leaveA1() // Safe to call twice
+	if got, want := presenceViewers(p, "doc"), []string{"alice:1", "bob:1"}; !equalStrings(got, want) {
+		t.Fatalf("after one tab closed, viewers = %v, want %v", got, want)
+	}
+	leaveA2()
+	leaveB()
+	if got := p.List("doc"); len(got) != 0 {
+		t.Fatalf("after everyone left, viewers = %v", got)
+	}
+	if got, want := recorder.list(), []string{"join alice", "join bob", "leave alice", "leave bob"}; !equalStrings(got, want) {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+}
+
+// A heartbeat snapshotted before a leave but delivered after it does not bring the connection
+// back, on this instance or any other.
+func TestPresenceHeartbeatDoesNotReviveLeft(t *testing.T) {
+	broker := NewMemoryBroker()
+	config := PresenceConfig{TTL: time.Minute, HeartbeatInterval: time.Hour}
+	p, recorder := testPresence(t, broker, config)
+	other, otherRecorder := testPresence(t, broker, config)
+
+	leave := p.Join("doc", "alice", "Alice")
+	p.mu.Lock()
+	var stale []presenceWireConn
+	for _, conn := range p.local {
+		stale = append(stale, conn)
+	}
+	p.mu.Unlock()
+	leave()
+	p.publish(presenceMessage{Op: "heartbeat", Topic: "doc", Conns: stale})
+
+	want := []string{"join alice", "leave alice"}
+	for name, instance := range map[string]*Presence{"publisher": p, "other": other} {
+		if got := instance.List("doc"); len(got) != 0 {
+			t.Errorf("%s: ghost viewers %v", name, got)
+		}
+	}
+	if got := recorder.list(); !equalStrings(got, want) {
+		t.Errorf("publisher: changes = %v, want %v", got, want)
+	}
+	if got := otherRecorder.list(); !equalStrings(got, want) {
+		t.Errorf("other: changes = %v, want %v", got, want)
+	}
+
+	// The tombstone is forgotten after TTL
+	p.expire(time.Now().Add(2 * config.TTL))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.left) != 0 {
+		t.Errorf("tombstones kept after TTL: %v", p.left)
+	}
+}
+
+// Connections of an instance that stops heartbeating expire after TTL; live ones do not.
+func TestPresenceExpiry(t *testing.T) {
+	broker := NewMemoryBroker()
+	config := PresenceConfig{TTL: 150 * time.Millisecond, HeartbeatInterval: 20 * time.Millisecond}
+	p, recorder := testPresence(t, broker, config)
+	crashed, _ := testPresence(t, broker, config)
+
+	p.Join("doc", "alice", "Alice")
+	crashed.Join("doc", "bob", "Bob")
+	if got, want := presenceViewers(p, "doc"), []string{"alice:1", "bob:1"}; !equalStrings(got, want) {
+		t.Fatalf("viewers = %v, want %v", got, want)
+	}
+
+	crashed.Close() // Without leaving
+	deadline := time.Now().Add(2 * time.Second)
+	for len(p.List("doc")) > 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, want := presenceViewers(p, "doc"), []string{"alice:1"}; !equalStrings(got, want) {
+		t.Fatalf("after the instance stopped, viewers = %v, want %v", got, want)
+	}
+
+	time.Sleep(2 * config.TTL) // Alice's own heartbeats keep her present
+	if got, want := presenceViewers(p, "doc"), []string{"alice:1"}; !equalStrings(got, want) {
+		t.Fatalf("viewers = %v, want %v", got, want)
+	}
+	if got, want := recorder.list(), []string{"join alice", "join bob", "leave bob"}; !equalStrings(got, want) {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+}
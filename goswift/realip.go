@@ -0,0 +1,271 @@
+// go-swift/goswift/realip.go
+package goswift
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DefaultForwardedHeader is the header trusted proxies are assumed to append the client address
+// to, unless SetForwardedHeader says otherwise.
+const DefaultForwardedHeader = "X-Forwarded-For"
+
+// SetTrustedProxies configures the CIDR ranges (or bare IPs) of reverse proxies
+// whose forwarding headers (see SetForwardedHeader) may be trusted. It should be called before
+// the server starts handling requests.
+// Passing no arguments disables header trust entirely (the default).
+func (e *Engine) SetTrustedProxies(cidrs ...string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return fmt.Errorf("invalid trusted proxy: %w", err)
+	}
+	e.trustedProxies = nets
+	return nil
+}
+
+// SetForwardedHeader sets the header the trusted proxies append the client address to:
+// "X-Forwarded-For" (the default, also the source of X-Forwarded-Proto/-Host), "Forwarded"
+// (RFC 7239, also the source of proto= and host=), or a header holding a comma-separated
+// address list such as "X-Real-IP". Only that header is read; proxies usually pass the other
+// one through from the client untouched. It should be called before the server starts
+// handling requests; FORWARDED_HEADER sets it from configuration.
+func (e *Engine) SetForwardedHeader(name string) error {
+	name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+	if name == "" {
+		return fmt.Errorf("forwarded header name is empty")
+	}
+	e.forwardedHeader = name
+	return nil
+}
+
+// forwardedHeaderName returns the header set with SetForwardedHeader.
+func (e *Engine) forwardedHeaderName() string {
+	if e.forwardedHeader == "" {
+		return DefaultForwardedHeader
+	}
+	return e.forwardedHeader
+}
+
+// isTrustedProxy reports whether the given IP belongs to a configured trusted proxy range.
+func (e *Engine) isTrustedProxy(ip net.IP) bool {
+	return ipInNets(ip, e.trustedProxies)
+}
+
+// RealIP returns the client IP address for the request.
+// Forwarding headers are only honoured when the direct peer is a trusted proxy;
+// the chain in the configured header (see SetForwardedHeader) is then walked from the right,
+// skipping trusted hops, and the first untrusted address is returned. A request carrying both
+// Forwarded and X-Forwarded-For is ambiguous, since one of them came from the client, so its
+// forwarding headers are ignored and the peer address is returned.
+func (c *Context) RealIP() string {
+	peer := remoteIP(c.Request.RemoteAddr)
+	if peer == nil {
+		return stripPort(c.Request.RemoteAddr)
+	}
+	if !c.fromTrustedProxy() {
+		return peer.String()
+	}
+
+	chain := forwardedFor(c.Request.Header, c.engine.forwardedHeaderName())
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(chain[i])
+		if ip == nil {
+			// Malformed hop: stop here rather than trusting anything further left
+			break
+		}
+		if !c.engine.isTrustedProxy(ip) {
+			return ip.String()
+		}
+		if i == 0 {
+			return ip.String() // Every hop is trusted, the leftmost one is the client
+		}
+	}
+	return peer.String()
+}
+
+// Scheme returns the request scheme ("http" or "https").
+// X-Forwarded-Proto, or Forwarded proto= when Forwarded is the configured header, is only
+// honoured from trusted proxies.
+func (c *Context) Scheme() string {
+	if c.fromTrustedProxy() {
+		if proto := c.forwardedValue("proto", "X-Forwarded-Proto"); proto != "" {
+			return strings.ToLower(proto)
+		}
+	}
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the host requested by the client.
+// X-Forwarded-Host, or Forwarded host= when Forwarded is the configured header, is only
+// honoured from trusted proxies.
+func (c *Context) Host() string {
+	if c.fromTrustedProxy() {
+		if host := c.forwardedValue("host", "X-Forwarded-Host"); host != "" {
+			return host
+		}
+	}
+	return c.Request.Host
+}
+
+// forwardedValue returns a Forwarded parameter if Forwarded is the configured header, and the
+// given X-Forwarded-* header otherwise.
+func (c *Context) forwardedValue(param, xHeader string) string {
+	if c.engine.forwardedHeaderName() == "Forwarded" {
+		return forwardedParam(c.Request.Header, param)
+	}
+	return firstHeaderValue(c.Request.Header.Get(xHeader))
+}
+
+// fromTrustedProxy reports whether the direct peer of the request is a trusted proxy and the
+// forwarding headers are unambiguous.
+func (c *Context) fromTrustedProxy() bool {
+	if c.engine == nil {
+		return false
+	}
+	peer := remoteIP(c.Request.RemoteAddr)
+	if peer == nil || !c.engine.isTrustedProxy(peer) {
+		return false
+	}
+	h := c.Request.Header
+	return len(h.Values("Forwarded")) == 0 || len(h.Values("X-Forwarded-For")) == 0 // One of both is the client's
+}
+
+// forwardedFor returns the client chain from the given header: Forwarded (RFC 7239) or a
+// comma-separated list such as X-Forwarded-For. Entries are ordered left (client) to right
+// (nearest proxy).
+func forwardedFor(h http.Header, header string) []string {
+	var chain []string
+	if header == "Forwarded" {
+		for _, element := range splitForwarded(h.Values("Forwarded")) {
+			if node, ok := element["for"]; ok {
+				chain = append(chain, forwardedNodeIP(node))
+			}
+		}
+		return chain
+	}
+	for _, value := range h.Values(header) {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				chain = append(chain, stripPort(part))
+			}
+		}
+	}
+	return chain
+}
+
+// forwardedParam returns a parameter (e.g. "proto", "host") from the last Forwarded element.
+// The last element is the one added by the proxy closest to us.
+func forwardedParam(h http.Header, key string) string {
+	elements := splitForwarded(h.Values("Forwarded"))
+	if len(elements) == 0 {
+		return ""
+	}
+	return elements[len(elements)-1][key]
+}
+
+// splitForwarded parses Forwarded header values into a list of key/value elements.
+func splitForwarded(values []string) []map[string]string {
+	var elements []map[string]string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			pairs := make(map[string]string)
+			for _, pair := range strings.Split(element, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				pairs[strings.ToLower(strings.TrimSpace(k))] = strings.Trim(strings.TrimSpace(v), `"`)
+			}
+			if len(pairs) > 0 {
+				elements = append(elements, pairs)
+			}
+		}
+	}
+	return elements
+}
+
+// forwardedNodeIP extracts the IP from a Forwarded node such as "[2001:db8::1]:4711" or "192.0.2.60".
+func forwardedNodeIP(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.Index(node, "]"); end != -1 {
+			return node[1:end]
+		}
+	}
+	return stripPort(node)
+}
+
+// firstHeaderValue returns the first comma-separated entry of a header value.
+func firstHeaderValue(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
+
+// remoteIP parses the IP part of a "host:port" remote address.
+func remoteIP(remoteAddr string) net.IP {
+	return net.ParseIP(stripPort(remoteAddr))
+}
+
+// stripPort removes an optional port (and IPv6 brackets) from an address.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+// parseCIDRs parses a list of CIDR ranges or bare IPs into IP networks.
+// Bare IPs are treated as single-host networks (/32 or /128).
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("'%s' is not a valid IP or CIDR", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a valid IP or CIDR: %w", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ipInNets reports whether ip is contained in any of the given networks.
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated configuration value into trimmed, non-empty entries.
+func splitList(value string) []string {
+	var out []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,95 @@
+// go-swift/goswift/realip_test.go
+package goswift
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// forwardedTestContext returns a context for a request from peer with the given headers, on an
+// engine trusting 10.0.0.0/8 and reading header (the default if empty).
+func forwardedTestContext(t *testing.T, header, peer string, headers map[string][]string) *Context {
+	t.Helper()
+	app := New()
+	if err := app.SetTrustedProxies("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if header != "" {
+		if err := app.SetForwardedHeader(header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+	req.RemoteAddr = peer + ":4711"
+	for name, values := range headers {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	return &Context{Request: req, engine: app}
+}
+
+func TestRealIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		peer    string
+		headers map[string][]string
+		want    string
+	}{
+		{"no proxy", "", "203.0.113.9", nil, "203.0.113.9"},
+		{"untrusted peer", "", "203.0.113.9", map[string][]string{"X-Forwarded-For": {"1.2.3.4"}}, "203.0.113.9"},
+		{"trusted peer", "", "10.0.0.2", map[string][]string{"X-Forwarded-For": {"1.2.3.4"}}, "1.2.3.4"},
+		{"trusted peer without header", "", "10.0.0.2", nil, "10.0.0.2"},
+		{"trusted hops skipped", "", "10.0.0.2", map[string][]string{"X-Forwarded-For": {"1.2.3.4, 10.0.0.7"}}, "1.2.3.4"},
+		{"client-supplied entries ignored", "", "10.0.0.2", map[string][]string{"X-Forwarded-For": {"127.0.0.1, 5.6.7.8"}}, "5.6.7.8"},
+		{"repeated headers", "", "10.0.0.2", map[string][]string{"X-Forwarded-For": {"127.0.0.1", "5.6.7.8"}}, "5.6.7.8"},
+		{"every hop trusted", "", "10.0.0.2", map[string][]string{"X-Forwarded-For": {"10.0.0.9, 10.0.0.7"}}, "10.0.0.9"},
+		{"malformed hop", "", "10.0.0.2", map[string][]string{"X-Forwarded-For": {"127.0.0.1, garbage"}}, "10.0.0.2"},
+		{"IPv6 hop", "", "10.0.0.2", map[string][]string{"X-Forwarded-For": {"2001:db8::1"}}, "2001:db8::1"},
+
+		// The client's own Forwarded header is passed through while the proxy appends X-Forwarded-For
+		{"client Forwarded ignored", "", "10.0.0.2", map[string][]string{"Forwarded": {"for=127.0.0.1"}}, "10.0.0.2"},
+		{"Forwarded and X-Forwarded-For", "", "10.0.0.2", map[string][]string{"Forwarded": {"for=127.0.0.1"}, "X-Forwarded-For": {"5.6.7.8"}}, "10.0.0.2"},
+		{"both, Forwarded configured", "Forwarded", "10.0.0.2", map[string][]string{"Forwarded": {"for=5.6.7.8"}, "X-Forwarded-For": {"127.0.0.1"}}, "10.0.0.2"},
+
+		{"Forwarded", "Forwarded", "10.0.0.2", map[string][]string{"Forwarded": {`for=127.0.0.1, for="[2001:db8::1]:4711";proto=https`}}, "2001:db8::1"},
+		{"Forwarded ignores X-Forwarded-For", "forwarded", "10.0.0.2", map[string][]string{"X-Forwarded-For": {"5.6.7.8"}}, "10.0.0.2"},
+		{"Forwarded from untrusted peer", "Forwarded", "203.0.113.9", map[string][]string{"Forwarded": {"for=5.6.7.8"}}, "203.0.113.9"},
+		{"custom header", "X-Real-IP", "10.0.0.2", map[string][]string{"X-Real-Ip": {"5.6.7.8"}, "X-Forwarded-For": {"127.0.0.1"}}, "5.6.7.8"},
+	}
+	for _, tt := range tests {
+		c := forwardedTestContext(t, tt.header, tt.peer, tt.headers)
+		if got := c.RealIP(); got != tt.want {
+			t.Errorf("%s: RealIP() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSchemeAndHost(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		peer       string
+		headers    map[string][]string
+		wantScheme string
+		wantHost   string
+	}{
+		{"direct", "", "203.0.113.9", nil, "http", "app.example.com"},
+		{"untrusted peer", "", "203.0.113.9", map[string][]string{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"evil.example"}}, "http", "app.example.com"},
+		{"trusted peer", "", "10.0.0.2", map[string][]string{"X-Forwarded-Proto": {"HTTPS"}, "X-Forwarded-Host": {"docs.example.com"}}, "https", "docs.example.com"},
+		{"client Forwarded ignored", "", "10.0.0.2", map[string][]string{"Forwarded": {"proto=https;host=evil.example"}}, "http", "app.example.com"},
+		{"mixed headers ignored", "", "10.0.0.2", map[string][]string{"Forwarded": {"host=evil.example"}, "X-Forwarded-For": {"5.6.7.8"}, "X-Forwarded-Host": {"docs.example.com"}}, "http", "app.example.com"},
+		{"Forwarded configured", "Forwarded", "10.0.0.2", map[string][]string{"Forwarded": {"for=5.6.7.8;proto=https;host=docs.example.com"}, "X-Forwarded-Host": {"evil.example"}}, "https", "docs.example.com"},
+	}
+	for _, tt := range tests {
+		c := forwardedTestContext(t, tt.header, tt.peer, tt.headers)
+		if got := c.Scheme(); got != tt.wantScheme {
+			t.Errorf("%s: Scheme() = %s, want %s", tt.name, got, tt.wantScheme)
+		}
+		if got := c.Host(); got != tt.wantHost {
+			t.Errorf("%s: Host() = %s, want %s", tt.name, got, tt.wantHost)
+		}
+	}
+}
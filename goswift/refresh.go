@@ -0,0 +1,330 @@
+// go-swift/goswift/refresh.go
+package goswift
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrRefreshTokenInvalid is returned for unknown, expired or revoked refresh tokens.
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again.
+	// The whole token family is revoked, since either the client or an attacker holds a stolen copy.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// RefreshToken is the stored state of an opaque refresh token. Only a hash of the token is kept.
+type RefreshToken struct {
+	Hash      string
+	FamilyID  string // Shared by every token rotated from the same login
+	UserID    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Used      bool // Set once the token has been exchanged
+	Revoked   bool
+	// Claims are the custom claims of the access tokens in this family, re-issued on refresh.
+	Claims json.RawMessage
+}
+
+// RefreshTokenStore persists refresh tokens.
+type RefreshTokenStore interface {
+	// Save stores a new refresh token. It returns ErrRefreshTokenInvalid if the token's family
+	// was revoked, so a refresh racing with RevokeFamily cannot add a token to the family.
+	Save(token *RefreshToken) error
+	// Get returns the token with the given hash, or nil if it is unknown. Tokens of a revoked
+	// family are returned with Revoked set.
+	Get(hash string) (*RefreshToken, error)
+	// MarkUsed atomically flags the token as used and reports whether it already was. It returns
+	// ErrRefreshTokenInvalid, leaving the token alone, if the token is unknown, expired or revoked
+	// by now (including through its family).
+	MarkUsed(hash string) (alreadyUsed bool, err error)
+	// RevokeFamily revokes every token in a family and refuses tokens saved to it later.
+	RevokeFamily(familyID string) error
+	// RevokeUser revokes every token belonging to a user, along with their families.
+	RevokeUser(userID string) error
+}
+
+// TokenDenylist records revoked access token IDs ("jti") until they would have expired anyway.
+type TokenDenylist interface {
+	Add(tokenID string, expiresAt time.Time) error
+	Contains(tokenID string) (bool, error)
+}
+
+// TokenPair is an access token together with the refresh token used to renew it.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // Access token lifetime in seconds
+}
+
+// IssueTokens starts a new token family for the user (i.e. a login) and returns the first token pair.
+func (s *JWTService) IssueTokens(userID string) (*TokenPair, error) {
+	return s.issuePair(userID, uuid.NewString(), nil)
+}
+
+// IssueTokensWith is like IssueTokens but the access tokens carry custom claims
+// (see GenerateWithClaims). The claims are kept with the refresh token and re-issued on refresh.
+func (s *JWTService) IssueTokensWith(userID string, custom interface{}) (*TokenPair, error) {
+	data, err := json.Marshal(custom)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode custom claims: %w", err)
+	}
+	return s.issuePair(userID, uuid.NewString(), data)
+}
+
+// Refresh exchanges a refresh token for a new token pair, rotating the refresh token.
+// Presenting a token that was already exchanged revokes its whole family and returns ErrRefreshTokenReused.
+func (s *JWTService) Refresh(refreshToken string) (*TokenPair, error) {
+	hash := hashRefreshToken(refreshToken)
+	stored, err := s.RefreshStore.Get(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load refresh token: %w", err)
+	}
+	if stored == nil || stored.Revoked || time.Now().After(stored.ExpiresAt) {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	alreadyUsed, err := s.RefreshStore.MarkUsed(hash)
+	if errors.Is(err, ErrRefreshTokenInvalid) {
+		return nil, err // Revoked or swept since Get
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	if alreadyUsed {
+		if err := s.RefreshStore.RevokeFamily(stored.FamilyID); err != nil {
+			return nil, fmt.Errorf("failed to revoke token family: %w", err)
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	return s.issuePair(stored.UserID, stored.FamilyID, stored.Claims)
+}
+
+// RevokeRefreshToken revokes the family the given refresh token belongs to (e.g. on logout).
+// Unknown tokens are ignored.
+func (s *JWTService) RevokeRefreshToken(refreshToken string) error {
+	stored, err := s.RefreshStore.Get(hashRefreshToken(refreshToken))
+	if err != nil || stored == nil {
+		return err
+	}
+	return s.RefreshStore.RevokeFamily(stored.FamilyID)
+}
+
+// RevokeTokenID adds an access token ID ("jti") to the denylist, so Validate rejects it
+// for the rest of its lifetime.
+func (s *JWTService) RevokeTokenID(tokenID string) error {
+	if tokenID == "" {
+		return errors.New("token has no ID")
+	}
+	// Tokens never outlive TTL plus leeway, so that is how long the entry must be kept
+	return s.Denylist.Add(tokenID, time.Now().Add(s.TTL+s.Leeway))
+}
+
+// issuePair signs an access token and creates a refresh token in the given family.
+func (s *JWTService) issuePair(userID, familyID string, custom json.RawMessage) (*TokenPair, error) {
+	var accessToken string
+	var err error
+	if custom != nil {
+		accessToken, err = s.GenerateWithClaims(userID, custom)
+	} else {
+		accessToken, err = s.Generate(userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now()
+	if err := s.RefreshStore.Save(&RefreshToken{
+		Hash:      hashRefreshToken(refreshToken),
+		FamilyID:  familyID,
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.RefreshTTL),
+		Claims:    custom,
+	}); err != nil {
+		if errors.Is(err, ErrRefreshTokenInvalid) {
+			return nil, err // Family revoked while refreshing
+		}
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.TTL.Seconds()),
+	}, nil
+}
+
+// hashRefreshToken hashes an opaque refresh token for storage. Tokens are random,
+// so a fast hash is sufficient.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// MemoryRefreshTokenStore is an in-memory RefreshTokenStore. Expired tokens are evicted lazily.
+type MemoryRefreshTokenStore struct {
+	mu              sync.Mutex
+	tokens          map[string]*RefreshToken // map[hash]*RefreshToken
+	revokedFamilies map[string]time.Time     // map[familyID]keepUntil
+	lastSweep       time.Time
+}
+
+// revokedFamilyRetention is how long a revoked family is remembered at least, covering refreshes
+// that were already past MarkUsed when it was revoked.
+const revokedFamilyRetention = time.Hour
+
+// NewMemoryRefreshTokenStore creates an empty in-memory refresh token store.
+func NewMemoryRefreshTokenStore() *MemoryRefreshTokenStore {
+	return &MemoryRefreshTokenStore{
+		tokens:          make(map[string]*RefreshToken),
+		revokedFamilies: make(map[string]time.Time),
+		lastSweep:       time.Now(),
+	}
+}
+
+// Save implements RefreshTokenStore.
+func (s *MemoryRefreshTokenStore) Save(token *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for hash, t := range s.tokens {
+			if now.After(t.ExpiresAt) {
+				delete(s.tokens, hash)
+			}
+		}
+		for family, keepUntil := range s.revokedFamilies {
+			if now.After(keepUntil) {
+				delete(s.revokedFamilies, family)
+			}
+		}
+		s.lastSweep = now
+	}
+	if _, revoked := s.revokedFamilies[token.FamilyID]; revoked {
+		return ErrRefreshTokenInvalid
+	}
+	copied := *token
+	s.tokens[token.Hash] = &copied
+	return nil
+}
+
+// Get implements RefreshTokenStore.
+func (s *MemoryRefreshTokenStore) Get(hash string) (*RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[hash]
+	if !ok {
+		return nil, nil
+	}
+	copied := *token
+	if _, revoked := s.revokedFamilies[token.FamilyID]; revoked {
+		copied.Revoked = true
+	}
+	return &copied, nil
+}
+
+// MarkUsed implements RefreshTokenStore.
+func (s *MemoryRefreshTokenStore) MarkUsed(hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[hash]
+	if !ok || token.Revoked || time.Now().After(token.ExpiresAt) {
+		return false, ErrRefreshTokenInvalid
+	}
+	if _, revoked := s.revokedFamilies[token.FamilyID]; revoked {
+		return false, ErrRefreshTokenInvalid
+	}
+	alreadyUsed := token.Used
+	token.Used = true
+	return alreadyUsed, nil
+}
+
+// RevokeFamily implements RefreshTokenStore.
+func (s *MemoryRefreshTokenStore) RevokeFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokeFamilyLocked(familyID)
+	return nil
+}
+
+// RevokeUser implements RefreshTokenStore.
+func (s *MemoryRefreshTokenStore) RevokeUser(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, token := range s.tokens {
+		if token.UserID == userID {
+			s.revokeFamilyLocked(token.FamilyID)
+		}
+	}
+	return nil
+}
+
+// revokeFamilyLocked flags the family's tokens and remembers the family until its last token
+// expires. The caller must hold s.mu.
+func (s *MemoryRefreshTokenStore) revokeFamilyLocked(familyID string) {
+	keepUntil := time.Now().Add(revokedFamilyRetention)
+	for _, token := range s.tokens {
+		if token.FamilyID == familyID {
+			token.Revoked = true
+			if token.ExpiresAt.After(keepUntil) {
+				keepUntil = token.ExpiresAt
+			}
+		}
+	}
+	if keepUntil.After(s.revokedFamilies[familyID]) {
+		s.revokedFamilies[familyID] = keepUntil
+	}
+}
+
+// MemoryTokenDenylist is an in-memory TokenDenylist. Entries are dropped once they expire.
+type MemoryTokenDenylist struct {
+	mu      sync.Mutex
+	entries map[string]time.Time // map[tokenID]expiresAt
+}
+
+// NewMemoryTokenDenylist creates an empty in-memory denylist.
+func NewMemoryTokenDenylist() *MemoryTokenDenylist {
+	return &MemoryTokenDenylist{
+		entries: make(map[string]time.Time),
+	}
+}
+
+// Add implements TokenDenylist.
+func (d *MemoryTokenDenylist) Add(tokenID string, expiresAt time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for id, exp := range d.entries { // The list only holds live tokens, so it stays small
+		if now.After(exp) {
+			delete(d.entries, id)
+		}
+	}
+	d.entries[tokenID] = expiresAt
+	return nil
+}
+
+// Contains implements TokenDenylist.
+func (d *MemoryTokenDenylist) Contains(tokenID string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	exp, ok := d.entries[tokenID]
+	return ok && time.Now().Before(exp), nil
+}
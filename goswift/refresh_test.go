@@ -0,0 +1,202 @@
+// go-swift/goswift/refresh_test.go
+package goswift
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// racingRefreshStore runs afterGet once Get returns and afterMarkUsed once MarkUsed succeeds,
+// standing in for a concurrent request.
+type racingRefreshStore struct {
+	*MemoryRefreshTokenStore
+	afterGet      func(token *RefreshToken)
+	afterMarkUsed func(hash string)
+}
+
+func (s *racingRefreshStore) Get(hash string) (*RefreshToken, error) {
+	token, err := s.MemoryRefreshTokenStore.Get(hash)
+	if token != nil && s.afterGet != nil {
+		s.afterGet(token)
+	}
+	return token, err
+}
+
+func (s *racingRefreshStore) MarkUsed(hash string) (bool, error) {
+	alreadyUsed, err := s.MemoryRefreshTokenStore.MarkUsed(hash)
+	if err == nil && s.afterMarkUsed != nil {
+		s.afterMarkUsed(hash)
+	}
+	return alreadyUsed, err
+}
+
+// testRefreshService returns a JWTService whose refresh tokens live in store.
+func testRefreshService(t *testing.T, store RefreshTokenStore) *JWTService {
+	t.Helper()
+	s := NewJWTService("app", []string{"app"}, time.Minute)
+	key, err := NewHMACKey("k1", "HS256", []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddKey(key); err != nil {
+		t.Fatal(err)
+	}
+	s.RefreshStore = store
+	return s
+}
+
+// Refresh tokens rotate, and presenting a rotated one revokes the family.
+func TestRefreshRotation(t *testing.T) {
+	s := testRefreshService(t, NewMemoryRefreshTokenStore())
+	first, err := s.IssueTokens("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := s.Validate(second.AccessToken); err != nil || claims.UserID != "user-1" {
+		t.Fatalf("Validate = %v, %v", claims, err)
+	}
+
+	if _, err := s.Refresh(first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reusing a rotated token: got %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := s.Refresh(second.RefreshToken); err != ErrRefreshTokenInvalid {
+		t.Fatalf("after reuse, the family's latest token: got %v, want ErrRefreshTokenInvalid", err)
+	}
+	if _, err := s.Refresh("unknown"); err != ErrRefreshTokenInvalid {
+		t.Fatalf("unknown token: got %v, want ErrRefreshTokenInvalid", err)
+	}
+}
+
+// A token revoked or swept between Get and MarkUsed is invalid; no new token is issued.
+func TestRefreshRacesWithRevocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		afterGet func(store *MemoryRefreshTokenStore, token *RefreshToken)
+	}{
+		{"family revoked", func(store *MemoryRefreshTokenStore, token *RefreshToken) {
+			store.RevokeFamily(token.FamilyID)
+		}},
+		{"user revoked", func(store *MemoryRefreshTokenStore, token *RefreshToken) {
+			store.RevokeUser(token.UserID)
+		}},
+		{"swept", func(store *MemoryRefreshTokenStore, token *RefreshToken) {
+			store.mu.Lock()
+			delete(store.tokens, token.Hash)
+			store.mu.Unlock()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := NewMemoryRefreshTokenStore()
+			store := &racingRefreshStore{MemoryRefreshTokenStore: memory}
+			s := testRefreshService(t, store)
+			pair, err := s.IssueTokens("user-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			store.afterGet = func(token *RefreshToken) { tt.afterGet(memory, token) }
+			if _, err := s.Refresh(pair.RefreshToken); err != ErrRefreshTokenInvalid { // Unwrapped, so callers answer 401
+				t.Fatalf("got %v, want ErrRefreshTokenInvalid", err)
+			}
+			memory.mu.Lock()
+			defer memory.mu.Unlock()
+			for _, token := range memory.tokens {
+				if !token.Revoked && !token.Used {
+					t.Fatalf("a usable token %+v was issued", token)
+				}
+			}
+		})
+	}
+}
+
+// A family revoked after MarkUsed but before the next token is saved stays revoked: Save refuses
+// the new token instead of starting the family over.
+func TestRefreshRacesWithRevocationBeforeSave(t *testing.T) {
+	tests := []struct {
+		name          string
+		afterMarkUsed func(s *JWTService, store *MemoryRefreshTokenStore, refreshToken string)
+	}{
+		{"logout", func(s *JWTService, store *MemoryRefreshTokenStore, refreshToken string) {
+			s.RevokeRefreshToken(refreshToken)
+		}},
+		{"reuse detected", func(s *JWTService, store *MemoryRefreshTokenStore, refreshToken string) {
+			if _, err := s.Refresh(refreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+				t.Errorf("concurrent reuse: got %v, want ErrRefreshTokenReused", err)
+			}
+		}},
+		{"user revoked", func(s *JWTService, store *MemoryRefreshTokenStore, refreshToken string) {
+			store.RevokeUser("user-1")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := NewMemoryRefreshTokenStore()
+			store := &racingRefreshStore{MemoryRefreshTokenStore: memory}
+			s := testRefreshService(t, store)
+			pair, err := s.IssueTokens("user-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			store.afterMarkUsed = func(string) {
+				store.afterMarkUsed = nil // Only the outer refresh races
+				tt.afterMarkUsed(s, memory, pair.RefreshToken)
+			}
+			if _, err := s.Refresh(pair.RefreshToken); err != ErrRefreshTokenInvalid { // Unwrapped, so callers answer 401
+				t.Fatalf("got %v, want ErrRefreshTokenInvalid", err)
+			}
+			memory.mu.Lock()
+			for _, token := range memory.tokens {
+				if !token.Revoked {
+					t.Errorf("token %+v of the revoked family was saved", token)
+				}
+			}
+			memory.mu.Unlock()
+
+			// A new login starts a new family, which is unaffected
+			next, err := s.IssueTokens("user-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Refresh(next.RefreshToken); err != nil {
+				t.Fatalf("refreshing a new family: %v", err)
+			}
+		})
+	}
+}
+
+// Revoked families are forgotten once their tokens could no longer be used anyway.
+func TestMemoryRefreshTokenStoreSweepsRevokedFamilies(t *testing.T) {
+	store := NewMemoryRefreshTokenStore()
+	now := time.Now()
+	if err := store.Save(&RefreshToken{Hash: "h1", FamilyID: "f1", ExpiresAt: now.Add(2 * revokedFamilyRetention)}); err != nil {
+		t.Fatal(err)
+	}
+	store.RevokeFamily("f1")
+	if err := store.Save(&RefreshToken{Hash: "h2", FamilyID: "f1", ExpiresAt: now.Add(time.Hour)}); err != ErrRefreshTokenInvalid {
+		t.Fatalf("Save to a revoked family: got %v, want ErrRefreshTokenInvalid", err)
+	}
+	if token, _ := store.Get("h1"); token == nil || !token.Revoked {
+		t.Fatalf("Get = %+v, want the token revoked", token)
+	}
+
+	store.mu.Lock()
+	if keepUntil := store.revokedFamilies["f1"]; keepUntil.Before(now.Add(2 * revokedFamilyRetention)) {
+		t.Errorf("family kept until %v, before its last token expires", keepUntil)
+	}
+	store.revokedFamilies["f1"] = now.Add(-time.Second)
+	store.lastSweep = now.Add(-2 * time.Minute)
+	store.mu.Unlock()
+	store.Save(&RefreshToken{Hash: "h3", FamilyID: "f2", ExpiresAt: now.Add(time.Hour)})
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.revokedFamilies["f1"]; ok {
+		t.Error("expired family entry was not swept")
+	}
+}
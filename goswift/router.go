@@ -18,6 +18,8 @@ type route struct {
 	regex *regexp.Regexp
 	// Names of path parameters, in order
 	paramNames []string
+	// Maximum request body size for this route (0 = no route-specific limit)
+	bodyLimit int64
 }
 
 // Router manages the routing logic for the GoSwift framework.
@@ -42,6 +44,7 @@ type RouteBuilder struct {
 	handler HandlerFunc
 	beforeMW []MiddlewareFunc
 	afterMW  []MiddlewareFunc
+	bodyLimit int64
 }
 
 // AddRoute initiates a RouteBuilder for a new route.
@@ -115,19 +118,28 @@ func (rb *RouteBuilder) Handler() {
 		after:      rb.afterMW,
 		regex:      compiledRegex,
 		paramNames: paramNames,
+		bodyLimit:  rb.bodyLimit,
 	}
 }
 
 // MatchRoute attempts to find a matching handler for the given HTTP method and request path.
 // It also extracts any path parameters.
 func (r *Router) MatchRoute(method, requestPath string) (HandlerFunc, map[string]string) {
+	handler, params, _ := r.match(method, requestPath)
+	return handler, params
+}
+
+// match is like MatchRoute but also returns the matched route, so the engine can
+// apply route-level settings (such as body limits) before global middleware runs.
+func (r *Router) match(method, requestPath string) (HandlerFunc, map[string]string, *route) {
 	methodRoutes, ok := r.routes[method]
 	if !ok {
-		return nil, nil // No routes for this method
+		return nil, nil, nil // No routes for this method
 	}
 
 	var bestMatchHandler HandlerFunc
 	var bestMatchParams map[string]string
+	var bestMatchRoute *route
 	longestMatchLen := -1 // To prioritize more specific routes
 
 	for pattern, rt := range methodRoutes {
@@ -148,6 +160,8 @@ func (r *Router) MatchRoute(method, requestPath string) (HandlerFunc, map[string
 				bestMatchHandler = rt.handler
 				bestMatchParams = params
 				longestMatchLen = len(pattern)
+				matched := rt
+				bestMatchRoute = &matched
 
 				// Apply route-specific middleware
 				// The order is: beforeMW -> handler -> afterMW
@@ -165,5 +179,5 @@ func (r *Router) MatchRoute(method, requestPath string) (HandlerFunc, map[string
 		}
 	}
 
-	return bestMatchHandler, bestMatchParams
+	return bestMatchHandler, bestMatchParams, bestMatchRoute
 }
@@ -0,0 +1,364 @@
+// go-swift/goswift/sessionstore.go
+package goswift
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionStore persists sessions for the SessionManager.
+// The id passed to Get, Delete and Touch is the cookie value returned by Save or Touch:
+// the session ID for server-side stores, the sealed session for CookieSessionStore.
+type SessionStore interface {
+	// Get returns the session for id, or nil (and no error) if it does not exist or has expired.
+	Get(id string) (*Session, error)
+	// Save stores the session and returns the value to put in the session cookie.
+	Save(session *Session) (string, error)
+	// Delete removes the session. Deleting an unknown session is not an error.
+	Delete(id string) error
+	// Touch moves the session's expiry to expiresAt and returns the (possibly new) cookie value.
+	Touch(id string, expiresAt time.Time) (string, error)
+	// GC removes expired sessions.
+	GC() error
+}
+
+// ErrSessionNotFound is returned by Touch when the session does not exist or has expired.
+var ErrSessionNotFound = errors.New("session not found")
+
+// --- In-memory store ---
+
+// MemorySessionStore keeps sessions in process memory. Sessions are lost on restart
+// and are not shared between instances.
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]Session // map[sessionID]Session
+}
+
+// NewMemorySessionStore creates an empty in-memory session store.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]Session),
+	}
+}
+
+// Get implements SessionStore.
+func (s *MemorySessionStore) Get(id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[id]
+	if !ok || session.ExpiresAt.Before(time.Now()) {
+		return nil, nil
+	}
+	return session.clone(), nil
+}
+
+// Save implements SessionStore.
+func (s *MemorySessionStore) Save(session *Session) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session.ID] = *session.clone() // Don't share Values with the caller
+	return session.ID, nil
+}
+
+// Delete implements SessionStore.
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// Touch implements SessionStore.
+func (s *MemorySessionStore) Touch(id string, expiresAt time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.ExpiresAt.Before(time.Now()) {
+		return "", ErrSessionNotFound
+	}
+	session.ExpiresAt = expiresAt
+	s.sessions[id] = session
+	return id, nil
+}
+
+// GC implements SessionStore.
+func (s *MemorySessionStore) GC() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, session := range s.sessions {
+		if session.ExpiresAt.Before(now) {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+// --- File store ---
+
+// FileSessionStore keeps one JSON file per session in a directory, so sessions survive restarts
+// and can be shared by instances mounting the same volume.
+type FileSessionStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileSessionStore creates a file-backed session store in dir, creating it if necessary.
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	return &FileSessionStore{dir: dir}, nil
+}
+
+// path maps a session ID to its file. IDs are hashed so they can never escape the directory.
+func (s *FileSessionStore) path(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// read loads a session file; it returns nil if the file does not exist.
+func (s *FileSessionStore) read(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode session: %w", err)
+	}
+	return &session, nil
+}
+
+// write stores a session file atomically via a temporary file and rename.
+func (s *FileSessionStore) write(session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, ".session-*")
+	if err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(session.ID)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	return nil
+}
+
+// Get implements SessionStore.
+func (s *FileSessionStore) Get(id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, err := s.read(s.path(id))
+	if err != nil || session == nil {
+		return nil, err
+	}
+	if session.ExpiresAt.Before(time.Now()) {
+		os.Remove(s.path(id))
+		return nil, nil
+	}
+	return session, nil
+}
+
+// Save implements SessionStore.
+func (s *FileSessionStore) Save(session *Session) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.write(session); err != nil {
+		return "", err
+	}
+	return session.ID, nil
+}
+
+// Delete implements SessionStore.
+func (s *FileSessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// Touch implements SessionStore.
+func (s *FileSessionStore) Touch(id string, expiresAt time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, err := s.read(s.path(id))
+	if err != nil {
+		return "", err
+	}
+	if session == nil || session.ExpiresAt.Before(time.Now()) {
+		return "", ErrSessionNotFound
+	}
+	session.ExpiresAt = expiresAt
+	if err := s.write(session); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// GC implements SessionStore.
+func (s *FileSessionStore) GC() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+		session, err := s.read(path)
+		if err != nil || (session != nil && session.ExpiresAt.Before(now)) {
+			os.Remove(path) // Drop expired and corrupt files alike
+		}
+	}
+	return nil
+}
+
+// --- Cookie store ---
+
+// maxCookieSessionSize keeps sealed sessions below the ~4KB browsers allow per cookie.
+const maxCookieSessionSize = 4000
+
+// cookieSessionAAD binds sealed values to their purpose so they can't be swapped with other ciphertexts.
+var cookieSessionAAD = []byte("goswift-session")
+
+// CookieSessionStore is a stateless store that keeps the whole session in the cookie,
+// encrypted and authenticated with AES-GCM. Several keys may be configured for rotation:
+// the first seals new sessions, all of them are tried when opening.
+// Because nothing is stored server-side, Delete cannot revoke a cookie that was copied
+// elsewhere; it only stops being valid when it expires.
+type CookieSessionStore struct {
+	aeads []cipher.AEAD
+}
+
+// NewCookieSessionStore creates a cookie store from one or more AES keys (16, 24 or 32 bytes).
+// List the current key first, followed by older keys that should still be accepted.
+func NewCookieSessionStore(keys ...[]byte) (*CookieSessionStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("cookie session store requires at least one key")
+	}
+	store := &CookieSessionStore{}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid session key %d: %w", i, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid session key %d: %w", i, err)
+		}
+		store.aeads = append(store.aeads, aead)
+	}
+	return store, nil
+}
+
+// seal encrypts the session with the current key.
+func (s *CookieSessionStore) seal(session *Session) (string, error) {
+	plaintext, err := json.Marshal(session)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode session: %w", err)
+	}
+	aead := s.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	value := base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, cookieSessionAAD))
+	if len(value) > maxCookieSessionSize {
+		return "", fmt.Errorf("session too large for a cookie (%d bytes)", len(value))
+	}
+	return value, nil
+}
+
+// open decrypts a sealed session, trying every configured key.
+func (s *CookieSessionStore) open(value string) (*Session, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, nil // Not one of ours; treat like a missing session
+	}
+	for _, aead := range s.aeads {
+		if len(data) < aead.NonceSize() {
+			continue
+		}
+		nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+		plaintext, err := aead.Open(nil, nonce, ciphertext, cookieSessionAAD)
+		if err != nil {
+			continue // Try the next (older) key
+		}
+		var session Session
+		if err := json.Unmarshal(plaintext, &session); err != nil {
+			return nil, fmt.Errorf("failed to decode session: %w", err)
+		}
+		return &session, nil
+	}
+	return nil, nil // Tampered or sealed with a retired key
+}
+
+// Get implements SessionStore.
+func (s *CookieSessionStore) Get(id string) (*Session, error) {
+	session, err := s.open(id)
+	if err != nil || session == nil || session.ExpiresAt.Before(time.Now()) {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Save implements SessionStore.
+func (s *CookieSessionStore) Save(session *Session) (string, error) {
+	return s.seal(session)
+}
+
+// Delete implements SessionStore. It is a no-op: clearing the cookie is all a stateless store can do.
+func (s *CookieSessionStore) Delete(id string) error {
+	return nil
+}
+
+// Touch implements SessionStore by re-sealing the session with the new expiry (and current key).
+func (s *CookieSessionStore) Touch(id string, expiresAt time.Time) (string, error) {
+	session, err := s.Get(id)
+	if err != nil {
+		return "", err
+	}
+	if session == nil {
+		return "", ErrSessionNotFound
+	}
+	session.ExpiresAt = expiresAt
+	return s.seal(session)
+}
+
+// GC implements SessionStore. Cookie sessions expire on their own, so there is nothing to collect.
+func (s *CookieSessionStore) GC() error {
+	return nil
+}
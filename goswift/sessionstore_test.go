@@ -0,0 +1,267 @@
+// go-swift/goswift/sessionstore_test.go
+package goswift
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sessionStoreTraits describes what an implementation can promise beyond the interface.
+type sessionStoreTraits struct {
+	stateless bool // Sessions live in the cookie, so Delete cannot revoke them
+}
+
+// testSession returns a session that expires after ttl (negative for an expired one).
+func testSession(t *testing.T, ttl time.Duration) *Session {
+	t.Helper()
+	id, err := randomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	session := &Session{ID: id, UserID: "user-1", CreatedAt: now, ExpiresAt: now.Add(ttl), AbsoluteExpiresAt: now.Add(24 * time.Hour)}
+	session.Set("theme", "dark")
+	session.FlashMessages = []string{"Welcome back"}
+	return session
+}
+
+// runSessionStoreConformance is the behaviour every SessionStore must provide. Each subtest gets
+// a fresh store from newStore.
+func runSessionStoreConformance(t *testing.T, newStore func(t *testing.T) SessionStore, traits sessionStoreTraits) {
+	t.Run("SaveAndGet", func(t *testing.T) {
+		store := newStore(t)
+		session := testSession(t, time.Hour)
+		token, err := store.Save(session)
+		if err != nil || token == "" {
+			t.Fatalf("Save: %q, %v", token, err)
+		}
+		got, err := store.Get(token)
+		if err != nil || got == nil {
+			t.Fatalf("Get: %v, %v", got, err)
+		}
+		if got.ID != session.ID || got.UserID != "user-1" || !got.ExpiresAt.Equal(session.ExpiresAt) ||
+			!got.AbsoluteExpiresAt.Equal(session.AbsoluteExpiresAt) || !got.CreatedAt.Equal(session.CreatedAt) {
+			t.Fatalf("Get returned %+v, saved %+v", got, session)
+		}
+		if theme, _ := got.Get("theme"); theme != "dark" {
+			t.Fatalf("value: got %v", theme)
+		}
+		if len(got.FlashMessages) != 1 || got.FlashMessages[0] != "Welcome back" {
+			t.Fatalf("flashes: got %v", got.FlashMessages)
+		}
+	})
+
+	t.Run("SaveOverwrites", func(t *testing.T) {
+		store := newStore(t)
+		session := testSession(t, time.Hour)
+		if _, err := store.Save(session); err != nil {
+			t.Fatal(err)
+		}
+		session.Set("theme", "light")
+		token, err := store.Save(session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.Get(token)
+		if err != nil || got == nil {
+			t.Fatalf("Get: %v, %v", got, err)
+		}
+		if theme, _ := got.Get("theme"); theme != "light" {
+			t.Fatalf("value: got %v, want the latest save", theme)
+		}
+	})
+
+	t.Run("NoSharedState", func(t *testing.T) {
+		store := newStore(t)
+		session := testSession(t, time.Hour)
+		token, err := store.Save(session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		session.Set("theme", "changed after save")
+		first, _ := store.Get(token)
+		first.Set("theme", "changed after get")
+		second, _ := store.Get(token)
+		if theme, _ := second.Get("theme"); theme != "dark" {
+			t.Fatalf("value: got %v, want the saved value", theme)
+		}
+	})
+
+	t.Run("GetMissing", func(t *testing.T) {
+		store := newStore(t)
+		for _, token := range []string{"", "unknown", "not base64 !", "../../etc/passwd"} {
+			if got, err := store.Get(token); got != nil || err != nil {
+				t.Fatalf("Get(%q) = %v, %v; want nil, nil", token, got, err)
+			}
+		}
+	})
+
+	t.Run("GetExpired", func(t *testing.T) {
+		store := newStore(t)
+		token, err := store.Save(testSession(t, -time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := store.Get(token); got != nil || err != nil {
+			t.Fatalf("Get = %v, %v; want nil, nil", got, err)
+		}
+	})
+
+	t.Run("Touch", func(t *testing.T) {
+		store := newStore(t)
+		token, err := store.Save(testSession(t, time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expiresAt := time.Now().Add(2 * time.Hour)
+		token, err = store.Touch(token, expiresAt)
+		if err != nil || token == "" {
+			t.Fatalf("Touch: %q, %v", token, err)
+		}
+		got, err := store.Get(token)
+		if err != nil || got == nil || !got.ExpiresAt.Equal(expiresAt) {
+			t.Fatalf("Get after Touch: %+v, %v; want expiry %v", got, err, expiresAt)
+		}
+		if theme, _ := got.Get("theme"); theme != "dark" {
+			t.Fatalf("Touch lost values: %v", got.Values)
+		}
+	})
+
+	t.Run("TouchMissingOrExpired", func(t *testing.T) {
+		store := newStore(t)
+		expired, err := store.Save(testSession(t, -time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, token := range []string{"unknown", expired} {
+			if _, err := store.Touch(token, time.Now().Add(time.Hour)); !errors.Is(err, ErrSessionNotFound) {
+				t.Fatalf("Touch(%q): got %v, want ErrSessionNotFound", token, err)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := newStore(t)
+		if err := store.Delete("unknown"); err != nil {
+			t.Fatalf("Delete of an unknown session: %v", err)
+		}
+		token, err := store.Save(testSession(t, time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Delete(token); err != nil {
+			t.Fatal(err)
+		}
+		if traits.stateless {
+			return // The cookie stays valid until it expires
+		}
+		if got, err := store.Get(token); got != nil || err != nil {
+			t.Fatalf("Get after Delete = %v, %v; want nil, nil", got, err)
+		}
+	})
+
+	t.Run("GC", func(t *testing.T) {
+		store := newStore(t)
+		live, err := store.Save(testSession(t, time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expired, err := store.Save(testSession(t, -time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.GC(); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := store.Get(live); got == nil {
+			t.Fatal("GC removed a live session")
+		}
+		if got, _ := store.Get(expired); got != nil {
+			t.Fatal("expired session still returned after GC")
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		store := newStore(t)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				session := testSession(t, time.Hour)
+				for j := 0; j < 20; j++ {
+					session.Set("n", fmt.Sprint(i, j))
+					token, err := store.Save(session)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if got, err := store.Get(token); err != nil || got == nil {
+						t.Errorf("Get: %v, %v", got, err)
+						return
+					}
+					if _, err := store.Touch(token, time.Now().Add(time.Hour)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+				store.GC()
+			}(i)
+		}
+		wg.Wait()
+	})
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	runSessionStoreConformance(t, func(t *testing.T) SessionStore {
+		return NewMemorySessionStore()
+	}, sessionStoreTraits{})
+}
+
+func TestFileSessionStore(t *testing.T) {
+	runSessionStoreConformance(t, func(t *testing.T) SessionStore {
+		store, err := NewFileSessionStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}, sessionStoreTraits{})
+}
+
+func TestCookieSessionStore(t *testing.T) {
+	runSessionStoreConformance(t, func(t *testing.T) SessionStore {
+		store, err := NewCookieSessionStore([]byte("0123456789abcdef0123456789abcdef"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}, sessionStoreTraits{stateless: true})
+}
+
+// Cookies sealed with a retired key still open while it is listed; tampered ones never do.
+func TestCookieSessionStoreKeyRotation(t *testing.T) {
+	oldKey, newKey := []byte("old-key-0123456789abcdef01234567"), []byte("new-key-0123456789abcdef01234567")
+	oldStore, _ := NewCookieSessionStore(oldKey)
+	token, err := oldStore.Save(testSession(t, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, _ := NewCookieSessionStore(newKey, oldKey)
+	if got, err := rotated.Get(token); err != nil || got == nil {
+		t.Fatalf("Get with the old key listed: %v, %v", got, err)
+	}
+	retired, _ := NewCookieSessionStore(newKey)
+	if got, err := retired.Get(token); got != nil || err != nil {
+		t.Fatalf("Get with the old key retired: %v, %v", got, err)
+	}
+
+	tampered := []byte(token)
+	tampered[len(tampered)/2] ^= 1
+	if got, _ := rotated.Get(string(tampered)); got != nil {
+		t.Fatal("tampered cookie accepted")
+	}
+}
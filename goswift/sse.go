@@ -2,115 +2,794 @@
 package goswift
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// SSEClient represents a single SSE client connection.
+// SSEEvent is a single server-sent event.
+type SSEEvent struct {
+	ID    string        `json:"id,omitempty"`    // Becomes the client's Last-Event-ID; assigned by BroadcastEvent if empty
+	Event string        `json:"event,omitempty"` // Event type; empty for the default "message" type
+	Data  string        `json:"data,omitempty"`  // Payload; may span several lines
+	Retry time.Duration `json:"retry,omitempty"` // Reconnection delay hint for the client; 0 to leave it unchanged
+}
+
+// sseFieldReplacer strips line breaks from single-line fields, which would end the field early.
+var sseFieldReplacer = strings.NewReplacer("\r\n", "", "\r", "", "\n", "", "\x00", "")
+
+// Encode returns the event in the text/event-stream format. Data is split into one "data:"
+// line per line (CRLF, CR and LF all count as line breaks, as they do for the client).
+func (e SSEEvent) Encode() []byte {
+	var b bytes.Buffer
+	if e.ID != "" {
+		b.WriteString("id: " + sseFieldReplacer.Replace(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + sseFieldReplacer.Replace(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	if e.Data != "" || e.Event != "" || e.ID != "" { // A retry-only event carries no data
+		data := strings.ReplaceAll(strings.ReplaceAll(e.Data, "\r\n", "\n"), "\r", "\n")
+		for _, line := range strings.Split(data, "\n") {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+	return b.Bytes()
+}
+
+// WriteTo writes the encoded event to w.
+func (e SSEEvent) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(e.Encode())
+	return int64(n), err
+}
+
+// sseReplayBuffer is a ring buffer of a topic's most recent events.
+type sseReplayBuffer struct {
+	events []SSEEvent
+	next   int // Index the next event is written to
+	full   bool
+}
+
+// add appends an event, overwriting the oldest one when the buffer is full.
+func (r *sseReplayBuffer) add(e SSEEvent) {
+	r.events[r.next] = e
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// since returns the events after the one with the given ID, oldest first.
+// It reports false if that event is no longer (or was never) in the buffer.
+func (r *sseReplayBuffer) since(id string) ([]SSEEvent, bool) {
+	ordered := r.events[:r.next]
+	if r.full {
+		ordered = append(append([]SSEEvent(nil), r.events[r.next:]...), r.events[:r.next]...)
+	}
+	for i, e := range ordered {
+		if e.ID == id {
+			return append([]SSEEvent(nil), ordered[i+1:]...), true
+		}
+	}
+	return nil, false
+}
+
+// --- Streams ---
+
+// SSEStream writes server-sent events to one client. It is only usable while the function
+// passed to Context.SSE runs; afterwards Send and Comment return ErrSSEStreamClosed.
+type SSEStream struct {
+	ctx         context.Context
+	writer      io.Writer
+	controller  *http.ResponseController
+	lastEventID string
+	mu          sync.Mutex // Serializes writes, so Send may be called from several goroutines
+	closed      bool
+}
+
+// ErrSSEStreamClosed is returned when writing to a stream whose handler has returned.
+var ErrSSEStreamClosed = errors.New("SSE stream is closed")
+
+// Send writes an event and flushes it to the client.
+func (s *SSEStream) Send(event SSEEvent) error {
+	return s.write(event.Encode())
+}
+
+// Comment writes a comment line, which clients ignore; useful as a keep-alive.
+func (s *SSEStream) Comment(text string) error {
+	return s.write([]byte(": " + sseFieldReplacer.Replace(text) + "\n\n"))
+}
+
+// write writes and flushes raw event-stream data.
+func (s *SSEStream) write(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSSEStreamClosed
+	}
+	if _, err := s.writer.Write(b); err != nil {
+		return err
+	}
+	return s.controller.Flush()
+}
+
+// Context returns a context that is cancelled when the client disconnects or the server shuts down.
+func (s *SSEStream) Context() context.Context {
+	return s.ctx
+}
+
+// Done is shorthand for Context().Done().
+func (s *SSEStream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// LastEventID returns the Last-Event-ID header sent by a reconnecting client, or "".
+func (s *SSEStream) LastEventID() string {
+	return s.lastEventID
+}
+
+// close stops further writes to the stream.
+func (s *SSEStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+// SSE turns the response into an event stream and calls fn with it. It blocks until fn returns,
+// which ends the response, so the handler should simply return SSE's result:
+//
+//	return c.SSE(func(stream *goswift.SSEStream) error {
+//		for {
+//			select {
+//			case msg := <-updates:
+//				if err := stream.Send(goswift.SSEEvent{Data: msg}); err != nil {
+//					return err
+//				}
+//			case <-stream.Done():
+//				return nil
+//			}
+//		}
+//	})
+//
+// The status and headers are sent before fn runs, so errors returned by fn are logged rather
+// than turned into an error response.
+func (c *Context) SSE(fn func(stream *SSEStream) error) error {
+	controller := http.NewResponseController(c.Writer)
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")    // Stop nginx-style proxies from buffering the stream
+	controller.SetWriteDeadline(time.Time{}) // A server WriteTimeout would cut long streams off
+	c.Writer.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		c.engine.Logger.Error("SSE: Streaming not supported by the response writer: %v", err)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	if c.engine != nil && c.engine.shutdownCtx != nil {
+		stop := context.AfterFunc(c.engine.shutdownCtx, cancel)
+		defer stop()
+	}
+
+	stream := &SSEStream{
+		ctx:         ctx,
+		writer:      c.Writer,
+		controller:  controller,
+		lastEventID: c.Request.Header.Get("Last-Event-ID"),
+	}
+	err := fn(stream)
+	stream.close()
+	if err != nil && ctx.Err() == nil && !errors.Is(err, ErrSSEStreamClosed) { // Write errors after a disconnect are routine
+		c.engine.Logger.Error("SSE: Stream for %s ended with error: %v", c.Request.URL.Path, err)
+	}
+	return nil
+}
+
+// --- Manager ---
+
+// SSEResyncEvent is the type of the event telling a client it missed events and should refetch
+// the current state.
+const SSEResyncEvent = "resync"
+
+// SSEPolicy decides what happens to events for a client that cannot keep up, i.e. whose queue
+// (SSEManager.QueueSize) is full.
+type SSEPolicy int
+
+const (
+	SSEDropOldest     SSEPolicy = iota // Discard the oldest queued event, then send a resync event
+	SSEDropNewest                      // Discard the new event, then send a resync event
+	SSECoalesceLatest                  // Replace a queued event of the same type, so only the latest is sent; for events carrying whole state
+	SSEDisconnectSlow                  // Disconnect the client; it reconnects and resumes with Last-Event-ID
+)
+
+// String returns the policy name used in logs and stats.
+func (p SSEPolicy) String() string {
+	switch p {
+	case SSEDropOldest:
+		return "drop-oldest"
+	case SSEDropNewest:
+		return "drop-newest"
+	case SSECoalesceLatest:
+		return "coalesce-latest"
+	case SSEDisconnectSlow:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler, so stats show policy names.
+func (p SSEPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// SSEClient is one subscribed connection. IDs are unique per connection, so the same user may
+// be subscribed from several tabs.
 type SSEClient struct {
-	ID   string
-	Chan chan string // Channel to send messages to this client
+	ID     string
+	Topic  string
+	UserID string // Authenticated user, if any
+
+	mu      sync.Mutex
+	queue   []SSEEvent // Events waiting to be written
+	resync  bool       // Events were dropped; a resync event follows the queue
+	dropped uint64
+
+	notify    chan struct{} // Signalled when the queue becomes non-empty
+	done      chan struct{} // Closed when the manager disconnects the client
+	closeOnce sync.Once
+}
+
+// enqueue adds an event according to policy. It reports whether an event was dropped and
+// whether the client must be disconnected instead.
+func (cl *SSEClient) enqueue(event SSEEvent, policy SSEPolicy, capacity int) (dropped, disconnect bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if policy == SSECoalesceLatest {
+		for i, queued := range cl.queue {
+			if queued.Event == event.Event {
+				cl.queue = append(cl.queue[:i], cl.queue[i+1:]...)
+				cl.dropped++
+				dropped = true
+				break
+			}
+		}
+	}
+	if len(cl.queue) >= capacity {
+		switch policy {
+		case SSEDisconnectSlow:
+			cl.dropped += uint64(len(cl.queue)) + 1
+			return true, true
+		case SSEDropNewest:
+			cl.dropped++
+			cl.resync = true
+			return true, false
+		default: // SSEDropOldest, and SSECoalesceLatest with too many event types queued
+			cl.queue = cl.queue[1:]
+			cl.dropped++
+			cl.resync = true
+			dropped = true
+		}
+	}
+	cl.queue = append(cl.queue, event)
+	select {
+	case cl.notify <- struct{}{}:
+	default: // Already signalled
+	}
+	return dropped, false
+}
+
+// drain returns the queued events, followed by a resync event if any were dropped.
+func (cl *SSEClient) drain() []SSEEvent {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	events := cl.queue
+	if cl.resync {
+		events = append(events, SSEEvent{Event: SSEResyncEvent})
+		cl.resync = false
+	}
+	cl.queue = nil
+	return events
+}
+
+// Dropped returns the number of events dropped for the client so far.
+func (cl *SSEClient) Dropped() uint64 {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.dropped
+}
+
+// disconnect tells the client's stream to end.
+func (cl *SSEClient) disconnect() {
+	cl.closeOnce.Do(func() { close(cl.done) })
+}
+
+// SSEClientStats describes a connected client.
+type SSEClientStats struct {
+	ID      string    `json:"id"`
+	Topic   string    `json:"topic"`
+	UserID  string    `json:"user_id,omitempty"`
+	Policy  SSEPolicy `json:"policy"`
+	Queued  int       `json:"queued"`
+	Dropped uint64    `json:"dropped"`
+}
+
+// SSEStats are the SSEManager's delivery metrics.
+type SSEStats struct {
+	Clients         []SSEClientStats `json:"clients"`
+	TotalDropped    uint64           `json:"total_dropped"`    // Including clients that have since disconnected
+	SlowDisconnects uint64           `json:"slow_disconnects"` // Clients disconnected by SSEDisconnectSlow
+}
+
+// sseBrokerPrefix namespaces SSEManager topics on a shared Broker.
+const sseBrokerPrefix = "sse:"
+
+// sseBrokerMessage is what SSEManager publishes on its Broker: an event, or an instruction
+// every instance applies to its own clients.
+type sseBrokerMessage struct {
+	Event          *SSEEvent `json:"event,omitempty"`
+	Close          bool      `json:"close,omitempty"`           // See CloseTopic
+	DisconnectUser string    `json:"disconnect_user,omitempty"` // See DisconnectUser
 }
 
 // SSEManager manages multiple SSE connections and broadcasts messages.
+// Broadcasts go through a Broker, so with a broker shared between instances (NetBroker) they
+// reach clients connected to any of them; an instance subscribes to a topic on the broker while
+// it has clients for it, and for TopicLinger afterwards.
+// It keeps the last ReplaySize events of each topic, so a client reconnecting with a
+// Last-Event-ID header receives the events it missed. Clients that fall QueueSize events
+// behind are handled according to the topic's SSEPolicy.
 type SSEManager struct {
-	mu      sync.RWMutex
-	clients map[string]map[string]SSEClient // map[documentID]map[clientID]SSEClient
-	Logger  *Logger
+	mu              sync.RWMutex
+	broker          Broker
+	clients         map[string]map[string]*SSEClient // map[topic]map[clientID]*SSEClient
+	replay          map[string]*sseReplayBuffer      // map[topic]*sseReplayBuffer
+	policies        map[string]SSEPolicy             // map[topic]SSEPolicy, see SetPolicy
+	brokerSubs      map[string]BrokerSubscription    // map[topic]BrokerSubscription
+	subscribing     map[string]chan struct{}         // map[topic]chan closed once the broker subscription in progress is done
+	releasedSubs    []BrokerSubscription             // Released under mu, unsubscribed from the broker by unlock
+	lingering       map[string]*time.Timer           // map[topic]*time.Timer, for topics without clients
+	epoch           string                           // Prefix of event IDs, unique per process so IDs from other instances or before a restart never collide
+	nextID          atomic.Uint64                    // Source of client IDs
+	nextEventID     atomic.Uint64                    // Source of event IDs
+	totalDropped    atomic.Uint64
+	slowDisconnects atomic.Uint64
+	Logger          *Logger
+	ReplaySize      int           // Events kept per topic for replay; 0 disables replay. Set before the first broadcast.
+	QueueSize       int           // Events queued per client before DefaultPolicy or the topic's policy applies, 16 by default
+	DefaultPolicy   SSEPolicy     // Policy for topics without one of their own, SSEDropOldest by default
+	Heartbeat       time.Duration // Interval of keep-alive comments, 30s by default
+	TopicLinger     time.Duration // How long a topic stays subscribed (and replayable) after its last client left, 1m by default
 }
 
-// NewSSEManager creates and initializes a new SSEManager.
+// NewSSEManager creates and initializes a new SSEManager backed by an in-process MemoryBroker.
+// It keeps 32 events per topic for replay.
 func NewSSEManager(logger *Logger) *SSEManager {
+	return NewSSEManagerWithBroker(logger, NewMemoryBroker())
+}
+
+// NewSSEManagerWithBroker creates an SSEManager that publishes through broker, e.g. a NetBroker
+// shared by several instances.
+func NewSSEManagerWithBroker(logger *Logger, broker Broker) *SSEManager {
+	var nonce [4]byte
+	rand.Read(nonce[:])
 	return &SSEManager{
-		clients: make(map[string]map[string]SSEClient),
-		Logger:  logger,
+		broker:      broker,
+		clients:     make(map[string]map[string]*SSEClient),
+		replay:      make(map[string]*sseReplayBuffer),
+		policies:    make(map[string]SSEPolicy),
+		brokerSubs:  make(map[string]BrokerSubscription),
+		subscribing: make(map[string]chan struct{}),
+		lingering:   make(map[string]*time.Timer),
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36) + hex.EncodeToString(nonce[:]),
+		Logger:      logger,
+		ReplaySize:  32,
+		QueueSize:   16,
+		Heartbeat:   30 * time.Second,
+		TopicLinger: time.Minute,
 	}
 }
 
-// AddClient adds a new SSE client for a specific document.
-func (sm *SSEManager) AddClient(docID, clientID string, c *Context) {
+// SetPolicy sets the backpressure policy of a topic, overriding DefaultPolicy.
+func (sm *SSEManager) SetPolicy(topic string, policy SSEPolicy) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	sm.policies[topic] = policy
+}
 
-	if _, ok := sm.clients[docID]; !ok {
-		sm.clients[docID] = make(map[string]SSEClient)
+// policyLocked returns the policy of a topic.
+func (sm *SSEManager) policyLocked(topic string) SSEPolicy {
+	if policy, ok := sm.policies[topic]; ok {
+		return policy
 	}
+	return sm.DefaultPolicy
+}
 
-	clientChan := make(chan string, 5) // Buffered channel for messages
-	sm.clients[docID][clientID] = SSEClient{ID: clientID, Chan: clientChan}
-
-	sm.Logger.Info("SSE: Client %s connected for document %s", clientID, docID)
-
-	// Set necessary headers for SSE
-	c.Writer.Header().Set("Content-Type", "text/event-stream")
-	c.Writer.Header().Set("Cache-Control", "no-cache")
-	c.Writer.Header().Set("Connection", "keep-alive")
-	c.Writer.Header().Set("Access-Control-Allow-Origin", "*") // Important for CORS with SSE
+// Serve streams the events of a topic to the client until it disconnects, the server shuts
+// down or the manager disconnects it (see DisconnectUser and CloseTopic). It blocks, so
+// handlers should return its result:
+//
+//	app.GET("/api/docs/:id/subscribe", func(c *goswift.Context) error {
+//		return sseManager.Serve(c, c.Param("id"))
+//	})
+//
+// If the request carries a Last-Event-ID header, the events broadcast since that event are sent
+// first; if that event is no longer buffered, the client gets a "resync" event instead, telling it
+// to refetch the current state.
+func (sm *SSEManager) Serve(c *Context, topic string) error {
+	userID, _ := c.UserID() // Optional; used by DisconnectUser
+	return c.SSE(func(stream *SSEStream) error {
+		client, missed, err := sm.Subscribe(topic, userID, stream.LastEventID())
+		if err != nil {
+			return err
+		}
+		defer sm.Unsubscribe(client)
 
-	// Flush the headers immediately
-	flusher, ok := c.Writer.ResponseWriter.(http.Flusher)
-	if !ok {
-		sm.Logger.Error("SSE: Streaming not supported by client writer.")
-		return
-	}
+		for _, event := range missed {
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
 
-	// Keep connection alive by sending comments or heartbeats
-	go func() {
-		ticker := time.NewTicker(30 * time.Second) // Send a heartbeat every 30 seconds
+		ticker := time.NewTicker(sm.Heartbeat)
 		defer ticker.Stop()
 		for {
 			select {
-			case msg := <-clientChan:
-				// Write the message to the client
-				fmt.Fprintf(c.Writer, "data: %s\n\n", msg)
-				flusher.Flush()
+			case <-client.notify:
+				for _, event := range client.drain() {
+					if err := stream.Send(event); err != nil {
+						return err
+					}
+				}
 			case <-ticker.C:
-				// Send a heartbeat comment
-				fmt.Fprintf(c.Writer, ": heartbeat\n\n")
-				flusher.Flush()
-			case <-c.Request.Context().Done():
-				// Client disconnected
-				sm.RemoveClient(docID, clientID)
-				sm.Logger.Info("SSE: Client %s disconnected from document %s", clientID, docID)
-				return
+				if err := stream.Comment("heartbeat"); err != nil {
+					return err
+				}
+			case <-client.done:
+				return nil
+			case <-stream.Done():
+				return nil
 			}
 		}
-	}()
+	})
 }
 
-// RemoveClient removes an SSE client connection.
-func (sm *SSEManager) RemoveClient(docID, clientID string) {
+// Subscribe registers a new client for a topic and returns it, together with the events to
+// replay after lastEventID (a single "resync" event if they are no longer buffered).
+// Callers must Unsubscribe the client when done; Serve does both.
+func (sm *SSEManager) Subscribe(topic, userID, lastEventID string) (*SSEClient, []SSEEvent, error) {
+	client := &SSEClient{
+		ID:     strconv.FormatUint(sm.nextID.Add(1), 10),
+		Topic:  topic,
+		UserID: userID,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	// Collect missed events under the same lock that registers the client, so none are lost or doubled
 	sm.mu.Lock()
-	defer sm.mu.Unlock()
+	defer sm.unlock()
+	if err := sm.subscribeTopicLocked(topic); err != nil {
+		return nil, nil, err
+	}
+	var missed []SSEEvent
+	if lastEventID != "" {
+		var found bool
+		if buffer, ok := sm.replay[topic]; ok {
+			missed, found = buffer.since(lastEventID)
+		}
+		if !found {
+			missed = []SSEEvent{{Event: SSEResyncEvent}}
+		}
+		sm.Logger.Info("SSE: Client %s resumed topic %s after event %s (%d events to replay)", client.ID, topic, lastEventID, len(missed))
+	}
+	if _, ok := sm.clients[topic]; !ok {
+		sm.clients[topic] = make(map[string]*SSEClient)
+	}
+	sm.clients[topic][client.ID] = client
+	sm.Logger.Info("SSE: Client %s (user %s) connected to topic %s", client.ID, userID, topic)
+	return client, missed, nil
+}
+
+// subscribeTopicLocked makes sure the manager receives the topic's events from the broker.
+// The broker may block on the network, so sm.mu is released while subscribing; it is held again
+// when this returns.
+func (sm *SSEManager) subscribeTopicLocked(topic string) error {
+	for {
+		if timer, ok := sm.lingering[topic]; ok {
+			timer.Stop()
+			delete(sm.lingering, topic)
+		}
+		if _, ok := sm.brokerSubs[topic]; ok {
+			return nil
+		}
+		wait, inProgress := sm.subscribing[topic]
+		if !inProgress {
+			break
+		}
+		sm.mu.Unlock() // Another client is subscribing the topic; check again once it is done
+		<-wait
+		sm.mu.Lock()
+	}
+
+	done := make(chan struct{})
+	sm.subscribing[topic] = done
+	sm.mu.Unlock()
+	sub, err := sm.broker.Subscribe(sseBrokerPrefix+topic, sm.receive)
+	sm.mu.Lock()
+	delete(sm.subscribing, topic)
+	close(done)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to topic %s: %w", topic, err)
+	}
+	sm.brokerSubs[topic] = sub
+	return nil
+}
 
-	if docClients, ok := sm.clients[docID]; ok {
-		if client, ok := docClients[clientID]; ok {
-			close(client.Chan) // Close the client's channel
-			delete(docClients, clientID)
-			if len(docClients) == 0 {
-				delete(sm.clients, docID) // Clean up document entry if no more clients
+// topicChangedLocked cleans up after clients left a topic. Once the last one is gone, the topic
+// lingers for TopicLinger, so clients reconnecting shortly can still resume, then it is released.
+func (sm *SSEManager) topicChangedLocked(topic string) {
+	if len(sm.clients[topic]) > 0 {
+		return
+	}
+	delete(sm.clients, topic) // Clean up topic entry if no more clients
+	if _, ok := sm.brokerSubs[topic]; !ok || sm.lingering[topic] != nil {
+		return
+	}
+	if sm.TopicLinger <= 0 {
+		sm.releaseTopicLocked(topic)
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(sm.TopicLinger, func() {
+		sm.mu.Lock()
+		defer sm.unlock()
+		if sm.lingering[topic] == timer { // Not stopped and replaced by a new subscription meanwhile
+			sm.releaseTopicLocked(topic)
+		}
+	})
+	sm.lingering[topic] = timer
+}
+
+// releaseTopicLocked stops handling the topic's broker messages and drops its replay buffer.
+// The broker subscription itself is ended by unlock, outside the lock.
+func (sm *SSEManager) releaseTopicLocked(topic string) {
+	if timer, ok := sm.lingering[topic]; ok {
+		timer.Stop()
+		delete(sm.lingering, topic)
+	}
+	if sub, ok := sm.brokerSubs[topic]; ok {
+		sm.releasedSubs = append(sm.releasedSubs, sub)
+		delete(sm.brokerSubs, topic)
+	}
+	delete(sm.replay, topic)
+}
+
+// unlock releases sm.mu, then unsubscribes from the topics released meanwhile. Broker calls
+// may block on the network, so they never run under the lock.
+func (sm *SSEManager) unlock() {
+	released := sm.releasedSubs
+	sm.releasedSubs = nil
+	sm.mu.Unlock()
+	for _, sub := range released {
+		if err := sm.broker.Unsubscribe(sub); err != nil {
+			sm.Logger.Error("SSE: Failed to unsubscribe from topic %s: %v", strings.TrimPrefix(sub.Topic, sseBrokerPrefix), err)
+		}
+	}
+}
+
+// Unsubscribe removes a client. It is safe to call more than once.
+func (sm *SSEManager) Unsubscribe(client *SSEClient) {
+	sm.mu.Lock()
+	defer sm.unlock()
+	if topicClients, ok := sm.clients[client.Topic]; ok {
+		if _, ok := topicClients[client.ID]; ok {
+			delete(topicClients, client.ID)
+			sm.topicChangedLocked(client.Topic)
+			if dropped := client.Dropped(); dropped > 0 {
+				sm.Logger.Warning("SSE: Client %s disconnected from topic %s after %d dropped events", client.ID, client.Topic, dropped)
+			} else {
+				sm.Logger.Info("SSE: Client %s disconnected from topic %s", client.ID, client.Topic)
 			}
 		}
 	}
+	client.disconnect()
 }
 
-// Broadcast sends a message to all clients subscribed to a specific document.
-func (sm *SSEManager) Broadcast(docID string, message string) {
+// ClientCount returns the number of clients subscribed to a topic.
+func (sm *SSEManager) ClientCount(topic string) int {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
+	return len(sm.clients[topic])
+}
 
-	if docClients, ok := sm.clients[docID]; ok {
-		for _, client := range docClients {
-			select {
-			case client.Chan <- message:
-				// Message sent successfully
-			default:
-				// Client channel is blocked, maybe it's slow or disconnected.
-				// Log and consider removing the client if this happens often.
-				sm.Logger.Warning("SSE: Failed to send message to client %s for document %s (channel full)", client.ID, docID)
-			}
+// DisconnectUser ends the streams of a user on a topic, on every instance sharing the broker,
+// e.g. after their access was revoked.
+func (sm *SSEManager) DisconnectUser(topic, userID string) {
+	sm.mu.Lock()
+	sm.disconnectUserLocked(topic, userID) // Right away here, even if publishing fails
+	sm.unlock()
+	sm.publish(topic, sseBrokerMessage{DisconnectUser: userID})
+}
+
+// disconnectUserLocked ends the local streams of a user on a topic.
+func (sm *SSEManager) disconnectUserLocked(topic, userID string) {
+	for id, client := range sm.clients[topic] {
+		if client.UserID == userID {
+			delete(sm.clients[topic], id)
+			client.disconnect()
 		}
 	}
+	sm.topicChangedLocked(topic)
+}
+
+// CloseTopic ends all streams of a topic on every instance sharing the broker, and drops its
+// replay buffer and policy, e.g. once its document has been deleted. Clients reconnecting
+// afterwards get a "resync" event.
+func (sm *SSEManager) CloseTopic(topic string) {
+	sm.mu.Lock()
+	sm.closeTopicLocked(topic)
+	sm.unlock()
+	sm.publish(topic, sseBrokerMessage{Close: true})
+}
+
+// closeTopicLocked ends the local streams of a topic and forgets it.
+func (sm *SSEManager) closeTopicLocked(topic string) {
+	for _, client := range sm.clients[topic] {
+		client.disconnect()
+	}
+	delete(sm.clients, topic)
+	delete(sm.policies, topic)
+	sm.releaseTopicLocked(topic)
+}
+
+// Broadcast sends a message as an unnamed ("message") event to all clients subscribed to a topic.
+func (sm *SSEManager) Broadcast(topic string, message string) {
+	sm.BroadcastEvent(topic, SSEEvent{Data: message})
+}
+
+// BroadcastEvent sends an event to all clients subscribed to a topic, on every instance sharing
+// the broker, and stores it for replay. Events without an ID get a unique one.
+func (sm *SSEManager) BroadcastEvent(topic string, event SSEEvent) {
+	if event.ID == "" {
+		event.ID = sm.epoch + "-" + strconv.FormatUint(sm.nextEventID.Add(1), 10)
+	}
+	sm.publish(topic, sseBrokerMessage{Event: &event})
+}
+
+// BroadcastLocal sends an event only to the clients connected to this instance, without an ID
+// and without storing it for replay. It is meant for state every instance tracks on its own,
+// such as Presence, where going through the broker would deliver the event once per instance.
+func (sm *SSEManager) BroadcastLocal(topic string, event SSEEvent) {
+	sm.mu.Lock()
+	defer sm.unlock()
+	sm.queueLocked(topic, event)
+}
+
+// publish sends a message to the managers subscribed to the topic, including this one.
+func (sm *SSEManager) publish(topic string, msg sseBrokerMessage) {
+	payload, err := json.Marshal(msg)
+	if err == nil {
+		err = sm.broker.Publish(sseBrokerPrefix+topic, payload)
+	}
+	if err != nil {
+		sm.Logger.Error("SSE: Failed to publish to topic %s: %v", topic, err)
+	}
+}
+
+// receive handles a message from the broker.
+func (sm *SSEManager) receive(brokerTopic string, payload []byte) {
+	topic := strings.TrimPrefix(brokerTopic, sseBrokerPrefix)
+	var msg sseBrokerMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		sm.Logger.Error("SSE: Ignoring malformed broker message on topic %s: %v", topic, err)
+		return
+	}
+
+	sm.mu.Lock()
+	defer sm.unlock()
+	_, subscribed := sm.brokerSubs[topic]
+	if _, subscribing := sm.subscribing[topic]; !subscribed && !subscribing {
+		return // Released since the broker dispatched the message
+	}
+	switch {
+	case msg.Event != nil:
+		sm.deliverLocked(topic, *msg.Event)
+	case msg.Close:
+		sm.closeTopicLocked(topic)
+	case msg.DisconnectUser != "":
+		sm.disconnectUserLocked(topic, msg.DisconnectUser)
+	}
+}
+
+// deliverLocked stores an event for replay and queues it for the topic's local clients.
+func (sm *SSEManager) deliverLocked(topic string, event SSEEvent) {
+	// Queueing happens under the lock that Unsubscribe takes, so a client is never sent to after it is gone
+	if sm.ReplaySize > 0 {
+		buffer, ok := sm.replay[topic]
+		if !ok {
+			buffer = &sseReplayBuffer{events: make([]SSEEvent, sm.ReplaySize)}
+			sm.replay[topic] = buffer
+		}
+		buffer.add(event)
+	}
+	sm.queueLocked(topic, event)
+}
+
+// queueLocked queues an event for the topic's local clients, applying the topic's policy.
+func (sm *SSEManager) queueLocked(topic string, event SSEEvent) {
+	policy := sm.policyLocked(topic)
+	for id, client := range sm.clients[topic] {
+		before := client.Dropped()
+		dropped, disconnect := client.enqueue(event, policy, sm.QueueSize)
+		if !dropped {
+			continue
+		}
+		sm.totalDropped.Add(client.Dropped() - before)
+		if disconnect {
+			delete(sm.clients[topic], id)
+			client.disconnect()
+			sm.slowDisconnects.Add(1)
+			sm.Logger.Warning("SSE: Disconnected slow client %s from topic %s (%d events behind)", id, topic, sm.QueueSize)
+		} else if before == 0 && policy != SSECoalesceLatest { // Warn once per client, not for every event
+			sm.Logger.Warning("SSE: Client %s on topic %s cannot keep up; dropping events (%s)", id, topic, policy)
+		}
+	}
+	sm.topicChangedLocked(topic)
+}
+
+// Stats returns per-client queue and drop counts and overall totals.
+func (sm *SSEManager) Stats() SSEStats {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	stats := SSEStats{
+		Clients:         []SSEClientStats{},
+		TotalDropped:    sm.totalDropped.Load(),
+		SlowDisconnects: sm.slowDisconnects.Load(),
+	}
+	for topic, clients := range sm.clients {
+		policy := sm.policyLocked(topic)
+		for _, client := range clients {
+			client.mu.Lock()
+			stats.Clients = append(stats.Clients, SSEClientStats{
+				ID:      client.ID,
+				Topic:   topic,
+				UserID:  client.UserID,
+				Policy:  policy,
+				Queued:  len(client.queue),
+				Dropped: client.dropped,
+			})
+			client.mu.Unlock()
+		}
+	}
+	sort.Slice(stats.Clients, func(i, j int) bool { // Numeric IDs in connection order
+		a, b := stats.Clients[i].ID, stats.Clients[j].ID
+		return len(a) < len(b) || len(a) == len(b) && a < b
+	})
+	return stats
+}
+
+// StatsHandler serves Stats as JSON, e.g. on a debug route.
+func (sm *SSEManager) StatsHandler(c *Context) error {
+	return c.JSON(http.StatusOK, sm.Stats())
 }
@@ -0,0 +1,400 @@
+// go-swift/goswift/sse_test.go
+package goswift
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sseData returns the Data of events, with resync events as "<resync>".
+func sseData(events []SSEEvent) []string {
+	data := make([]string, 0, len(events))
+	for _, e := range events {
+		if e.Event == SSEResyncEvent {
+			data = append(data, "<resync>")
+		} else {
+			data = append(data, e.Data)
+		}
+	}
+	return data
+}
+
+// testSSEManager returns a manager with a small queue. The MemoryBroker delivers synchronously,
+// so every broadcast is queued by the time BroadcastEvent returns.
+func testSSEManager(queueSize int) *SSEManager {
+	sm := NewSSEManager(NewLogger())
+	sm.QueueSize = queueSize
+	return sm
+}
+
+// A client that never reads is handled according to the topic's policy.
+func TestSSEBackpressurePolicies(t *testing.T) {
+	tests := []struct {
+		policy      SSEPolicy
+		events      []SSEEvent
+		want        []string
+		wantDropped uint64
+	}{
+		{
+			policy:      SSEDropOldest,
+			events:      []SSEEvent{{Data: "1"}, {Data: "2"}, {Data: "3"}, {Data: "4"}, {Data: "5"}},
+			want:        []string{"3", "4", "5", "<resync>"},
+			wantDropped: 2,
+		},
+		{
+			policy:      SSEDropNewest,
+			events:      []SSEEvent{{Data: "1"}, {Data: "2"}, {Data: "3"}, {Data: "4"}, {Data: "5"}},
+			want:        []string{"1", "2", "3", "<resync>"},
+			wantDropped: 2,
+		},
+		{
+			policy:      SSECoalesceLatest,
+			events:      []SSEEvent{{Event: "doc", Data: "1"}, {Event: "doc", Data: "2"}, {Event: "doc", Data: "3"}, {Event: "doc", Data: "4"}, {Event: "doc", Data: "5"}},
+			want:        []string{"5"}, // Only the latest state, and no resync: nothing was lost
+			wantDropped: 4,
+		},
+		{
+			policy:      SSECoalesceLatest,
+			events:      []SSEEvent{{Event: "doc", Data: "d1"}, {Event: "presence", Data: "p1"}, {Event: "doc", Data: "d2"}, {Event: "presence", Data: "p2"}},
+			want:        []string{"d2", "p2"},
+			wantDropped: 2,
+		},
+		{
+			policy:      SSECoalesceLatest, // More event types than fit fall back to dropping the oldest
+			events:      []SSEEvent{{Event: "a", Data: "a"}, {Event: "b", Data: "b"}, {Event: "c", Data: "c"}, {Event: "d", Data: "d"}},
+			want:        []string{"b", "c", "d", "<resync>"},
+			wantDropped: 1,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.policy, i), func(t *testing.T) {
+			sm := testSSEManager(3)
+			sm.SetPolicy("t", tt.policy)
+			client, _, err := sm.Subscribe("t", "u", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sm.Unsubscribe(client)
+
+			for _, e := range tt.events {
+				sm.BroadcastEvent("t", e)
+			}
+			select {
+			case <-client.notify:
+			default:
+				t.Fatal("client was not notified")
+			}
+			if got := sseData(client.drain()); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if got := client.Dropped(); got != tt.wantDropped {
+				t.Fatalf("Dropped() = %d, want %d", got, tt.wantDropped)
+			}
+			if got := sm.Stats().TotalDropped; got != tt.wantDropped {
+				t.Fatalf("Stats().TotalDropped = %d, want %d", got, tt.wantDropped)
+			}
+			if got := client.drain(); len(got) != 0 {
+				t.Fatalf("second drain returned %v, want nothing", sseData(got))
+			}
+		})
+	}
+}
+
+// SSEDisconnectSlow disconnects the slow client only; the fast one gets every event.
+func TestSSEDisconnectSlow(t *testing.T) {
+	sm := testSSEManager(2)
+	sm.DefaultPolicy = SSEDisconnectSlow
+	slow, _, err := sm.Subscribe("t", "slow", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sm.Unsubscribe(slow)
+	fast, _, err := sm.Subscribe("t", "fast", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sm.Unsubscribe(fast)
+
+	var received []string
+	for i := 1; i <= 4; i++ {
+		sm.BroadcastEvent("t", SSEEvent{Data: fmt.Sprint(i)})
+		received = append(received, sseData(fast.drain())...)
+	}
+
+	select {
+	case <-slow.done:
+	default:
+		t.Fatal("slow client was not disconnected")
+	}
+	select {
+	case <-fast.done:
+		t.Fatal("fast client was disconnected")
+	default:
+	}
+	if got := strings.Join(received, ","); got != "1,2,3,4" {
+		t.Fatalf("fast client got %s, want 1,2,3,4", got)
+	}
+	if got := sm.ClientCount("t"); got != 1 {
+		t.Fatalf("ClientCount = %d, want 1", got)
+	}
+	stats := sm.Stats()
+	if stats.SlowDisconnects != 1 || stats.TotalDropped != 3 {
+		t.Fatalf("stats = %+v, want 1 slow disconnect and 3 dropped", stats)
+	}
+}
+
+// A client subscribing with a Last-Event-ID gets what it missed, or a resync when that is gone.
+func TestSSEReplay(t *testing.T) {
+	sm := testSSEManager(16)
+	sm.ReplaySize = 3
+	keep, _, err := sm.Subscribe("t", "", "") // Keeps the topic, and its replay buffer, alive
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sm.Unsubscribe(keep)
+
+	for i := 1; i <= 4; i++ {
+		sm.BroadcastEvent("t", SSEEvent{ID: fmt.Sprint(i), Data: fmt.Sprint(i)})
+	}
+
+	tests := []struct {
+		lastEventID string
+		want        []string
+	}{
+		{"", nil},
+		{"2", []string{"3", "4"}},
+		{"4", []string{}},
+		{"1", []string{"<resync>"}},       // Overwritten in the buffer
+		{"unknown", []string{"<resync>"}}, // Never sent, e.g. from before a restart
+	}
+	for _, tt := range tests {
+		client, missed, err := sm.Subscribe("t", "", tt.lastEventID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sm.Unsubscribe(client)
+		if got := sseData(missed); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("Last-Event-ID %q: got %v, want %v", tt.lastEventID, got, tt.want)
+		}
+	}
+}
+
+// readSSEEvents reads n events from an event stream, skipping comments.
+func readSSEEvents(t *testing.T, r *bufio.Reader, n int) []SSEEvent {
+	t.Helper()
+	var events []SSEEvent
+	var e SSEEvent
+	for len(events) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream after %d events: %v", len(events), err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			events = append(events, e)
+			e = SSEEvent{}
+		case strings.HasPrefix(line, "id: "):
+			e.ID = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.Data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	return events
+}
+
+// waitForClients waits until a topic has n clients.
+func waitForClients(t *testing.T, sm *SSEManager, topic string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for sm.ClientCount(topic) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("ClientCount(%q) = %d, want %d", topic, sm.ClientCount(topic), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Serve streams concurrent broadcasts in order to several clients and resumes after a reconnect.
+func TestSSEServeStream(t *testing.T) {
+	sm := testSSEManager(1024)
+	app := New()
+	app.GET("/events", func(c *Context) error {
+		return sm.Serve(c, "t")
+	}).Handler()
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	connect := func(lastEventID string) (*http.Response, *bufio.Reader) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+			t.Fatalf("Content-Type = %q", ct)
+		}
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	const clients, perPublisher = 3, 20
+	var readers []*bufio.Reader
+	for i := 0; i < clients; i++ {
+		resp, r := connect("")
+		defer resp.Body.Close()
+		readers = append(readers, r)
+	}
+	waitForClients(t, sm, "t", clients)
+
+	var wg sync.WaitGroup
+	for p := 0; p < 2; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perPublisher; i++ {
+				sm.BroadcastEvent("t", SSEEvent{Data: fmt.Sprintf("%d-%d", p, i)})
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	var first []SSEEvent
+	for i, r := range readers {
+		events := readSSEEvents(t, r, 2*perPublisher)
+		next := map[string]int{}
+		for _, e := range events {
+			var p, n int
+			fmt.Sscanf(e.Data, "%d-%d", &p, &n)
+			if n != next[fmt.Sprint(p)] {
+				t.Fatalf("client %d: publisher %d events out of order at %q", i, p, e.Data)
+			}
+			next[fmt.Sprint(p)]++
+		}
+		if i == 0 {
+			first = events
+		} else if fmt.Sprint(sseData(events)) != fmt.Sprint(sseData(first)) {
+			t.Fatalf("client %d saw a different order than client 0", i)
+		}
+	}
+
+	// Reconnecting from the tenth event replays the rest
+	resp, r := connect(first[9].ID)
+	defer resp.Body.Close()
+	if got := readSSEEvents(t, r, len(first)-10); fmt.Sprint(sseData(got)) != fmt.Sprint(sseData(first[10:])) {
+		t.Fatalf("replay got %v, want %v", sseData(got), sseData(first[10:]))
+	}
+}
+
+// gatedBroker is a MemoryBroker whose Subscribe and Unsubscribe calls for one topic block until
+// the gate is opened, like a NetBroker waiting on a slow connection.
+type gatedBroker struct {
+	*MemoryBroker
+	topic   string
+	gate    chan struct{}
+	entered chan string // Receives "sub" or "unsub" as a call starts waiting
+	subs    atomic.Int32
+}
+
+func (b *gatedBroker) Subscribe(topic string, handler BrokerHandler) (BrokerSubscription, error) {
+	if topic == b.topic {
+		b.subs.Add(1)
+		b.entered <- "sub"
+		<-b.gate
+	}
+	return b.MemoryBroker.Subscribe(topic, handler)
+}
+
+func (b *gatedBroker) Unsubscribe(sub BrokerSubscription) error {
+	if sub.Topic == b.topic {
+		b.entered <- "unsub"
+		<-b.gate
+	}
+	return b.MemoryBroker.Unsubscribe(sub)
+}
+
+// A broker call that blocks does not hold up the manager: other topics keep working meanwhile,
+// and clients subscribing the same topic share the one subscription.
+func TestSSEBrokerCallsOutsideLock(t *testing.T) {
+	broker := &gatedBroker{MemoryBroker: NewMemoryBroker(), topic: sseBrokerPrefix + "slow", gate: make(chan struct{}), entered: make(chan string, 4)}
+	sm := NewSSEManagerWithBroker(NewLogger(), broker)
+	sm.TopicLinger = 0
+
+	other, _, err := sm.Subscribe("other", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// withinTimeout fails the test if fn does not return promptly while the broker is stuck
+	withinTimeout := func(what string, fn func()) {
+		t.Helper()
+		done := make(chan struct{})
+		go func() {
+			fn()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s blocked on the broker call of another topic", what)
+		}
+	}
+	otherStillWorks := func() {
+		t.Helper()
+		withinTimeout("BroadcastEvent", func() { sm.BroadcastEvent("other", SSEEvent{Data: "x"}) })
+		withinTimeout("Stats", func() { sm.Stats() })
+		if events := other.drain(); len(events) != 1 || events[0].Data != "x" {
+			t.Fatalf("other topic got %v, want one event", sseData(events))
+		}
+	}
+
+	slow := make(chan *SSEClient, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			client, _, err := sm.Subscribe("slow", "", "")
+			if err != nil {
+				t.Error(err)
+			}
+			slow <- client
+		}()
+	}
+	if op := <-broker.entered; op != "sub" {
+		t.Fatalf("broker got %s, want sub", op)
+	}
+	otherStillWorks()
+	close(broker.gate)
+	clients := []*SSEClient{<-slow, <-slow}
+	if n := broker.subs.Load(); n != 1 {
+		t.Fatalf("broker subscribed %d times, want once", n)
+	}
+	sm.BroadcastEvent("slow", SSEEvent{Data: "y"})
+	for _, client := range clients {
+		if events := client.drain(); len(events) != 1 || events[0].Data != "y" {
+			t.Fatalf("slow topic client got %v, want one event", sseData(events))
+		}
+	}
+
+	broker.gate = make(chan struct{})
+	sm.Unsubscribe(clients[0])
+	released := make(chan struct{})
+	go func() {
+		sm.Unsubscribe(clients[1]) // The last client; releases the topic
+		close(released)
+	}()
+	if op := <-broker.entered; op != "unsub" {
+		t.Fatalf("broker got %s, want unsub", op)
+	}
+	otherStillWorks()
+	close(broker.gate)
+	<-released
+}
@@ -0,0 +1,213 @@
+// go-swift/goswift/totp.go
+package goswift
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MFAPendingPurpose is the purpose of the token issued between the password and second-factor
+// steps of a login (see JWTService.GeneratePurposeToken).
+const MFAPendingPurpose = "mfa_pending"
+
+// MFAPendingTTL is how long a user has to enter the second factor after the password.
+const MFAPendingTTL = 5 * time.Minute
+
+// totpEncoding is the unpadded base32 alphabet authenticator apps expect for secrets.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTP generates and verifies time-based one-time passwords (RFC 6238). Zero values of the
+// fields fall back to the defaults noted on each.
+type TOTP struct {
+	secret    []byte
+	Digits    int           // Code length, 6 by default
+	Period    time.Duration // Time step, 30s by default; values below 1s also mean 30s
+	Skew      int           // Steps accepted before and after the current one, 1 by default
+	Algorithm string        // "SHA1" (the default, and the only one all authenticator apps support), "SHA256" or "SHA512"
+}
+
+// GenerateTOTPSecret returns a new random 160-bit secret, base32-encoded.
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20) // The key length recommended by RFC 4226
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// NewTOTP creates a TOTP from a base32-encoded secret with the default parameters.
+// Spaces and lowercase letters, as users tend to type them, are accepted.
+func NewTOTP(secret string) (*TOTP, error) {
+	normalized := strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := totpEncoding.DecodeString(strings.TrimRight(normalized, "="))
+	if err != nil || len(key) == 0 {
+		return nil, errors.New("invalid TOTP secret")
+	}
+	return &TOTP{secret: key, Digits: 6, Period: 30 * time.Second, Skew: 1}, nil
+}
+
+// Secret returns the base32-encoded secret.
+func (t *TOTP) Secret() string {
+	return totpEncoding.EncodeToString(t.secret)
+}
+
+// Counter returns the time step for at.
+func (t *TOTP) Counter(at time.Time) int64 {
+	return at.Unix() / int64(t.period()/time.Second)
+}
+
+// period returns the time step, falling back to 30s for values below a second.
+func (t *TOTP) period() time.Duration {
+	if t.Period < time.Second {
+		return 30 * time.Second
+	}
+	return t.Period
+}
+
+// digits returns the code length, falling back to 6.
+func (t *TOTP) digits() int {
+	if t.Digits <= 0 {
+		return 6
+	}
+	return t.Digits
+}
+
+// algorithm returns the normalized HMAC hash name and constructor, falling back to SHA1.
+func (t *TOTP) algorithm() (string, func() hash.Hash) {
+	switch strings.ToUpper(t.Algorithm) {
+	case "SHA256":
+		return "SHA256", sha256.New
+	case "SHA512":
+		return "SHA512", sha512.New
+	}
+	return "SHA1", sha1.New
+}
+
+// Code returns the code for the time step containing at.
+func (t *TOTP) Code(at time.Time) string {
+	return t.hotp(t.Counter(at))
+}
+
+// hotp computes the HOTP value (RFC 4226 section 5.3) for a counter.
+func (t *TOTP) hotp(counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	_, newHash := t.algorithm()
+	mac := hmac.New(newHash, t.secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f // Dynamic truncation
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	digits := t.digits()
+	mod := uint64(1)
+	for i := 0; i < digits && i < 10; i++ { // value has at most 10 digits
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, uint64(value)%mod)
+}
+
+// Verify checks code against the time steps around at, allowing for Skew steps of clock drift.
+// It returns the matched time step, which callers should store and pass as lastCounter next
+// time: codes for steps at or before lastCounter are rejected so a code cannot be replayed.
+// Pass -1 if no code has been used yet.
+func (t *TOTP) Verify(code string, at time.Time, lastCounter int64) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != t.digits() {
+		return 0, false
+	}
+	current := t.Counter(at)
+	matched, ok := int64(0), false
+	for step := current - int64(t.Skew); step <= current+int64(t.Skew); step++ {
+		// Check every step so the time taken does not reveal which one matched
+		if subtle.ConstantTimeCompare([]byte(t.hotp(step)), []byte(code)) == 1 && step > lastCounter {
+			matched, ok = step, true
+		}
+	}
+	return matched, ok
+}
+
+// URI returns the otpauth:// provisioning URI for authenticator apps, usually shown as a QR code.
+func (t *TOTP) URI(issuer, account string) string {
+	algorithm, _ := t.algorithm()
+	query := url.Values{
+		"secret":    {t.Secret()},
+		"issuer":    {issuer},
+		"algorithm": {algorithm},
+		"digits":    {fmt.Sprint(t.digits())},
+		"period":    {fmt.Sprint(int(t.period() / time.Second))},
+	}
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// recoveryCodeEncoding spells recovery codes in lowercase base32, avoiding 0/1 lookalikes.
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// GenerateRecoveryCodes returns n one-time recovery codes (formatted as "xxxxx-xxxxx") to show
+// to the user once, and their HashPassword hashes to store.
+func GenerateRecoveryCodes(n int) (codes []string, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		raw := recoveryCodeEncoding.EncodeToString(b)[:10]
+		hash, err := HashPassword(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to hash recovery code: %w", err)
+		}
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hash)
+	}
+	return codes, hashes, nil
+}
+
+// UseRecoveryCode checks code against the stored hashes. On a match it returns the hashes
+// without the used one, which the caller must store so the code cannot be used again.
+func UseRecoveryCode(code string, hashes []string) (remaining []string, ok bool) {
+	hash, ok := MatchRecoveryCode(code, hashes)
+	if !ok {
+		return hashes, false
+	}
+	return RemoveRecoveryCode(hashes, hash)
+}
+
+// MatchRecoveryCode returns the stored hash that code matches. Every check costs a password
+// hash, so callers guarding the hashes with a lock should check a copy outside it, then consume
+// the match under the lock with RemoveRecoveryCode.
+func MatchRecoveryCode(code string, hashes []string) (hash string, ok bool) {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(normalized) != 10 {
+		return "", false
+	}
+	for _, hash := range hashes {
+		if CheckPasswordHash(normalized, hash) {
+			return hash, true
+		}
+	}
+	return "", false
+}
+
+// RemoveRecoveryCode returns hashes without hash, in a new slice. It reports false if hash is
+// no longer among them, e.g. because a concurrent login used the same code.
+func RemoveRecoveryCode(hashes []string, hash string) (remaining []string, ok bool) {
+	for i, h := range hashes {
+		if h == hash {
+			return append(append([]string(nil), hashes[:i]...), hashes[i+1:]...), true
+		}
+	}
+	return hashes, false
+}
@@ -0,0 +1,144 @@
+// go-swift/goswift/totp_test.go
+package goswift
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Each recovery code works once, whichever way it is consumed.
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remaining, ok := UseRecoveryCode(codes[1], hashes)
+	if !ok || len(remaining) != 2 || len(hashes) != 3 {
+		t.Fatalf("UseRecoveryCode: ok=%v, %d left, input now %d long", ok, len(remaining), len(hashes))
+	}
+	if _, ok := UseRecoveryCode(codes[1], remaining); ok {
+		t.Fatal("a used code was accepted again")
+	}
+
+	hash, ok := MatchRecoveryCode(codes[0], remaining)
+	if !ok || hash != hashes[0] {
+		t.Fatalf("MatchRecoveryCode = %q, %v; want the first hash", hash, ok)
+	}
+	afterFirst, ok := RemoveRecoveryCode(remaining, hash)
+	if !ok || len(afterFirst) != 1 {
+		t.Fatalf("RemoveRecoveryCode: ok=%v, %d left", ok, len(afterFirst))
+	}
+	if _, ok := RemoveRecoveryCode(afterFirst, hash); ok { // A concurrent login matched the same code
+		t.Fatal("a code was removed twice")
+	}
+
+	if _, ok := MatchRecoveryCode("not-a-code", afterFirst); ok {
+		t.Fatal("an invalid code matched")
+	}
+}
+
+// RFC 6238 Appendix B test vectors, with 8-digit codes and the RFC's per-algorithm seeds.
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	seeds := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	tests := []struct {
+		unix  int64
+		codes map[string]string
+	}{
+		{59, map[string]string{"SHA1": "94287082", "SHA256": "46119246", "SHA512": "90693936"}},
+		{1111111109, map[string]string{"SHA1": "07081804", "SHA256": "68084774", "SHA512": "25091201"}},
+		{1111111111, map[string]string{"SHA1": "14050471", "SHA256": "67062674", "SHA512": "99943326"}},
+		{1234567890, map[string]string{"SHA1": "89005924", "SHA256": "91819424", "SHA512": "93441116"}},
+		{2000000000, map[string]string{"SHA1": "69279037", "SHA256": "90698825", "SHA512": "38618901"}},
+		{20000000000, map[string]string{"SHA1": "65353130", "SHA256": "77737706", "SHA512": "47863826"}},
+	}
+	for algorithm, seed := range seeds {
+		totp, err := NewTOTP(totpEncoding.EncodeToString([]byte(seed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		totp.Digits, totp.Algorithm = 8, algorithm
+		for _, tt := range tests {
+			if got, want := totp.Code(time.Unix(tt.unix, 0)), tt.codes[algorithm]; got != want {
+				t.Errorf("%s at %d: got %s, want %s", algorithm, tt.unix, got, want)
+			}
+		}
+	}
+}
+
+// Periods below a second fall back to 30s instead of dividing by zero.
+func TestTOTPPeriodBelowOneSecond(t *testing.T) {
+	totp, err := NewTOTP(totpEncoding.EncodeToString([]byte("12345678901234567890")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Unix(1111111109, 0)
+	want := totp.Code(at)
+	for _, period := range []time.Duration{0, 500 * time.Millisecond, -time.Second} {
+		totp.Period = period
+		if got := totp.Counter(at); got != 1111111109/30 {
+			t.Errorf("period %v: counter %d, want %d", period, got, 1111111109/30)
+		}
+		if got := totp.Code(at); got != want {
+			t.Errorf("period %v: code %s, want %s", period, got, want)
+		}
+		if uri := totp.URI("QuikDocs", "alice"); !strings.Contains(uri, "period=30") {
+			t.Errorf("period %v: URI %s does not advertise 30s", period, uri)
+		}
+	}
+}
+
+// Codes from Skew steps around the current one are accepted, and a code at or before
+// lastCounter is never accepted again.
+func TestTOTPVerifySkewAndReplay(t *testing.T) {
+	totp, err := NewTOTP(totpEncoding.EncodeToString([]byte("12345678901234567890")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1234567890, 0)
+	current := totp.Counter(now)
+
+	tests := []struct {
+		name        string
+		skew        int
+		step        int64
+		lastCounter int64
+		ok          bool
+	}{
+		{"current step", 1, current, -1, true},
+		{"previous step", 1, current - 1, -1, true},
+		{"next step", 1, current + 1, -1, true},
+		{"two steps back", 1, current - 2, -1, false},
+		{"two steps back with skew 2", 2, current - 2, -1, true},
+		{"previous step without skew", 0, current - 1, -1, false},
+		{"replayed", 1, current, current, false},
+		{"older than the last used", 1, current - 1, current, false},
+		{"newer than the last used", 1, current + 1, current, true},
+	}
+	for _, tt := range tests {
+		totp.Skew = tt.skew
+		matched, ok := totp.Verify(totp.hotp(tt.step), now, tt.lastCounter)
+		if ok != tt.ok || (ok && matched != tt.step) {
+			t.Errorf("%s: got step %d, %v; want step %d, %v", tt.name, matched, ok, tt.step, tt.ok)
+		}
+	}
+
+	// Using a code returns its step, which then rejects the same code
+	totp.Skew = 1
+	code := totp.Code(now)
+	last, ok := totp.Verify(code, now, -1)
+	if !ok {
+		t.Fatal("the current code was rejected")
+	}
+	if _, ok := totp.Verify(code, now.Add(10*time.Second), last); ok {
+		t.Fatal("a code was accepted twice")
+	}
+	if _, ok := totp.Verify("12345", now, -1); ok {
+		t.Fatal("a code of the wrong length was accepted")
+	}
+}
@@ -0,0 +1,545 @@
+// go-swift/goswift/websocket.go
+package goswift
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WebSocketMessageType is the type of a WebSocket data message.
+type WebSocketMessageType int
+
+const (
+	WebSocketText   WebSocketMessageType = 1 // UTF-8 text
+	WebSocketBinary WebSocketMessageType = 2
+)
+
+// WebSocket close codes (RFC 6455, section 7.4.1).
+const (
+	WebSocketCloseNormal             = 1000
+	WebSocketCloseGoingAway          = 1001 // E.g. the server is shutting down
+	WebSocketCloseProtocolError      = 1002
+	WebSocketCloseUnsupportedData    = 1003
+	WebSocketCloseNoStatus           = 1005 // Never sent; reported when the peer's close frame carried no code
+	WebSocketCloseAbnormal           = 1006 // Never sent; reported when the connection dropped without a close frame
+	WebSocketCloseInvalidPayload     = 1007 // E.g. text that is not valid UTF-8
+	WebSocketClosePolicyViolation    = 1008
+	WebSocketCloseMessageTooBig      = 1009
+	WebSocketCloseMandatoryExtension = 1010
+	WebSocketCloseInternalError      = 1011
+	WebSocketCloseServiceRestart     = 1012
+	WebSocketCloseTryAgainLater      = 1013
+)
+
+// websocketGUID is appended to the client's key to compute Sec-WebSocket-Accept.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrWebSocketClosed is returned when using a connection after it was closed locally.
+var ErrWebSocketClosed = errors.New("websocket connection is closed")
+
+// WebSocketCloseError is returned by ReadMessage once the connection is closed: by the peer
+// (with its code and reason), because the peer broke the protocol (with the code sent to it),
+// or because the connection dropped or timed out (WebSocketCloseAbnormal).
+type WebSocketCloseError struct {
+	Code   int
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *WebSocketCloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("websocket closed with code %d: %s", e.Code, e.Reason)
+}
+
+// WebSocketConfig controls WebSocket connections. Zero values fall back to the defaults noted
+// on each field.
+type WebSocketConfig struct {
+	ReadLimit    int64         // Largest message accepted, after decompression; larger ones close the connection with 1009. 1 MiB by default
+	WriteTimeout time.Duration // Bound on each write, 10s by default
+	PingInterval time.Duration // How often the server pings, 30s by default
+	PongTimeout  time.Duration // How long past PingInterval the peer may stay silent before the connection is dropped, 10s by default
+	CloseTimeout time.Duration // How long Close waits for the peer to confirm, 3s by default
+
+	// Browsers send the page's origin with the handshake. Requests from the request's own host,
+	// and requests without an Origin header (not from a browser), are always allowed.
+	AllowedOrigins []string // Further allowed origins, e.g. "https://app.example.com"; "*" allows any
+	Subprotocols   []string // Supported subprotocols, most preferred first
+
+	EnableCompression    bool // Negotiate permessage-deflate when the client offers it
+	CompressionThreshold int  // Messages shorter than this are sent uncompressed, 256 bytes by default
+	FragmentSize         int  // Outgoing messages longer than this are split into several frames; 0 sends each in one frame
+}
+
+// withDefaults fills in the zero fields.
+func (cfg WebSocketConfig) withDefaults() WebSocketConfig {
+	if cfg.ReadLimit <= 0 {
+		cfg.ReadLimit = 1 << 20
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = 30 * time.Second
+	}
+	if cfg.PongTimeout <= 0 {
+		cfg.PongTimeout = 10 * time.Second
+	}
+	if cfg.CloseTimeout <= 0 {
+		cfg.CloseTimeout = 3 * time.Second
+	}
+	if cfg.CompressionThreshold <= 0 {
+		cfg.CompressionThreshold = 256
+	}
+	return cfg
+}
+
+// originAllowed reports whether the handshake's Origin may open a connection, guarding
+// against cross-site WebSocket hijacking with the user's cookies.
+func (cfg WebSocketConfig) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Upgrade completes a WebSocket handshake using the engine's WebSocket configuration and
+// returns the connection. Route middleware such as authentication runs on the handshake
+// request as usual. Before the switch, failures are returned as HTTPErrors, so handlers
+// simply return them:
+//
+//	app.GET("/ws", func(c *goswift.Context) error {
+//		ws, err := c.Upgrade()
+//		if err != nil {
+//			return err
+//		}
+//		defer ws.Close(goswift.WebSocketCloseNormal, "")
+//		for {
+//			typ, msg, err := ws.ReadMessage()
+//			if err != nil {
+//				return nil // Closed by the peer, the server or the network
+//			}
+//			if err := ws.WriteMessage(typ, msg); err != nil {
+//				return nil
+//			}
+//		}
+//	})
+func (c *Context) Upgrade() (*WebSocketConn, error) {
+	var config WebSocketConfig
+	if c.engine != nil {
+		config = c.engine.WebSocket
+	}
+	return c.UpgradeWith(config)
+}
+
+// UpgradeWith is Upgrade with a configuration of its own, e.g. a larger ReadLimit for one route.
+func (c *Context) UpgradeWith(config WebSocketConfig) (*WebSocketConn, error) {
+	return c.upgrade(config, true)
+}
+
+// upgrade performs the handshake. Unless closeOnShutdown is set, the caller closes the connection
+// when the server shuts down (Hub does, after draining its queue).
+func (c *Context) upgrade(config WebSocketConfig, closeOnShutdown bool) (*WebSocketConn, error) {
+	config = config.withDefaults()
+	r := c.Request
+	if r.Method != http.MethodGet {
+		return nil, NewHTTPError(http.StatusMethodNotAllowed, "WebSocket handshakes must use GET")
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		c.Writer.Header().Set("Upgrade", "websocket")
+		return nil, NewHTTPError(http.StatusUpgradeRequired, "Expected a WebSocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		c.Writer.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, NewHTTPError(http.StatusUpgradeRequired, "Unsupported WebSocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, NewHTTPError(http.StatusBadRequest, "Invalid Sec-WebSocket-Key")
+	}
+	if !config.originAllowed(r) {
+		c.engine.Logger.Warning("WebSocket: Rejected handshake for %s from origin %s (%s)", r.URL.Path, r.Header.Get("Origin"), c.RealIP())
+		return nil, NewHTTPError(http.StatusForbidden, "Origin not allowed")
+	}
+
+	// Headers set by middleware (e.g. X-Request-ID) are kept in the 101 response
+	header := c.Writer.Header().Clone()
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", websocketAccept(key))
+	if subprotocol := selectSubprotocol(r.Header, config.Subprotocols); subprotocol != "" {
+		header.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	compress := false
+	if config.EnableCompression && negotiateDeflate(r.Header) {
+		compress = true
+		header.Set("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	}
+
+	conn, brw, err := http.NewResponseController(c.Writer).Hijack()
+	if err != nil {
+		return nil, NewHTTPError(http.StatusInternalServerError, "WebSocket upgrade is not supported on this connection", err)
+	}
+	c.Writer.status = http.StatusSwitchingProtocols
+	c.Writer.hijacked = true
+	conn.SetDeadline(time.Time{}) // Drop the server's read and write timeouts
+
+	var response bytes.Buffer
+	response.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	header.Write(&response)
+	response.WriteString("\r\n")
+	conn.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+	if _, err := conn.Write(response.Bytes()); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to complete WebSocket handshake: %w", err)
+	}
+	conn.SetWriteDeadline(time.Time{})
+
+	ws := newWebSocketConn(conn, brw.Reader, config, header.Get("Sec-WebSocket-Protocol"), compress)
+	var shutdown <-chan struct{}
+	if closeOnShutdown && c.engine != nil && c.engine.shutdownCtx != nil {
+		shutdown = c.engine.shutdownCtx.Done()
+	}
+	go ws.keepAlive(shutdown)
+	return ws, nil
+}
+
+// websocketAccept computes the Sec-WebSocket-Accept value for a client key.
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHasToken reports whether a comma-separated header contains token, ignoring case.
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectSubprotocol picks the most preferred supported subprotocol the client offered.
+func selectSubprotocol(header http.Header, supported []string) string {
+	for _, protocol := range supported {
+		if headerHasToken(header, "Sec-WebSocket-Protocol", protocol) {
+			return protocol
+		}
+	}
+	return ""
+}
+
+// negotiateDeflate reports whether the client offered permessage-deflate (RFC 7692) in a form the
+// server accepts. The server always answers with both no_context_takeover parameters, so every
+// message is compressed on its own, and only accepts a 15-bit (the default) server window.
+func negotiateDeflate(header http.Header) bool {
+	for _, value := range header.Values("Sec-WebSocket-Extensions") {
+	offers:
+		for _, offer := range strings.Split(value, ",") {
+			params := strings.Split(offer, ";")
+			if !strings.EqualFold(strings.TrimSpace(params[0]), "permessage-deflate") {
+				continue
+			}
+			seen := make(map[string]bool)
+			for _, param := range params[1:] {
+				name, arg, _ := strings.Cut(strings.TrimSpace(param), "=")
+				name = strings.ToLower(strings.TrimSpace(name))
+				arg = strings.Trim(strings.TrimSpace(arg), `"`)
+				if seen[name] {
+					continue offers // Duplicate parameters make the offer invalid
+				}
+				seen[name] = true
+				switch name {
+				case "server_no_context_takeover", "client_no_context_takeover":
+				case "client_max_window_bits": // The client may use any window; inflating handles all
+				case "server_max_window_bits":
+					if arg != "15" {
+						continue offers
+					}
+				default:
+					continue offers
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// --- Connection ---
+
+// WebSocketConn is a server-side WebSocket connection. ReadMessage must be called from one
+// goroutine at a time, and pings, pongs and close frames from the peer are handled while it
+// runs, so keep a goroutine reading. The write methods may be called concurrently.
+//
+// The server pings every PingInterval and drops the connection if the peer stays silent for
+// PongTimeout longer. When the Engine shuts down, connections are closed with 1001 (going away).
+type WebSocketConn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	config      WebSocketConfig
+	subprotocol string
+	compress    bool // permessage-deflate was negotiated
+
+	readMu        sync.Mutex
+	readErr       error         // Sticky; guarded by readMu
+	inflater      io.ReadCloser // Reused between messages; guarded by readMu
+	closeDeadline atomic.Int64  // UnixNano by which the peer must confirm our close frame; 0 if none was sent
+
+	writeMu   sync.Mutex
+	closeSent bool          // Guarded by writeMu
+	deflater  *flate.Writer // Reused between messages; guarded by writeMu
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+// newWebSocketConn wraps a hijacked connection.
+func newWebSocketConn(conn net.Conn, br *bufio.Reader, config WebSocketConfig, subprotocol string, compress bool) *WebSocketConn {
+	ws := &WebSocketConn{
+		conn:        conn,
+		br:          br,
+		config:      config,
+		subprotocol: subprotocol,
+		compress:    compress,
+	}
+	ws.ctx, ws.cancel = context.WithCancel(context.Background())
+	return ws
+}
+
+// Subprotocol returns the negotiated subprotocol, or "" if none.
+func (ws *WebSocketConn) Subprotocol() string {
+	return ws.subprotocol
+}
+
+// RemoteAddr returns the peer's network address.
+func (ws *WebSocketConn) RemoteAddr() net.Addr {
+	return ws.conn.RemoteAddr()
+}
+
+// Context returns a context that is cancelled once the connection is closed.
+func (ws *WebSocketConn) Context() context.Context {
+	return ws.ctx
+}
+
+// Done returns a channel that is closed once the connection is closed.
+func (ws *WebSocketConn) Done() <-chan struct{} {
+	return ws.ctx.Done()
+}
+
+// ReadMessage returns the next data message. Once the connection is closed it returns a
+// *WebSocketCloseError, or ErrWebSocketClosed if it was closed locally.
+func (ws *WebSocketConn) ReadMessage() (WebSocketMessageType, []byte, error) {
+	ws.readMu.Lock()
+	defer ws.readMu.Unlock()
+	if ws.readErr != nil {
+		return 0, nil, ws.readErr
+	}
+	typ, data, err := ws.readMessageLocked()
+	if err != nil {
+		ws.readErr = err
+	}
+	return typ, data, err
+}
+
+// ReadJSON reads the next message and decodes it as JSON into v.
+func (ws *WebSocketConn) ReadJSON(v interface{}) error {
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteMessage sends a data message.
+func (ws *WebSocketConn) WriteMessage(typ WebSocketMessageType, data []byte) error {
+	if typ != WebSocketText && typ != WebSocketBinary {
+		return fmt.Errorf("invalid websocket message type %d", typ)
+	}
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	if ws.closeSent {
+		return ErrWebSocketClosed
+	}
+
+	rsv1 := false
+	if ws.compress && len(data) >= ws.config.CompressionThreshold {
+		compressed, err := ws.deflateLocked(data)
+		if err != nil {
+			return err
+		}
+		data, rsv1 = compressed, true
+	}
+	opcode := byte(typ)
+	for {
+		frame := data
+		if size := ws.config.FragmentSize; size > 0 && len(frame) > size {
+			frame = frame[:size]
+		}
+		data = data[len(frame):]
+		fin := len(data) == 0
+		if err := ws.writeFrameLocked(opcode, rsv1, fin, frame); err != nil {
+			return err
+		}
+		if fin {
+			return nil
+		}
+		opcode, rsv1 = wsOpContinuation, false // RSV1 marks only the first frame of a compressed message
+	}
+}
+
+// WriteText sends a text message.
+func (ws *WebSocketConn) WriteText(text string) error {
+	return ws.WriteMessage(WebSocketText, []byte(text))
+}
+
+// WriteJSON sends v encoded as JSON in a text message.
+func (ws *WebSocketConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.WriteMessage(WebSocketText, data)
+}
+
+// Ping sends a ping with an optional payload of up to 125 bytes.
+func (ws *WebSocketConn) Ping(payload []byte) error {
+	if len(payload) > 125 {
+		return errors.New("websocket ping payload exceeds 125 bytes")
+	}
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	if ws.closeSent {
+		return ErrWebSocketClosed
+	}
+	return ws.writeFrameLocked(wsOpPing, false, true, payload)
+}
+
+// Close starts the closing handshake with the given code and reason, waits up to CloseTimeout for
+// the peer to confirm, then closes the connection. It is safe to call more than once and from any
+// goroutine.
+func (ws *WebSocketConn) Close(code int, reason string) error {
+	err := ws.sendClose(code, reason)
+	if errors.Is(err, ErrWebSocketClosed) {
+		err = nil // Already closing
+	}
+
+	if ws.readMu.TryLock() {
+		// Nobody is reading, so wait for the peer's close frame here, discarding data messages
+		for ws.readErr == nil {
+			if _, _, readErr := ws.readMessageLocked(); readErr != nil {
+				ws.readErr = readErr
+			}
+		}
+		ws.readMu.Unlock()
+	} else {
+		// The active ReadMessage sees the peer's close frame and closes the connection
+		timer := time.NewTimer(ws.config.CloseTimeout)
+		defer timer.Stop()
+		select {
+		case <-ws.ctx.Done():
+		case <-timer.C:
+		}
+	}
+	ws.closeConn()
+	return err
+}
+
+// sendClose sends a close frame, unless one was sent already. Reasons are cut to fit the
+// 125-byte control frame limit.
+func (ws *WebSocketConn) sendClose(code int, reason string) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	if ws.closeSent {
+		return ErrWebSocketClosed
+	}
+	ws.closeSent = true
+	ws.closeDeadline.Store(time.Now().Add(ws.config.CloseTimeout).UnixNano())
+
+	var payload []byte
+	if code != WebSocketCloseNoStatus { // 1005 means "no code", so it is sent as an empty close frame
+		if len(reason) > 123 {
+			reason = truncateUTF8(reason, 123)
+		}
+		payload = make([]byte, 2, 2+len(reason))
+		payload[0], payload[1] = byte(code>>8), byte(code)
+		payload = append(payload, reason...)
+	}
+	return ws.writeFrameLocked(wsOpClose, false, true, payload)
+}
+
+// closeConn closes the network connection and cancels the connection's context.
+func (ws *WebSocketConn) closeConn() {
+	ws.once.Do(func() {
+		ws.conn.Close()
+		ws.cancel()
+	})
+}
+
+// keepAlive pings the peer and closes the connection when the server shuts down.
+func (ws *WebSocketConn) keepAlive(shutdown <-chan struct{}) {
+	ticker := time.NewTicker(ws.config.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ws.Ping(nil); err != nil {
+				return
+			}
+		case <-shutdown:
+			ws.Close(WebSocketCloseGoingAway, "server shutting down")
+			return
+		case <-ws.ctx.Done():
+			return
+		}
+	}
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	for n > 0 && n < len(s) && s[n]&0xC0 == 0x80 { // Back up over continuation bytes
+		n--
+	}
+	return s[:n]
+}
+
+// closeCodeValid reports whether a peer may send the close code (RFC 6455, section 7.4).
+func closeCodeValid(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999: // Registered (3xxx) and private (4xxx) codes
+		return true
+	default:
+		return false // Including 1004-1006 and 1015, which must never be sent
+	}
+}
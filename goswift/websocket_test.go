@@ -0,0 +1,492 @@
+// go-swift/goswift/websocket_test.go
+package goswift
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// First-byte flags of a frame header.
+const (
+	wsTestFin  byte = 0x80
+	wsTestRsv1 byte = 0x40
+	wsTestRsv2 byte = 0x20
+)
+
+// wsClientFrame encodes a frame as a client sends it, masked. b0 is the first header byte:
+// FIN and RSV flags or'ed with the opcode.
+func wsClientFrame(b0 byte, payload string) []byte {
+	return wsEncodeFrame(b0, true, uint64(len(payload)), []byte(payload))
+}
+
+// wsUnmaskedFrame encodes a frame without a mask, which clients must not send.
+func wsUnmaskedFrame(b0 byte, payload string) []byte {
+	return wsEncodeFrame(b0, false, uint64(len(payload)), []byte(payload))
+}
+
+// wsEncodeFrame encodes a frame header declaring length, followed by payload.
+func wsEncodeFrame(b0 byte, masked bool, length uint64, payload []byte) []byte {
+	frame := []byte{b0, 0}
+	switch {
+	case length <= 125:
+		frame[1] = byte(length)
+	case length <= 0xFFFF:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, length)
+	}
+	if !masked {
+		return append(frame, payload...)
+	}
+	frame[1] |= 0x80
+	mask := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// wsCloseFrame encodes a client close frame with a code and reason.
+func wsCloseFrame(code int, reason string) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return wsClientFrame(wsTestFin|wsOpClose, string(payload)+reason)
+}
+
+// wsDeflate compresses data for permessage-deflate, without the trailing sync marker.
+func wsDeflate(t *testing.T, data []byte) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	w.Flush()
+	return string(bytes.TrimSuffix(buf.Bytes(), []byte(deflateTail[:4])))
+}
+
+// wsServerFrame is a frame the server sent.
+type wsServerFrame struct {
+	fin     bool
+	opcode  byte
+	payload string
+}
+
+// readServerFrames reads the server's frames until the connection closes.
+func readServerFrames(conn net.Conn) <-chan []wsServerFrame {
+	result := make(chan []wsServerFrame, 1)
+	go func() {
+		var frames []wsServerFrame
+		r := bufio.NewReader(conn)
+		for {
+			var header [2]byte
+			if _, err := io.ReadFull(r, header[:]); err != nil {
+				break
+			}
+			payload := make([]byte, header[1]&0x7F) // The server only sends small frames here
+			if _, err := io.ReadFull(r, payload); err != nil {
+				break
+			}
+			frames = append(frames, wsServerFrame{fin: header[0]&wsTestFin != 0, opcode: header[0] & 0x0F, payload: string(payload)})
+		}
+		result <- frames
+	}()
+	return result
+}
+
+// wsTestMessage is a data message the server read.
+type wsTestMessage struct {
+	typ  WebSocketMessageType
+	data string
+}
+
+// The reader follows RFC 6455 on the frames a client sends, closing with the right code on
+// violations, in the spirit of the Autobahn test suite.
+func TestWebSocketReadFrames(t *testing.T) {
+	bomb := wsDeflate(t, make([]byte, 1<<20)) // 1 MiB of zeros, about 1 KiB compressed
+
+	tests := []struct {
+		name      string
+		readLimit int64
+		compress  bool
+		frames    [][]byte
+		want      []wsTestMessage
+		wantPongs []string
+		wantCode  int // Close code ReadMessage reports, and the server sends unless it is 1005
+	}{
+		{
+			name:     "text",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsOpText, "hello"), wsCloseFrame(1000, "bye")},
+			want:     []wsTestMessage{{WebSocketText, "hello"}},
+			wantCode: 1000,
+		},
+		{
+			name:     "binary",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsOpBinary, "\x00\xff"), wsCloseFrame(1000, "")},
+			want:     []wsTestMessage{{WebSocketBinary, "\x00\xff"}},
+			wantCode: 1000,
+		},
+		{
+			name:     "empty text",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsOpText, ""), wsCloseFrame(1000, "")},
+			want:     []wsTestMessage{{WebSocketText, ""}},
+			wantCode: 1000,
+		},
+		{
+			name:     "16-bit length",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsOpText, strings.Repeat("x", 300)), wsCloseFrame(1000, "")},
+			want:     []wsTestMessage{{WebSocketText, strings.Repeat("x", 300)}},
+			wantCode: 1000,
+		},
+		{
+			name: "fragmented",
+			frames: [][]byte{
+				wsClientFrame(wsOpText, "Hel"),
+				wsClientFrame(wsOpContinuation, "lo "),
+				wsClientFrame(wsTestFin|wsOpContinuation, "world"),
+				wsCloseFrame(1000, ""),
+			},
+			want:     []wsTestMessage{{WebSocketText, "Hello world"}},
+			wantCode: 1000,
+		},
+		{
+			name: "empty fragments",
+			frames: [][]byte{
+				wsClientFrame(wsOpBinary, ""),
+				wsClientFrame(wsOpContinuation, ""),
+				wsClientFrame(wsTestFin|wsOpContinuation, ""),
+				wsCloseFrame(1000, ""),
+			},
+			want:     []wsTestMessage{{WebSocketBinary, ""}},
+			wantCode: 1000,
+		},
+		{
+			name: "ping and pong between fragments",
+			frames: [][]byte{
+				wsClientFrame(wsOpText, "a"),
+				wsClientFrame(wsTestFin|wsOpPing, "p1"),
+				wsClientFrame(wsOpContinuation, "b"),
+				wsClientFrame(wsTestFin|wsOpPong, "unsolicited"),
+				wsClientFrame(wsTestFin|wsOpPing, ""),
+				wsClientFrame(wsTestFin|wsOpContinuation, "c"),
+				wsCloseFrame(1000, ""),
+			},
+			want:      []wsTestMessage{{WebSocketText, "abc"}},
+			wantPongs: []string{"p1", ""},
+			wantCode:  1000,
+		},
+		{
+			name: "UTF-8 split across fragments",
+			frames: [][]byte{
+				wsClientFrame(wsOpText, "\xe2\x82"),
+				wsClientFrame(wsTestFin|wsOpContinuation, "\xac"),
+				wsCloseFrame(1000, ""),
+			},
+			want:     []wsTestMessage{{WebSocketText, "€"}},
+			wantCode: 1000,
+		},
+		{
+			name:     "two messages",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsOpText, "one"), wsClientFrame(wsTestFin|wsOpBinary, "two"), wsCloseFrame(1000, "")},
+			want:     []wsTestMessage{{WebSocketText, "one"}, {WebSocketBinary, "two"}},
+			wantCode: 1000,
+		},
+		{
+			name:     "close without status",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsOpClose, "")},
+			wantCode: WebSocketCloseNoStatus,
+		},
+		{
+			name:     "private close code",
+			frames:   [][]byte{wsCloseFrame(4000, "custom")},
+			wantCode: 4000,
+		},
+
+		// Protocol errors: 1002
+		{
+			name:     "unmasked",
+			frames:   [][]byte{wsUnmaskedFrame(wsTestFin|wsOpText, "hello")},
+			wantCode: WebSocketCloseProtocolError,
+		},
+		{
+			name:     "unmasked after a valid message",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsOpText, "ok"), wsUnmaskedFrame(wsTestFin|wsOpPing, "")},
+			want:     []wsTestMessage{{WebSocketText, "ok"}},
+			wantCode: WebSocketCloseProtocolError,
+		},
+		{
+			name:     "reserved bit",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsTestRsv2|wsOpText, "hello")},
+			wantCode: WebSocketCloseProtocolError,
+		},
+		{
+			name:     "compression bit without negotiation",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsTestRsv1|wsOpText, "hello")},
+			wantCode: WebSocketCloseProtocolError,
+		},
+		{
+			name:     "continuation without a message",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsOpContinuation, "x")},
+			wantCode: WebSocketCloseProtocolError,
+		},
+		{
+			name:     "new message before the previous one ended",
+			frames:   [][]byte{wsClientFrame(wsOpText, "a"), wsClientFrame(wsTestFin|wsOpText, "b")},
+			wantCode: WebSocketCloseProtocolError,
+		},
+		{
+			name:     "fragmented ping",
+			frames:   [][]byte{wsClientFrame(wsOpPing, "a"), wsClientFrame(wsTestFin|wsOpContinuation, "b")},
+			wantCode: WebSocketCloseProtocolError,
+		},
+		{
+			name:     "control frame over 125 bytes",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsOpPing, strings.Repeat("x", 126))},
+			wantCode: WebSocketCloseProtocolError,
+		},
+		{
+			name:     "compressed control frame",
+			compress: true,
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsTestRsv1|wsOpPing, "")},
+			wantCode: WebSocketCloseProtocolError,
+		},
+		{
+			name:     "unknown data opcode",
+			frames:   [][]byte{wsClientFrame(wsTestFin|0x3, "x")},
+			wantCode: WebSocketCloseProtocolError,
+		},
+		{
+			name:     "unknown control opcode",
+			frames:   [][]byte{wsClientFrame(wsTestFin|0xB, "x")},
+			wantCode: WebSocketCloseProtocolError,
+		},
+		{
+			name:     "one-byte close payload",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsOpClose, "\x03")},
+			wantCode: WebSocketCloseProtocolError,
+		},
+		{
+			name:     "reserved close code",
+			frames:   [][]byte{wsCloseFrame(WebSocketCloseNoStatus, "")},
+			wantCode: WebSocketCloseProtocolError,
+		},
+
+		// Invalid payloads: 1007
+		{
+			name:     "invalid UTF-8",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsOpText, "\xce\xba\xe1\xbd\xb9\xcf\x83\xce\xbc\xce\xb5\xed\xa0\x80edited")},
+			wantCode: WebSocketCloseInvalidPayload,
+		},
+		{
+			name:     "invalid UTF-8 in a later fragment",
+			frames:   [][]byte{wsClientFrame(wsOpText, "ok"), wsClientFrame(wsTestFin|wsOpContinuation, "\xff")},
+			wantCode: WebSocketCloseInvalidPayload,
+		},
+		{
+			name:     "truncated UTF-8 at the end",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsOpText, "\xe2\x82")},
+			wantCode: WebSocketCloseInvalidPayload,
+		},
+		{
+			name:     "invalid UTF-8 in binary is fine",
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsOpBinary, "\xff"), wsCloseFrame(1000, "")},
+			want:     []wsTestMessage{{WebSocketBinary, "\xff"}},
+			wantCode: 1000,
+		},
+		{
+			name:     "invalid UTF-8 in close reason",
+			frames:   [][]byte{wsCloseFrame(1000, "\xff")},
+			wantCode: WebSocketCloseInvalidPayload,
+		},
+
+		// ReadLimit: 1009
+		{
+			name:      "at the read limit",
+			readLimit: 16,
+			frames:    [][]byte{wsClientFrame(wsTestFin|wsOpText, strings.Repeat("x", 16)), wsCloseFrame(1000, "")},
+			want:      []wsTestMessage{{WebSocketText, strings.Repeat("x", 16)}},
+			wantCode:  1000,
+		},
+		{
+			name:      "over the read limit",
+			readLimit: 16,
+			frames:    [][]byte{wsClientFrame(wsTestFin|wsOpText, strings.Repeat("x", 17))},
+			wantCode:  WebSocketCloseMessageTooBig,
+		},
+		{
+			name:      "fragments over the read limit",
+			readLimit: 16,
+			frames:    [][]byte{wsClientFrame(wsOpText, strings.Repeat("x", 10)), wsClientFrame(wsTestFin|wsOpContinuation, strings.Repeat("x", 10))},
+			wantCode:  WebSocketCloseMessageTooBig,
+		},
+		{
+			name:      "declared length over the read limit",
+			readLimit: 16,
+			frames:    [][]byte{wsEncodeFrame(wsTestFin|wsOpBinary, true, 1<<62, nil)}, // Must fail before allocating
+			wantCode:  WebSocketCloseMessageTooBig,
+		},
+		{
+			name:      "64-bit length with the top bit set",
+			readLimit: 16,
+			frames:    [][]byte{wsEncodeFrame(wsTestFin|wsOpBinary, true, 1<<64-1, nil)},
+			wantCode:  WebSocketCloseMessageTooBig,
+		},
+
+		// permessage-deflate
+		{
+			name:     "compressed",
+			compress: true,
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsTestRsv1|wsOpText, wsDeflate(t, []byte("hello hello hello"))), wsCloseFrame(1000, "")},
+			want:     []wsTestMessage{{WebSocketText, "hello hello hello"}},
+			wantCode: 1000,
+		},
+		{
+			name:     "compressed and fragmented",
+			compress: true,
+			frames: func() [][]byte {
+				data := wsDeflate(t, []byte(strings.Repeat("abc", 100)))
+				return [][]byte{
+					wsClientFrame(wsTestRsv1|wsOpText, data[:5]),
+					wsClientFrame(wsTestFin|wsOpPing, "p"),
+					wsClientFrame(wsTestFin|wsOpContinuation, data[5:]),
+					wsCloseFrame(1000, ""),
+				}
+			}(),
+			want:      []wsTestMessage{{WebSocketText, strings.Repeat("abc", 100)}},
+			wantPongs: []string{"p"},
+			wantCode:  1000,
+		},
+		{
+			name:     "uncompressed message on a compressed connection",
+			compress: true,
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsOpText, "plain"), wsCloseFrame(1000, "")},
+			want:     []wsTestMessage{{WebSocketText, "plain"}},
+			wantCode: 1000,
+		},
+		{
+			name:     "compression bit on a continuation frame",
+			compress: true,
+			frames:   [][]byte{wsClientFrame(wsTestRsv1|wsOpText, ""), wsClientFrame(wsTestFin|wsTestRsv1|wsOpContinuation, "")},
+			wantCode: WebSocketCloseProtocolError,
+		},
+		{
+			name:     "invalid compressed data",
+			compress: true,
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsTestRsv1|wsOpBinary, "\xff\xff\xff\xff")},
+			wantCode: WebSocketCloseInvalidPayload,
+		},
+		{
+			name:     "compressed invalid UTF-8",
+			compress: true,
+			frames:   [][]byte{wsClientFrame(wsTestFin|wsTestRsv1|wsOpText, wsDeflate(t, []byte("\xff")))},
+			wantCode: WebSocketCloseInvalidPayload,
+		},
+		{
+			name:      "deflate bomb",
+			readLimit: 64 << 10,
+			compress:  true,
+			frames:    [][]byte{wsClientFrame(wsTestFin|wsTestRsv1|wsOpBinary, bomb)},
+			wantCode:  WebSocketCloseMessageTooBig,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			config := WebSocketConfig{ReadLimit: tt.readLimit}.withDefaults()
+			ws := newWebSocketConn(server, bufio.NewReader(server), config, "", tt.compress)
+
+			go func() {
+				for _, frame := range tt.frames {
+					if _, err := client.Write(frame); err != nil {
+						return // The server closed the connection
+					}
+				}
+			}()
+			serverFrames := readServerFrames(client)
+
+			var got []wsTestMessage
+			var err error
+			for {
+				var typ WebSocketMessageType
+				var data []byte
+				if typ, data, err = ws.ReadMessage(); err != nil {
+					break
+				}
+				got = append(got, wsTestMessage{typ, string(data)})
+			}
+
+			var closeErr *WebSocketCloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != tt.wantCode {
+				t.Fatalf("ReadMessage error = %v, want close code %d", err, tt.wantCode)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got messages %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("message %d = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+			if _, _, again := ws.ReadMessage(); again != err {
+				t.Fatalf("second ReadMessage error = %v, want the same %v", again, err)
+			}
+
+			frames := <-serverFrames
+			var pongs []string
+			for _, f := range frames {
+				if f.opcode == wsOpPong {
+					pongs = append(pongs, f.payload)
+				}
+			}
+			if strings.Join(pongs, ",") != strings.Join(tt.wantPongs, ",") || len(pongs) != len(tt.wantPongs) {
+				t.Fatalf("server sent pongs %q, want %q", pongs, tt.wantPongs)
+			}
+			if len(frames) == 0 || frames[len(frames)-1].opcode != wsOpClose {
+				t.Fatalf("server did not end with a close frame: %v", frames)
+			}
+			payload := frames[len(frames)-1].payload
+			if tt.wantCode == WebSocketCloseNoStatus {
+				if payload != "" {
+					t.Fatalf("server close payload = %q, want empty", payload)
+				}
+			} else if len(payload) < 2 || int(binary.BigEndian.Uint16([]byte(payload))) != tt.wantCode {
+				t.Fatalf("server close payload = %q, want code %d", payload, tt.wantCode)
+			}
+		})
+	}
+}
+
+// Outgoing messages longer than FragmentSize are split into continuation frames.
+func TestWebSocketWriteFragmented(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	ws := newWebSocketConn(server, bufio.NewReader(server), WebSocketConfig{FragmentSize: 4}.withDefaults(), "", false)
+	serverFrames := readServerFrames(client)
+
+	if err := ws.WriteText("hello world"); err != nil {
+		t.Fatal(err)
+	}
+	ws.closeConn()
+
+	want := []wsServerFrame{{false, wsOpText, "hell"}, {false, wsOpContinuation, "o wo"}, {true, wsOpContinuation, "rld"}}
+	got := <-serverFrames
+	if len(got) != len(want) {
+		t.Fatalf("frames = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("frame %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// go-swift/goswift/websocketframe.go
+package goswift
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket frame opcodes (RFC 6455, section 5.2).
+const (
+	wsOpContinuation byte = 0x0
+	wsOpText         byte = 0x1
+	wsOpBinary       byte = 0x2
+	wsOpClose        byte = 0x8
+	wsOpPing         byte = 0x9
+	wsOpPong         byte = 0xA
+)
+
+// deflateTail completes a permessage-deflate message for the inflater: the sync flush marker
+// the sender stripped, then an empty final block so inflating ends with io.EOF.
+const deflateTail = "\x00\x00\xff\xff\x01\x00\x00\xff\xff"
+
+// errWebSocketTooBig is returned by inflate when a message exceeds ReadLimit.
+var errWebSocketTooBig = errors.New("message exceeds the read limit")
+
+// wsFrameHeader is the decoded header of a frame.
+type wsFrameHeader struct {
+	fin              bool
+	rsv1, rsv2, rsv3 bool
+	opcode           byte
+	masked           bool
+	mask             [4]byte
+	length           int64
+}
+
+// readFrameHeader reads and decodes a frame header.
+func (ws *WebSocketConn) readFrameHeader() (wsFrameHeader, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(ws.br, b[:2]); err != nil {
+		return wsFrameHeader{}, err
+	}
+	h := wsFrameHeader{
+		fin:    b[0]&0x80 != 0,
+		rsv1:   b[0]&0x40 != 0,
+		rsv2:   b[0]&0x20 != 0,
+		rsv3:   b[0]&0x10 != 0,
+		opcode: b[0] & 0x0F,
+		masked: b[1]&0x80 != 0,
+		length: int64(b[1] & 0x7F),
+	}
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(ws.br, b[:2]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(ws.br, b[:8]); err != nil {
+			return h, err
+		}
+		length := binary.BigEndian.Uint64(b[:8])
+		if length>>63 != 0 { // The most significant bit must be 0
+			length = 1<<63 - 1 // Reported as too big rather than wrapping negative
+		}
+		h.length = int64(length)
+	}
+	if h.masked {
+		if _, err := io.ReadFull(ws.br, h.mask[:]); err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+// readPayload reads and unmasks a frame's payload.
+func (ws *WebSocketConn) readPayload(h wsFrameHeader) ([]byte, error) {
+	payload := make([]byte, h.length)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return nil, err
+	}
+	for i := range payload {
+		payload[i] ^= h.mask[i%4]
+	}
+	return payload, nil
+}
+
+// readMessageLocked reads frames until a complete data message arrives, answering control frames
+// on the way. Fragmented messages are reassembled and decompressed.
+func (ws *WebSocketConn) readMessageLocked() (WebSocketMessageType, []byte, error) {
+	var (
+		started    bool
+		msgType    WebSocketMessageType
+		compressed bool
+		data       []byte
+	)
+	for {
+		ws.conn.SetReadDeadline(ws.readDeadline())
+		h, err := ws.readFrameHeader()
+		if err != nil {
+			return 0, nil, ws.abort(err)
+		}
+		if h.rsv2 || h.rsv3 {
+			return 0, nil, ws.fail(WebSocketCloseProtocolError, "reserved bits set")
+		}
+		if !h.masked {
+			return 0, nil, ws.fail(WebSocketCloseProtocolError, "client frames must be masked")
+		}
+
+		if h.opcode >= 0x8 { // Control frames may arrive between the fragments of a message
+			if !h.fin || h.length > 125 || h.rsv1 {
+				return 0, nil, ws.fail(WebSocketCloseProtocolError, "invalid control frame")
+			}
+			payload, err := ws.readPayload(h)
+			if err != nil {
+				return 0, nil, ws.abort(err)
+			}
+			switch h.opcode {
+			case wsOpPing:
+				if err := ws.pong(payload); err != nil && !errors.Is(err, ErrWebSocketClosed) {
+					return 0, nil, ws.abort(err)
+				}
+			case wsOpPong:
+				// Any frame shows the peer is alive; the read deadline is extended above
+			case wsOpClose:
+				return 0, nil, ws.handleClose(payload)
+			default:
+				return 0, nil, ws.fail(WebSocketCloseProtocolError, "unknown opcode")
+			}
+			continue
+		}
+
+		switch h.opcode {
+		case wsOpContinuation:
+			if !started {
+				return 0, nil, ws.fail(WebSocketCloseProtocolError, "continuation frame without a message")
+			}
+			if h.rsv1 {
+				return 0, nil, ws.fail(WebSocketCloseProtocolError, "compression bit on a continuation frame")
+			}
+		case wsOpText, wsOpBinary:
+			if started {
+				return 0, nil, ws.fail(WebSocketCloseProtocolError, "new message before the previous one ended")
+			}
+			if h.rsv1 && !ws.compress {
+				return 0, nil, ws.fail(WebSocketCloseProtocolError, "compression bit without permessage-deflate")
+			}
+			started, msgType, compressed = true, WebSocketMessageType(h.opcode), h.rsv1
+		default:
+			return 0, nil, ws.fail(WebSocketCloseProtocolError, "unknown opcode")
+		}
+
+		if int64(len(data))+h.length > ws.config.ReadLimit { // Checked before allocating the payload
+			return 0, nil, ws.fail(WebSocketCloseMessageTooBig, "message too big")
+		}
+		payload, err := ws.readPayload(h)
+		if err != nil {
+			return 0, nil, ws.abort(err)
+		}
+		data = append(data, payload...)
+		if !h.fin {
+			continue
+		}
+
+		if compressed {
+			if data, err = ws.inflateLocked(data); errors.Is(err, errWebSocketTooBig) {
+				return 0, nil, ws.fail(WebSocketCloseMessageTooBig, "message too big")
+			} else if err != nil {
+				return 0, nil, ws.fail(WebSocketCloseInvalidPayload, "invalid compressed data")
+			}
+		}
+		if msgType == WebSocketText && !utf8.Valid(data) {
+			return 0, nil, ws.fail(WebSocketCloseInvalidPayload, "invalid UTF-8 in text message")
+		}
+		if data == nil {
+			data = []byte{}
+		}
+		return msgType, data, nil
+	}
+}
+
+// readDeadline returns the deadline for the next frame: the peer must answer within
+// PingInterval+PongTimeout, or confirm our close frame by its deadline.
+func (ws *WebSocketConn) readDeadline() time.Time {
+	deadline := time.Now().Add(ws.config.PingInterval + ws.config.PongTimeout)
+	if closing := ws.closeDeadline.Load(); closing != 0 && closing < deadline.UnixNano() {
+		return time.Unix(0, closing)
+	}
+	return deadline
+}
+
+// handleClose answers a close frame from the peer and closes the connection.
+func (ws *WebSocketConn) handleClose(payload []byte) error {
+	code, reason := WebSocketCloseNoStatus, ""
+	switch {
+	case len(payload) == 1:
+		return ws.fail(WebSocketCloseProtocolError, "invalid close frame")
+	case len(payload) >= 2:
+		code, reason = int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+		if !closeCodeValid(code) {
+			return ws.fail(WebSocketCloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(reason) {
+			return ws.fail(WebSocketCloseInvalidPayload, "invalid UTF-8 in close reason")
+		}
+	}
+	ws.sendClose(code, "") // Echo the code; a no-op if we started the closing handshake
+	ws.closeConn()         // The server closes the TCP connection first (RFC 6455, section 7.1.1)
+	return &WebSocketCloseError{Code: code, Reason: reason}
+}
+
+// fail closes the connection after a protocol violation by the peer, telling it why.
+func (ws *WebSocketConn) fail(code int, reason string) error {
+	ws.sendClose(code, reason)
+	ws.closeConn()
+	return &WebSocketCloseError{Code: code, Reason: reason}
+}
+
+// abort closes the connection after a network error or timeout.
+func (ws *WebSocketConn) abort(err error) error {
+	select {
+	case <-ws.ctx.Done():
+		return ErrWebSocketClosed // Closed locally, which is what interrupted the read
+	default:
+	}
+	ws.closeConn()
+	reason := "connection lost"
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		reason = "timed out"
+	}
+	return &WebSocketCloseError{Code: WebSocketCloseAbnormal, Reason: reason}
+}
+
+// pong answers a ping.
+func (ws *WebSocketConn) pong(payload []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	if ws.closeSent {
+		return ErrWebSocketClosed
+	}
+	return ws.writeFrameLocked(wsOpPong, false, true, payload)
+}
+
+// writeFrameLocked writes a single unmasked frame. A failed write closes the connection.
+func (ws *WebSocketConn) writeFrameLocked(opcode byte, rsv1, fin bool, payload []byte) error {
+	var header [10]byte
+	header[0] = opcode
+	if fin {
+		header[0] |= 0x80
+	}
+	if rsv1 {
+		header[0] |= 0x40
+	}
+	n := 2
+	switch length := len(payload); {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xFFFF:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+		n = 4
+	default:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+		n = 10
+	}
+
+	ws.conn.SetWriteDeadline(time.Now().Add(ws.config.WriteTimeout))
+	buffers := net.Buffers{header[:n], payload}
+	if _, err := buffers.WriteTo(ws.conn); err != nil {
+		ws.closeConn()
+		return err
+	}
+	return nil
+}
+
+// deflateLocked compresses a message for permessage-deflate, without the trailing sync marker.
+func (ws *WebSocketConn) deflateLocked(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if ws.deflater == nil {
+		deflater, err := flate.NewWriter(&buf, flate.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+		ws.deflater = deflater
+	} else {
+		ws.deflater.Reset(&buf) // No context takeover: every message starts afresh
+	}
+	if _, err := ws.deflater.Write(data); err != nil {
+		return nil, err
+	}
+	if err := ws.deflater.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte(deflateTail[:4])), nil
+}
+
+// inflateLocked decompresses a permessage-deflate message, stopping past ReadLimit so small
+// frames cannot expand into huge messages.
+func (ws *WebSocketConn) inflateLocked(data []byte) ([]byte, error) {
+	src := io.MultiReader(bytes.NewReader(data), strings.NewReader(deflateTail))
+	if ws.inflater == nil {
+		ws.inflater = flate.NewReader(src)
+	} else if err := ws.inflater.(flate.Resetter).Reset(src, nil); err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(io.LimitReader(ws.inflater, ws.config.ReadLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > ws.config.ReadLimit {
+		return nil, errWebSocketTooBig
+	}
+	return out, nil
+}
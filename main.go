@@ -3,8 +3,11 @@ package main
 
 import (
 	"embed" // For embedding static files
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"sync"
@@ -24,6 +27,11 @@ type User struct {
 	ID             string
 	Username       string
 	HashedPassword string
+	// Two-factor authentication
+	TOTPSecret      string   // Set once enrollment is confirmed
+	PendingTOTP     string   // Secret shown during enrollment, not yet confirmed
+	TOTPLastCounter int64    // Last accepted time step, to reject replayed codes
+	RecoveryCodes   []string // HashPassword hashes of unused recovery codes
 }
 
 // Document represents a QuikDocs document.
@@ -31,17 +39,105 @@ type Document struct {
 	ID        string `json:"id"`
 	Title     string `json:"title"`
 	Content   string `json:"content"`
+	Revision  int    `json:"revision"` // Number of live edits applied, see applyEditLocked
 	OwnerID   string `json:"owner_id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Versions  []DocumentVersion `json:"versions"` // Simple version history
 	ShareID   string `json:"share_id,omitempty"` // Public shareable ID
+	// Collaborators maps user IDs to the role they were granted; the owner is not listed.
+	Collaborators map[string]DocRole `json:"collaborators,omitempty"`
+}
+
+// DocRole is a user's access level on a document. Each role includes the rights of the ones below it.
+type DocRole string
+
+const (
+	RoleViewer    DocRole = "viewer"    // Read the document, its history and live updates
+	RoleCommenter DocRole = "commenter" // Viewer, plus commenting
+	RoleEditor    DocRole = "editor"    // Commenter, plus editing the content
+	RoleOwner     DocRole = "owner"     // Editor, plus sharing, managing collaborators and deleting
+)
+
+// docRoleRank orders roles for comparison; unknown roles rank 0 (no access).
+var docRoleRank = map[DocRole]int{RoleViewer: 1, RoleCommenter: 2, RoleEditor: 3, RoleOwner: 4}
+
+// RoleOf returns the role userID has on the document, or "" if it has no access.
+func (d Document) RoleOf(userID string) DocRole {
+	if d.OwnerID == userID {
+		return RoleOwner
+	}
+	return d.Collaborators[userID]
+}
+
+// withCollaborator returns a copy of the collaborators with userID granted role, or removed if
+// role is "". Documents are copied out of the store and read after its lock is released, so the
+// map is replaced rather than changed in place.
+func (d Document) withCollaborator(userID string, role DocRole) map[string]DocRole {
+	collaborators := make(map[string]DocRole, len(d.Collaborators)+1)
+	for id, r := range d.Collaborators {
+		collaborators[id] = r
+	}
+	if role == "" {
+		delete(collaborators, userID)
+	} else {
+		collaborators[userID] = role
+	}
+	return collaborators
+}
+
+// atLeast reports whether r grants at least the rights of min.
+func (r DocRole) atLeast(min DocRole) bool {
+	return docRoleRank[r] > 0 && docRoleRank[r] >= docRoleRank[min]
+}
+
+// docRole only lets users with at least the given role on the document in the :id path
+// parameter through. Missing documents are reported as 404 before access is considered.
+func docRole(min DocRole) goswift.Policy {
+	return func(c *goswift.Context) (bool, error) {
+		currentUserID, err := c.UserID()
+		if err != nil {
+			return false, err
+		}
+		inMemoryDocuments.RLock()
+		doc, ok := inMemoryDocuments.data[c.Param("id")]
+		inMemoryDocuments.RUnlock()
+		if !ok {
+			return false, goswift.NewHTTPError(http.StatusNotFound, "Document not found")
+		}
+		return doc.RoleOf(currentUserID).atLeast(min), nil
+	}
 }
 
 // DocumentVersion represents a snapshot of a document's content at a point in time.
 type DocumentVersion struct {
-	Timestamp time.Time `json:"timestamp"`
-	Content   string    `json:"content"`
+	Timestamp time.Time             `json:"timestamp"`
+	Content   string                `json:"content"`
+	Revision  int                   `json:"revision"`
+	Changes   goswift.TextOperation `json:"changes,omitempty"` // The edits since the previous version, composed into one
+	Saved     bool                  `json:"saved,omitempty"`   // Created or saved explicitly; live edits start a new version after it
+}
+
+// liveEditGap is how long editing must pause before live edits start a new version.
+const liveEditGap = time.Minute
+
+// recordVersion adds an edit, already applied to doc, to its version history. Live edits are
+// folded into the latest version until editing pauses for liveEditGap or the version is saved.
+func recordVersion(doc *Document, op goswift.TextOperation, now time.Time, save bool) {
+	n := len(doc.Versions)
+	last := doc.Versions[n-1]
+	if op.IsNoop() { // Nothing changed: saving marks the latest version
+		if !save || last.Saved {
+			return
+		}
+		last.Saved = true
+	} else if changes, err := last.Changes.Compose(op); err == nil && !last.Saved && now.Sub(last.Timestamp) < liveEditGap {
+		last.Timestamp, last.Content, last.Revision, last.Changes, last.Saved = now, doc.Content, doc.Revision, changes, save
+	} else {
+		doc.Versions = append(doc.Versions, DocumentVersion{Timestamp: now, Content: doc.Content, Revision: doc.Revision, Changes: op, Saved: save})
+		return
+	}
+	doc.Versions = append(doc.Versions[:n-1:n-1], last) // Copied: readers may hold the old slice
 }
 
 // In-memory storage for users and documents.
@@ -53,14 +149,25 @@ var (
 		data: make(map[string]User),
 	}
 
+	// External identities linked to local users by the OIDC login
+	inMemoryIdentities = struct {
+		sync.RWMutex
+		data map[string]string // map[issuer + " " + subject]userID
+	}{
+		data: make(map[string]string),
+	}
+
 	inMemoryDocuments = struct {
 		sync.RWMutex
 		data map[string]Document // map[documentID]Document
 		// Map to quickly find document ID by share ID
 		shareIDToDocID map[string]string // map[shareID]documentID
+		// Operation history of documents edited live, created on their first edit
+		live map[string]*goswift.OTDocument // map[documentID]*goswift.OTDocument
 	}{
 		data:           make(map[string]Document),
 		shareIDToDocID: make(map[string]string),
+		live:           make(map[string]*goswift.OTDocument),
 	}
 )
 
@@ -71,6 +178,32 @@ type AuthRequest struct {
 	Password string `json:"password"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type APIKeyCreateRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0 for keys that never expire
+}
+
+// API key scopes
+const (
+	apiKeyScopeRead  = "docs:read"
+	apiKeyScopeWrite = "docs:write"
+)
+
 type DocumentCreateRequest struct {
 	Title   string `json:"title"`
 	Content string `json:"content"`
@@ -80,21 +213,258 @@ type DocumentUpdateRequest struct {
 	Content string `json:"content"`
 }
 
+type CollaboratorRequest struct {
+	Username string  `json:"username"`
+	Role     DocRole `json:"role"`
+}
+
+// Collaborator is a user with access to a document, as returned by the collaborators endpoint.
+type Collaborator struct {
+	UserID   string  `json:"user_id"`
+	Username string  `json:"username"`
+	Role     DocRole `json:"role"`
+}
+
+// DocumentListItem is a document in the list view, together with the caller's role on it.
+type DocumentListItem struct {
+	Document
+	Role DocRole `json:"role"`
+}
+
+// userByID looks up a user by ID. Users are keyed by username, so this scans.
+func userByID(userID string) (User, bool) {
+	inMemoryUsers.RLock()
+	defer inMemoryUsers.RUnlock()
+	return userByIDLocked(userID)
+}
+
+// userByIDLocked is userByID for callers already holding the inMemoryUsers lock.
+func userByIDLocked(userID string) (User, bool) {
+	for _, user := range inMemoryUsers.data {
+		if user.ID == userID {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// usernameByID looks up a username for display.
+func usernameByID(userID string) string {
+	user, _ := userByID(userID)
+	return user.Username
+}
+
+// mfaFailures counts wrong second-factor attempts per MFA pending token.
+var mfaFailures = struct {
+	sync.Mutex
+	data map[string]int // map[tokenID]failures
+}{
+	data: make(map[string]int),
+}
+
+// maxMFAFailures is how many wrong codes an MFA pending token allows before it is revoked.
+const maxMFAFailures = 5
+
 // --- Main Application ---
 
 func main() {
 	app := goswift.New()
 
-	// Initialize SSE Manager
-	sseManager := goswift.NewSSEManager(app.Logger)
+	// Initialize SSE Manager. With several instances, updates are fanned out through a broker hub:
+	// SSE_BROKER_LISTEN runs the hub in this instance, SSE_BROKER_URL connects to it
+	// (tcp://host:port or unix:///path/to.sock).
+	if listenURL := app.Config.Get("SSE_BROKER_LISTEN"); listenURL != "" {
+		network, addr, err := goswift.ParseBrokerURL(listenURL)
+		if err != nil {
+			log.Fatalf("Invalid SSE_BROKER_LISTEN: %v", err)
+		}
+		listener, err := net.Listen(network, addr)
+		if err != nil {
+			log.Fatalf("Failed to start SSE broker: %v", err)
+		}
+		brokerServer := goswift.NewBrokerServer(app.Logger)
+		go brokerServer.Serve(listener)
+		defer brokerServer.Close()
+	}
+	var broker goswift.Broker = goswift.NewMemoryBroker()
+	if brokerURL := app.Config.Get("SSE_BROKER_URL"); brokerURL != "" {
+		network, addr, err := goswift.ParseBrokerURL(brokerURL)
+		if err != nil {
+			log.Fatalf("Invalid SSE_BROKER_URL: %v", err)
+		}
+		netBroker, err := goswift.DialBroker(network, addr, app.Logger)
+		if err != nil {
+			log.Fatalf("Failed to connect to SSE broker: %v", err)
+		}
+		broker = netBroker
+	}
+	defer broker.Close()
+	sseManager := goswift.NewSSEManagerWithBroker(app.Logger, broker)
+	sseManager.DefaultPolicy = goswift.SSECoalesceLatest // Updates carry the whole document, so only the latest matters
 	app.DI.Bind(sseManager) // Bind SSEManager to DI container
 
+	// Presence tracks who is viewing each document, shared between instances through the same broker.
+	// Every instance tells its own viewers about joins and leaves, so the events bypass the broker.
+	presence, err := goswift.NewPresence(broker, goswift.PresenceConfig{
+		OnChange: func(docID string, change goswift.PresenceChange) {
+			data, err := json.Marshal(change)
+			if err != nil {
+				return
+			}
+			sseManager.BroadcastLocal(docID, goswift.SSEEvent{Event: "presence", Data: string(data)})
+		},
+	}, app.Logger)
+	if err != nil {
+		log.Fatalf("Failed to start presence tracking: %v", err)
+	}
+	defer presence.Close()
+
+	// WebSocket hub for live editing traffic, with a room per document ("doc:<id>").
+	// Editors send operations on the document and share their cursor positions with the others in the room.
+	hub := goswift.NewHub(goswift.HubConfig{}, app.Logger)
+
+	// applyEditLocked applies an operation made on revision of doc, records it in the version history
+	// and sends it to the live editors; from, the client that made it (if any), gets an ack instead.
+	// Sending under the documents lock keeps every editor's operations in revision order.
+	applyEditLocked := func(doc *Document, userID string, revision int, op goswift.TextOperation, from *goswift.HubClient, save bool) error {
+		live, ok := inMemoryDocuments.live[doc.ID]
+		if !ok {
+			live = goswift.NewOTDocument(doc.Content, doc.Revision, 0)
+			inMemoryDocuments.live[doc.ID] = live
+		}
+		now := goswift.Now()
+		if !op.IsNoop() {
+			applied, newRevision, err := live.Apply(revision, op)
+			if err != nil {
+				return err
+			}
+			op = applied
+			doc.Content, doc.Revision = live.Snapshot()
+			doc.UpdatedAt = now
+			hub.BroadcastExcept("doc:"+doc.ID, from, map[string]interface{}{
+				"type":      "op",
+				"revision":  newRevision,
+				"operation": op,
+				"user_id":   userID,
+			})
+			sseManager.Broadcast(doc.ID, doc.Content) // For subscribers without a WebSocket
+		}
+		if from != nil {
+			from.Send(map[string]interface{}{"type": "ack", "revision": doc.Revision})
+		}
+		recordVersion(doc, op, now, save)
+		return nil
+	}
+
+	// editLiveDocument runs edit on the document of a live editor, if they may still edit it.
+	editLiveDocument := func(client *goswift.HubClient, edit func(doc *Document) error) error {
+		docID, _ := client.Get("docID")
+		inMemoryDocuments.Lock()
+		defer inMemoryDocuments.Unlock()
+		doc, ok := inMemoryDocuments.data[docID.(string)]
+		if !ok {
+			return goswift.NewHTTPError(http.StatusNotFound, "Document not found")
+		}
+		if !doc.RoleOf(client.UserID).atLeast(RoleEditor) {
+			return goswift.NewHTTPError(http.StatusForbidden, "Only editors can change this document")
+		}
+		if err := edit(&doc); err != nil {
+			return err
+		}
+		inMemoryDocuments.data[doc.ID] = doc
+		return nil
+	}
+
+	// Sends the current content and revision, which operations are then based on
+	hub.Handle("sync", func(client *goswift.HubClient, msg goswift.HubMessage) error {
+		docID, _ := client.Get("docID")
+		inMemoryDocuments.RLock()
+		defer inMemoryDocuments.RUnlock() // Sent under the lock, so no operation can slip in between
+		doc, ok := inMemoryDocuments.data[docID.(string)]
+		if !ok {
+			return goswift.NewHTTPError(http.StatusNotFound, "Document not found")
+		}
+		return client.Send(map[string]interface{}{"type": "sync", "revision": doc.Revision, "content": doc.Content})
+	})
+	// An edit made on a revision; answered with "ack", sent to the others as "op"
+	hub.Handle("op", func(client *goswift.HubClient, msg goswift.HubMessage) error {
+		var req struct {
+			Revision  int                   `json:"revision"`
+			Operation goswift.TextOperation `json:"operation"`
+		}
+		if err := msg.Bind(&req); err != nil {
+			return goswift.NewHTTPError(http.StatusBadRequest, "Invalid operation")
+		}
+		return editLiveDocument(client, func(doc *Document) error {
+			return applyEditLocked(doc, client.UserID, req.Revision, req.Operation, client, false)
+		})
+	})
+	// Marks the current content as a saved version
+	hub.Handle("save", func(client *goswift.HubClient, msg goswift.HubMessage) error {
+		return editLiveDocument(client, func(doc *Document) error {
+			recordVersion(doc, nil, goswift.Now(), true)
+			return client.Send(map[string]interface{}{"type": "saved", "revision": doc.Revision})
+		})
+	})
+	hub.Handle("cursor", func(client *goswift.HubClient, msg goswift.HubMessage) error {
+		var cursor struct {
+			Position int `json:"position"`
+		}
+		if err := msg.Bind(&cursor); err != nil || cursor.Position < 0 {
+			return goswift.NewHTTPError(http.StatusBadRequest, "Invalid cursor position")
+		}
+		docID, _ := client.Get("docID")
+		return hub.BroadcastExcept("doc:"+docID.(string), client, map[string]interface{}{
+			"type":     "cursor",
+			"user_id":  client.UserID,
+			"name":     usernameByID(client.UserID),
+			"position": cursor.Position,
+		})
+	})
+
+	// JWT signing keys, issuer, audience and lifetime come from configuration (JWT_* variables)
+	jwtConfig := app.Config
+	if app.Config.Get("JWT_SECRET") == "" {
+		app.Logger.Warning("JWT_SECRET is not set; using a random secret, tokens will not survive restarts")
+		jwtConfig = goswift.NewConfigManager() // Keeps the secret out of app.Config; the other JWT_* variables still come from the environment
+		jwtConfig.Set("JWT_SECRET", uuid.NewString()+uuid.NewString())
+	}
+	jwtService, err := goswift.NewJWTServiceFromConfig(jwtConfig)
+	if err != nil {
+		log.Fatalf("Invalid JWT configuration: %v", err)
+	}
+
+	// Password hashing algorithm (PASSWORD_HASHER etc.) and rules for new passwords (PASSWORD_*);
+	// existing hashes keep working and are upgraded at the next login
+	passwordHasher, err := goswift.PasswordHasherFromConfig(app.Config)
+	if err != nil {
+		log.Fatalf("Invalid password hashing configuration: %v", err)
+	}
+	goswift.SetDefaultPasswordHasher(passwordHasher)
+	passwordPolicy, err := goswift.PasswordPolicyFromConfig(app.Config)
+	if err != nil {
+		log.Fatalf("Invalid password policy configuration: %v", err)
+	}
+
+	// Throttles password and second-factor guessing per username and per client IP
+	loginGuard := goswift.NewLoginGuard(goswift.LoginGuardConfig{}, app.Logger)
+
+	// Stores first responses for retried requests carrying an Idempotency-Key
+	idempotencyStore := goswift.NewMemoryIdempotencyStore()
+
+	// API keys for scripts and other non-interactive clients ("qd_..." keys)
+	apiKeys, err := goswift.NewAPIKeyService(goswift.NewMemoryAPIKeyStore(), "qd")
+	if err != nil {
+		log.Fatalf("Invalid API key configuration: %v", err)
+	}
+
 	// Global Middleware
 	app.Use(goswift.RequestIDMiddleware())
 	app.Use(goswift.LoggerMiddleware())
 	app.Use(goswift.RecoveryMiddleware())
 	app.Use(goswift.MetricsMiddleware(app.MetricsMan))
 	app.Use(goswift.CORSMiddleware("*")) // Allow all origins for simplicity in development/production
+	app.Use(goswift.BodyLimit(1 << 20))  // 1 MB default; routes below raise or lower it as needed
 
 	// --- Serve Frontend Static Files ---
 	// This will serve the vanilla JS frontend from the 'static' directory.
@@ -102,6 +472,28 @@ func main() {
 	app.Logger.Info("Serving vanilla JS frontend from /")
 
 
+	// Public keys for verifying our tokens (empty while signing with an HMAC secret)
+	app.GET("/.well-known/jwks.json", jwtService.JWKSHandler).Handler()
+
+	// completeLogin issues the token pair for a fully authenticated user
+	completeLogin := func(c *goswift.Context, user User) error {
+		tokens, err := jwtService.IssueTokens(user.ID)
+		if err != nil {
+			app.Logger.Error("Failed to generate JWT for user %s: %v", user.ID, err)
+			return goswift.NewHTTPError(http.StatusInternalServerError, "Failed to generate authentication token")
+		}
+
+		loginGuard.Success(c, user.Username)
+		app.Logger.Info("User logged in: %s (ID: %s)", user.Username, user.ID)
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"token":         tokens.AccessToken,
+			"refresh_token": tokens.RefreshToken,
+			"expires_in":    tokens.ExpiresIn,
+			"user_id":       user.ID,
+			"username":      user.Username,
+		})
+	}
+
 	// --- Authentication Routes ---
 	app.POST("/api/signup", func(c *goswift.Context) error {
 		var req AuthRequest
@@ -112,6 +504,9 @@ func main() {
 		if req.Username == "" || req.Password == "" {
 			return goswift.NewHTTPError(http.StatusBadRequest, "Username and password are required")
 		}
+		if err := passwordPolicy.Validate(req.Password, req.Username); err != nil {
+			return goswift.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
 
 		inMemoryUsers.RLock()
 		_, exists := inMemoryUsers.data[req.Username]
@@ -134,7 +529,7 @@ func main() {
 
 		app.Logger.Info("User registered: %s (ID: %s)", req.Username, userID)
 		return c.JSON(http.StatusCreated, map[string]string{"message": "User registered successfully"})
-	}).Handler()
+	}).BodyLimit(4 << 10).Handler() // Credentials are tiny
 
 	app.POST("/api/login", func(c *goswift.Context) error {
 		var req AuthRequest
@@ -142,41 +537,431 @@ func main() {
 			return goswift.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
 		}
 
+		if err := loginGuard.Check(c, req.Username); err != nil {
+			return err
+		}
+
 		inMemoryUsers.RLock()
 		user, exists := inMemoryUsers.data[req.Username]
 		inMemoryUsers.RUnlock()
 
+		if !exists {
+			goswift.CheckDummyPassword(req.Password) // Take as long as for an existing user; CheckPasswordHash does the same for users without a password
+		}
 		if !exists || !goswift.CheckPasswordHash(req.Password, user.HashedPassword) {
+			loginGuard.Failure(c, req.Username)
 			return goswift.NewHTTPError(http.StatusUnauthorized, "Invalid username or password")
 		}
 
-		token, err := goswift.GenerateJWT(user.ID)
+		// Upgrade hashes made with an older algorithm or cost while we have the plaintext
+		if goswift.PasswordNeedsRehash(user.HashedPassword) {
+			if hashed, err := goswift.HashPassword(req.Password); err != nil {
+				app.Logger.Warning("Failed to rehash password for user %s: %v", user.ID, err)
+			} else {
+				inMemoryUsers.Lock()
+				if current, ok := inMemoryUsers.data[user.Username]; ok && current.HashedPassword == user.HashedPassword {
+					current.HashedPassword = hashed
+					inMemoryUsers.data[user.Username] = current
+				}
+				inMemoryUsers.Unlock()
+				app.Logger.Info("Upgraded password hash for user %s", user.ID)
+			}
+		}
+
+		// With two-factor authentication enabled, the password only earns a short-lived
+		// token for the second step (POST /api/login/mfa)
+		if user.TOTPSecret != "" {
+			loginGuard.Abandon(c, user.Username) // The second step is checked on its own
+			mfaToken, err := jwtService.GeneratePurposeToken(user.ID, goswift.MFAPendingPurpose, goswift.MFAPendingTTL)
+			if err != nil {
+				app.Logger.Error("Failed to generate MFA token for user %s: %v", user.ID, err)
+				return goswift.NewHTTPError(http.StatusInternalServerError, "Failed to generate authentication token")
+			}
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"mfa_required": true,
+				"mfa_token":    mfaToken,
+				"expires_in":   int(goswift.MFAPendingTTL.Seconds()),
+			})
+		}
+
+		return completeLogin(c, user)
+	}).BodyLimit(4 << 10).Handler() // Credentials are tiny
+
+	// Second login step: a TOTP code or an unused recovery code
+	app.POST("/api/login/mfa", func(c *goswift.Context) error {
+		var req MFALoginRequest
+		if err := c.BindJSON(&req); err != nil {
+			return goswift.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+		}
+
+		claims, err := jwtService.ValidatePurpose(req.MFAToken, goswift.MFAPendingPurpose)
 		if err != nil {
-			app.Logger.Error("Failed to generate JWT for user %s: %v", user.ID, err)
-			return goswift.NewHTTPError(http.StatusInternalServerError, "Failed to generate authentication token")
+			return goswift.NewHTTPError(http.StatusUnauthorized, "Login expired, please sign in again")
+		}
+		if username := usernameByID(claims.UserID); username != "" {
+			if err := loginGuard.Check(c, username); err != nil {
+				return err
+			}
+		}
+
+		inMemoryUsers.Lock()
+		user, ok := userByIDLocked(claims.UserID)
+		if !ok || user.TOTPSecret == "" {
+			inMemoryUsers.Unlock()
+			return goswift.NewHTTPError(http.StatusUnauthorized, "Login expired, please sign in again")
+		}
+		verified := false
+		if req.RecoveryCode == "" {
+			if totp, err := goswift.NewTOTP(user.TOTPSecret); err == nil {
+				if counter, valid := totp.Verify(req.Code, time.Now(), user.TOTPLastCounter); valid {
+					user.TOTPLastCounter = counter
+					inMemoryUsers.data[user.Username] = user
+					verified = true
+				}
+			}
+		}
+		inMemoryUsers.Unlock()
+
+		if req.RecoveryCode != "" {
+			// Checking runs a slow password hash per code, so it happens outside the lock; the
+			// slice is never changed in place, only replaced
+			if hash, matched := goswift.MatchRecoveryCode(req.RecoveryCode, user.RecoveryCodes); matched {
+				inMemoryUsers.Lock()
+				if current, ok := userByIDLocked(claims.UserID); ok {
+					if remaining, unused := goswift.RemoveRecoveryCode(current.RecoveryCodes, hash); unused { // A concurrent login may have used it
+						current.RecoveryCodes = remaining
+						inMemoryUsers.data[current.Username] = current
+						user, verified = current, true
+					}
+				}
+				inMemoryUsers.Unlock()
+			}
 		}
 
-		app.Logger.Info("User logged in: %s (ID: %s)", req.Username, user.ID)
-		return c.JSON(http.StatusOK, map[string]string{"token": token, "user_id": user.ID, "username": user.Username})
+		if !verified {
+			loginGuard.Failure(c, user.Username)
+			mfaFailures.Lock()
+			mfaFailures.data[claims.ID]++
+			tooMany := mfaFailures.data[claims.ID] >= maxMFAFailures
+			if tooMany {
+				delete(mfaFailures.data, claims.ID)
+			}
+			mfaFailures.Unlock()
+			if tooMany {
+				jwtService.RevokeClaims(claims) // Force a new password login
+				app.Logger.Warning("Too many invalid MFA codes for user %s; login attempt revoked", user.ID)
+			}
+			return goswift.NewHTTPError(http.StatusUnauthorized, "Invalid authentication code")
+		}
+
+		// The pending token is single-use
+		mfaFailures.Lock()
+		delete(mfaFailures.data, claims.ID)
+		mfaFailures.Unlock()
+		if err := jwtService.RevokeClaims(claims); err != nil {
+			return goswift.NewHTTPError(http.StatusInternalServerError, "Failed to complete login", err)
+		}
+		if req.RecoveryCode != "" {
+			app.Logger.Warning("User %s logged in with a recovery code (%d left)", user.ID, len(user.RecoveryCodes))
+		}
+		return completeLogin(c, user)
+	}).BodyLimit(4 << 10).Handler()
+
+	// Exchange a refresh token for a new access/refresh token pair
+	app.POST("/api/token/refresh", func(c *goswift.Context) error {
+		var req RefreshRequest
+		if err := c.BindJSON(&req); err != nil || req.RefreshToken == "" {
+			return goswift.NewHTTPError(http.StatusBadRequest, "refresh_token is required")
+		}
+
+		tokens, err := jwtService.Refresh(req.RefreshToken)
+		if errors.Is(err, goswift.ErrRefreshTokenReused) {
+			app.Logger.Warning("Refresh token reuse detected from %s; token family revoked", c.RealIP())
+			return goswift.NewHTTPError(http.StatusUnauthorized, "Invalid or expired refresh token")
+		}
+		if errors.Is(err, goswift.ErrRefreshTokenInvalid) {
+			return goswift.NewHTTPError(http.StatusUnauthorized, "Invalid or expired refresh token")
+		}
+		if err != nil {
+			return goswift.NewHTTPError(http.StatusInternalServerError, "Failed to refresh token", err)
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"token":         tokens.AccessToken,
+			"refresh_token": tokens.RefreshToken,
+			"expires_in":    tokens.ExpiresIn,
+		})
+	}).BodyLimit(4 << 10).Handler()
+
+	// --- Single Sign-On (OpenID Connect), enabled when OIDC_ISSUER is set ---
+	providers := []string{}
+	if app.Config.Get("OIDC_ISSUER") != "" {
+		oidc, err := goswift.NewOIDCProvider(goswift.OIDCConfigFromConfig(app.Config))
+		if err != nil {
+			log.Fatalf("Invalid OIDC configuration: %v", err)
+		}
+		providers = append(providers, oidc.Name())
+
+		// linkIdentity returns the user linked to the external identity, creating one on first login
+		linkIdentity := func(c *goswift.Context, identity *goswift.OIDCIdentity) (string, error) {
+			key := identity.Issuer + " " + identity.Subject
+			inMemoryIdentities.RLock()
+			userID, linked := inMemoryIdentities.data[key]
+			inMemoryIdentities.RUnlock()
+			if linked {
+				return userID, nil
+			}
+
+			base := identity.Username
+			if base == "" && identity.EmailVerified {
+				base = identity.Email
+			}
+			if base == "" {
+				base = identity.Provider + "-" + identity.Subject
+			}
+
+			userID = uuid.New().String()
+			inMemoryUsers.Lock()
+			username := base
+			for i := 2; ; i++ { // Don't take over an existing local account with the same name
+				if _, taken := inMemoryUsers.data[username]; !taken {
+					break
+				}
+				username = fmt.Sprintf("%s-%d", base, i)
+			}
+			// No password: the account can only sign in through the provider
+			inMemoryUsers.data[username] = User{ID: userID, Username: username}
+			inMemoryUsers.Unlock()
+
+			inMemoryIdentities.Lock()
+			inMemoryIdentities.data[key] = userID
+			inMemoryIdentities.Unlock()
+
+			app.Logger.Info("User registered via %s: %s (ID: %s)", identity.Provider, username, userID)
+			return userID, nil
+		}
+
+		app.GET("/api/auth/oidc/login", oidc.LoginHandler).Handler()
+		app.GET("/api/auth/oidc/callback", oidc.JWTCallback(linkIdentity, jwtService)).Handler()
+	}
+
+	// Lets the frontend offer single sign-on buttons
+	app.GET("/api/auth/providers", func(c *goswift.Context) error {
+		return c.JSON(http.StatusOK, map[string][]string{"providers": providers})
 	}).Handler()
 
 	// --- Protected API Routes (Document CRUD) ---
 	apiGroup := app.Group("/api")
-	apiGroup.Use(goswift.JWTAuthMiddleware()) // Apply JWT authentication to all API routes
+	// Apply authentication to all API routes: a JWT for the web app, or an API key for scripts
+	apiGroup.Use(goswift.AnyAuth(goswift.JWTAuthMiddleware(jwtService), goswift.APIKeyAuth(apiKeys)))
+	// API keys are limited to their scopes: docs:read for reads, docs:write for everything else
+	apiGroup.Authorize(func(c *goswift.Context) (bool, error) {
+		if method, _ := c.Get("authMethod"); method != "apikey" {
+			return true, nil
+		}
+		subject, err := c.Subject()
+		if err != nil {
+			return false, err
+		}
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			return subject.HasScope(apiKeyScopeRead), nil
+		}
+		return subject.HasScope(apiKeyScopeWrite), nil
+	})
+
+	// interactiveOnly keeps API keys from managing credentials, so a leaked key cannot mint more
+	interactiveOnly := func(c *goswift.Context) (bool, error) {
+		method, _ := c.Get("authMethod")
+		return method == "jwt", nil
+	}
+
+	// Current user, e.g. after a single sign-on login that only returned tokens
+	apiGroup.GET("/me", func(c *goswift.Context) error {
+		currentUserID, err := c.UserID()
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, map[string]string{"user_id": currentUserID, "username": usernameByID(currentUserID)})
+	}).Handler()
+
+	// --- Two-Factor Authentication (TOTP) ---
+	// Start enrollment: returns a new secret to add to an authenticator app
+	apiGroup.POST("/mfa/totp/enroll", func(c *goswift.Context) error {
+		currentUserID, err := c.UserID()
+		if err != nil {
+			return err
+		}
+		secret, err := goswift.GenerateTOTPSecret()
+		if err != nil {
+			return goswift.NewHTTPError(http.StatusInternalServerError, "Failed to start enrollment", err)
+		}
+		totp, err := goswift.NewTOTP(secret)
+		if err != nil {
+			return goswift.NewHTTPError(http.StatusInternalServerError, "Failed to start enrollment", err)
+		}
+
+		inMemoryUsers.Lock()
+		user, ok := userByIDLocked(currentUserID)
+		if !ok {
+			inMemoryUsers.Unlock()
+			return goswift.NewHTTPError(http.StatusNotFound, "User not found")
+		}
+		if user.TOTPSecret != "" {
+			inMemoryUsers.Unlock()
+			return goswift.NewHTTPError(http.StatusConflict, "Two-factor authentication is already enabled")
+		}
+		user.PendingTOTP = secret
+		inMemoryUsers.data[user.Username] = user
+		inMemoryUsers.Unlock()
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"secret":      secret,
+			"otpauth_uri": totp.URI("QuikDocs", user.Username),
+		})
+	}).Authorize(interactiveOnly).Handler()
+
+	// Finish enrollment with a code from the app; returns the recovery codes, shown only once
+	apiGroup.POST("/mfa/totp/confirm", func(c *goswift.Context) error {
+		currentUserID, err := c.UserID()
+		if err != nil {
+			return err
+		}
+		var req TOTPCodeRequest
+		if err := c.BindJSON(&req); err != nil {
+			return goswift.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+		}
+		codes, hashes, err := goswift.GenerateRecoveryCodes(10)
+		if err != nil {
+			return goswift.NewHTTPError(http.StatusInternalServerError, "Failed to generate recovery codes", err)
+		}
+
+		inMemoryUsers.Lock()
+		user, ok := userByIDLocked(currentUserID)
+		if !ok || user.PendingTOTP == "" {
+			inMemoryUsers.Unlock()
+			return goswift.NewHTTPError(http.StatusBadRequest, "No enrollment in progress")
+		}
+		totp, err := goswift.NewTOTP(user.PendingTOTP)
+		if err != nil {
+			inMemoryUsers.Unlock()
+			return goswift.NewHTTPError(http.StatusInternalServerError, "Failed to confirm enrollment", err)
+		}
+		counter, valid := totp.Verify(req.Code, time.Now(), -1)
+		if !valid {
+			inMemoryUsers.Unlock()
+			return goswift.NewHTTPError(http.StatusBadRequest, "Invalid authentication code")
+		}
+		user.TOTPSecret = user.PendingTOTP
+		user.PendingTOTP = ""
+		user.TOTPLastCounter = counter
+		user.RecoveryCodes = hashes
+		inMemoryUsers.data[user.Username] = user
+		inMemoryUsers.Unlock()
+
+		app.Logger.Info("User %s enabled two-factor authentication", currentUserID)
+		return c.JSON(http.StatusOK, map[string][]string{"recovery_codes": codes})
+	}).Authorize(interactiveOnly).BodyLimit(4 << 10).Handler()
+
+	// --- API Keys ---
+	// Create a key; the key itself is only returned in this response
+	apiGroup.POST("/keys", func(c *goswift.Context) error {
+		currentUserID, err := c.UserID()
+		if err != nil {
+			return err
+		}
+		var req APIKeyCreateRequest
+		if err := c.BindJSON(&req); err != nil {
+			return goswift.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+		}
+		if req.Name == "" {
+			return goswift.NewHTTPError(http.StatusBadRequest, "Name is required")
+		}
+		if len(req.Scopes) == 0 {
+			req.Scopes = []string{apiKeyScopeRead}
+		}
+		for _, scope := range req.Scopes {
+			if scope != apiKeyScopeRead && scope != apiKeyScopeWrite {
+				return goswift.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Unknown scope '%s'", scope))
+			}
+		}
+		if req.ExpiresInDays < 0 {
+			return goswift.NewHTTPError(http.StatusBadRequest, "expires_in_days must not be negative")
+		}
+
+		plaintext, key, err := apiKeys.Create(currentUserID, req.Name, req.Scopes, time.Duration(req.ExpiresInDays)*24*time.Hour)
+		if err != nil {
+			return goswift.NewHTTPError(http.StatusInternalServerError, "Failed to create API key", err)
+		}
+		app.Logger.Info("User %s created API key %s (%s) with scopes %v", currentUserID, key.ID, key.Name, key.Scopes)
+		return c.JSON(http.StatusCreated, map[string]interface{}{"key": plaintext, "api_key": key})
+	}).Authorize(interactiveOnly).BodyLimit(4 << 10).Handler()
+
+	// List the caller's keys (without the secrets)
+	apiGroup.GET("/keys", func(c *goswift.Context) error {
+		currentUserID, err := c.UserID()
+		if err != nil {
+			return err
+		}
+		keys, err := apiKeys.List(currentUserID)
+		if err != nil {
+			return goswift.NewHTTPError(http.StatusInternalServerError, "Failed to list API keys", err)
+		}
+		return c.JSON(http.StatusOK, keys)
+	}).Authorize(interactiveOnly).Handler()
+
+	// Revoke one of the caller's keys
+	apiGroup.DELETE("/keys/:keyID", func(c *goswift.Context) error {
+		currentUserID, err := c.UserID()
+		if err != nil {
+			return err
+		}
+		found, err := apiKeys.Revoke(currentUserID, c.Param("keyID"))
+		if err != nil {
+			return goswift.NewHTTPError(http.StatusInternalServerError, "Failed to revoke API key", err)
+		}
+		if !found {
+			return goswift.NewHTTPError(http.StatusNotFound, "API key not found")
+		}
+		app.Logger.Info("User %s revoked API key %s", currentUserID, c.Param("keyID"))
+		return c.NoContent(http.StatusNoContent)
+	}).Authorize(interactiveOnly).Handler()
+
+	// Logout: revoke the current access token and, if given, the refresh token family
+	apiGroup.POST("/logout", func(c *goswift.Context) error {
+		var req RefreshRequest
+		c.BindJSON(&req) // The body is optional
+
+		claims, err := c.Claims()
+		if err != nil {
+			return err
+		}
+		if err := jwtService.RevokeTokenID(claims.ID); err != nil {
+			return goswift.NewHTTPError(http.StatusInternalServerError, "Failed to log out", err)
+		}
+		if req.RefreshToken != "" {
+			if err := jwtService.RevokeRefreshToken(req.RefreshToken); err != nil {
+				return goswift.NewHTTPError(http.StatusInternalServerError, "Failed to log out", err)
+			}
+		}
+		return c.NoContent(http.StatusNoContent)
+	}).BodyLimit(4 << 10).Handler()
 
 	// List user documents
 	apiGroup.GET("/docs", func(c *goswift.Context) error {
-		userID, _ := c.Get("userID") // JWTAuthMiddleware guarantees userID is present
-		currentUserID := userID.(string)
+		currentUserID, err := c.UserID()
+		if err != nil {
+			return err
+		}
 
-		userDocs := []Document{}
+		userDocs := []DocumentListItem{}
 		inMemoryDocuments.RLock()
 		for _, doc := range inMemoryDocuments.data {
-			if doc.OwnerID == currentUserID {
-				// Exclude versions and shareID from list view for brevity
+			// Owned documents and documents shared with the user
+			if role := doc.RoleOf(currentUserID); role != "" {
+				// Exclude versions, shareID and collaborators from list view for brevity
 				doc.Versions = nil
 				doc.ShareID = ""
-				userDocs = append(userDocs, doc)
+				doc.Collaborators = nil
+				userDocs = append(userDocs, DocumentListItem{Document: doc, Role: role})
 			}
 		}
 		inMemoryDocuments.RUnlock()
@@ -186,8 +971,10 @@ func main() {
 
 	// Create document
 	apiGroup.POST("/docs", func(c *goswift.Context) error {
-		userID, _ := c.Get("userID")
-		currentUserID := userID.(string)
+		currentUserID, err := c.UserID()
+		if err != nil {
+			return err
+		}
 
 		var req DocumentCreateRequest
 		if err := c.BindJSON(&req); err != nil {
@@ -206,7 +993,7 @@ func main() {
 			OwnerID:   currentUserID,
 			CreatedAt: now,
 			UpdatedAt: now,
-			Versions:  []DocumentVersion{{Timestamp: now, Content: req.Content}},
+			Versions:  []DocumentVersion{{Timestamp: now, Content: req.Content, Saved: true}},
 		}
 
 		inMemoryDocuments.Lock()
@@ -215,13 +1002,12 @@ func main() {
 
 		app.Logger.Info("User %s created document: %s (ID: %s)", currentUserID, newDoc.Title, newDoc.ID)
 		return c.JSON(http.StatusCreated, newDoc)
-	}).Handler()
+	}).Before(goswift.Idempotency(idempotencyStore, 24*time.Hour)). // Retried creates return the original document
+		BodyLimit(5 << 20).Handler() // Documents may be large
 
 	// Read document
 	apiGroup.GET("/docs/:id", func(c *goswift.Context) error {
 		docID := c.Param("id")
-		userID, _ := c.Get("userID")
-		currentUserID := userID.(string)
 
 		inMemoryDocuments.RLock()
 		doc, ok := inMemoryDocuments.data[docID]
@@ -230,18 +1016,17 @@ func main() {
 		if !ok {
 			return goswift.NewHTTPError(http.StatusNotFound, "Document not found")
 		}
-		if doc.OwnerID != currentUserID {
-			return goswift.NewHTTPError(http.StatusForbidden, "Access denied")
-		}
 
 		return c.JSON(http.StatusOK, doc)
-	}).Handler()
+	}).Authorize(docRole(RoleViewer)).Handler()
 
 	// Update document
 	apiGroup.PUT("/docs/:id", func(c *goswift.Context) error {
 		docID := c.Param("id")
-		userID, _ := c.Get("userID")
-		currentUserID := userID.(string)
+		currentUserID, err := c.UserID()
+		if err != nil {
+			return err
+		}
 
 		var req DocumentUpdateRequest
 		if err := c.BindJSON(&req); err != nil {
@@ -254,30 +1039,28 @@ func main() {
 			inMemoryDocuments.Unlock()
 			return goswift.NewHTTPError(http.StatusNotFound, "Document not found")
 		}
-		if doc.OwnerID != currentUserID {
+
+		// Replace the content as a single edit, so live editors keep their changes and place,
+		// and save it as a new version
+		if err := applyEditLocked(&doc, currentUserID, doc.Revision, goswift.DiffText(doc.Content, req.Content), nil, true); err != nil {
 			inMemoryDocuments.Unlock()
-			return goswift.NewHTTPError(http.StatusForbidden, "Access denied")
+			return err
 		}
-
-		// Update content and add a new version
-		doc.Content = req.Content
-		doc.UpdatedAt = goswift.Now()
-		doc.Versions = append(doc.Versions, DocumentVersion{Timestamp: doc.UpdatedAt, Content: req.Content})
 		inMemoryDocuments.data[doc.ID] = doc // Update the map entry
 		inMemoryDocuments.Unlock()
 
-		// Broadcast update to all subscribed clients for this document
-		sseManager.Broadcast(docID, req.Content)
 		app.Logger.Info("User %s updated document %s (ID: %s). Broadcasted update.", currentUserID, doc.Title, doc.ID)
 
 		return c.JSON(http.StatusOK, doc)
-	}).Handler()
+	}).Authorize(docRole(RoleEditor)).BodyLimit(5 << 20).Handler()
 
 	// Delete document
 	apiGroup.DELETE("/docs/:id", func(c *goswift.Context) error {
 		docID := c.Param("id")
-		userID, _ := c.Get("userID")
-		currentUserID := userID.(string)
+		currentUserID, err := c.UserID()
+		if err != nil {
+			return err
+		}
 
 		inMemoryDocuments.Lock()
 		doc, ok := inMemoryDocuments.data[docID]
@@ -285,27 +1068,24 @@ func main() {
 			inMemoryDocuments.Unlock()
 			return goswift.NewHTTPError(http.StatusNotFound, "Document not found")
 		}
-		if doc.OwnerID != currentUserID {
-			inMemoryDocuments.Unlock()
-			return goswift.NewHTTPError(http.StatusForbidden, "Access denied")
-		}
 
 		delete(inMemoryDocuments.data, docID)
+		delete(inMemoryDocuments.live, docID)
 		// Also remove from shareID map if it exists
 		if doc.ShareID != "" {
 			delete(inMemoryDocuments.shareIDToDocID, doc.ShareID)
 		}
 		inMemoryDocuments.Unlock()
+		sseManager.CloseTopic(docID)
+		hub.CloseRoom("doc:"+docID, goswift.WebSocketCloseNormal, "document deleted")
 
 		app.Logger.Info("User %s deleted document: %s (ID: %s)", currentUserID, doc.Title, doc.ID)
 		return c.NoContent(http.StatusNoContent)
-	}).Handler()
+	}).Authorize(docRole(RoleOwner)).Handler()
 
 	// Get document version history
 	apiGroup.GET("/docs/:id/history", func(c *goswift.Context) error {
 		docID := c.Param("id")
-		userID, _ := c.Get("userID")
-		currentUserID := userID.(string)
 
 		inMemoryDocuments.RLock()
 		doc, ok := inMemoryDocuments.data[docID]
@@ -314,42 +1094,97 @@ func main() {
 		if !ok {
 			return goswift.NewHTTPError(http.StatusNotFound, "Document not found")
 		}
-		if doc.OwnerID != currentUserID {
-			return goswift.NewHTTPError(http.StatusForbidden, "Access denied")
-		}
 
 		return c.JSON(http.StatusOK, doc.Versions)
-	}).Handler()
+	}).Authorize(docRole(RoleViewer)).Handler()
 
 	// --- Real-time Sync (SSE) ---
-	apiGroup.GET("/docs/:id/subscribe", func(c *goswift.Context) error {
+	// Registered outside apiGroup: EventSource cannot send headers, so this route also accepts
+	// the token as ?access_token=.
+	app.GET("/api/docs/:id/subscribe", func(c *goswift.Context) error {
+		// Blocks until the client disconnects or loses access to the document
+		userID, _ := c.UserID()
+		leave := presence.Join(c.Param("id"), userID, usernameByID(userID))
+		defer leave()
+		return sseManager.Serve(c, c.Param("id"))
+	}).Before(goswift.JWTAuthMiddleware(jwtService, "header:Authorization", "query:access_token")).
+		Authorize(docRole(RoleViewer)).Handler()
+
+	// WebSocket for live editing, also accepting the token as ?access_token= (browsers cannot set
+	// headers on WebSockets). The Origin must match the host unless listed in app.WebSocket.
+	app.GET("/api/docs/:id/ws", func(c *goswift.Context) error {
+		c.Set("docID", c.Param("id"))
+		return hub.Serve(c, "doc:"+c.Param("id")) // Blocks until the client disconnects
+	}).Before(goswift.JWTAuthMiddleware(jwtService, "header:Authorization", "query:access_token")).
+		Authorize(docRole(RoleViewer)).Handler()
+
+	// Users currently viewing the document, across all instances
+	apiGroup.GET("/docs/:id/presence", func(c *goswift.Context) error {
+		return c.JSON(http.StatusOK, presence.List(c.Param("id")))
+	}).Authorize(docRole(RoleViewer)).Handler()
+
+	// --- Shareable Public Link ---
+	apiGroup.POST("/docs/:id/share", func(c *goswift.Context) error {
 		docID := c.Param("id")
-		userID, _ := c.Get("userID")
-		currentUserID := userID.(string)
 
-		inMemoryDocuments.RLock()
+		inMemoryDocuments.Lock()
 		doc, ok := inMemoryDocuments.data[docID]
-		inMemoryDocuments.RUnlock()
-
 		if !ok {
+			inMemoryDocuments.Unlock()
 			return goswift.NewHTTPError(http.StatusNotFound, "Document not found")
 		}
-		if doc.OwnerID != currentUserID {
-			return goswift.NewHTTPError(http.StatusForbidden, "Access denied")
+
+		if doc.ShareID == "" {
+			shareID := uuid.New().String() // Generate a new shareable ID
+			doc.ShareID = shareID
+			inMemoryDocuments.data[doc.ID] = doc // Update the document in map
+			inMemoryDocuments.shareIDToDocID[shareID] = docID // Map share ID to doc ID
 		}
+		inMemoryDocuments.Unlock()
 
-		// Add the client to the SSE manager
-		sseManager.AddClient(docID, currentUserID, c)
+		shareLink := fmt.Sprintf("/share/%s", doc.ShareID)
+		return c.JSON(http.StatusOK, map[string]string{"share_link": shareLink})
+	}).Authorize(docRole(RoleOwner)).Handler()
 
-		// The SSEManager takes over the response writing, so we return nil.
-		return nil
-	}).Handler()
+	// --- Collaborators ---
+	// List the owner and everyone the document is shared with
+	apiGroup.GET("/docs/:id/collaborators", func(c *goswift.Context) error {
+		inMemoryDocuments.RLock()
+		doc, ok := inMemoryDocuments.data[c.Param("id")]
+		inMemoryDocuments.RUnlock()
+		if !ok {
+			return goswift.NewHTTPError(http.StatusNotFound, "Document not found")
+		}
 
-	// --- Shareable Public Link ---
-	apiGroup.POST("/docs/:id/share", func(c *goswift.Context) error {
+		collaborators := []Collaborator{{UserID: doc.OwnerID, Username: usernameByID(doc.OwnerID), Role: RoleOwner}}
+		for userID, role := range doc.Collaborators {
+			collaborators = append(collaborators, Collaborator{UserID: userID, Username: usernameByID(userID), Role: role})
+		}
+		return c.JSON(http.StatusOK, collaborators)
+	}).Authorize(docRole(RoleViewer)).Handler()
+
+	// Invite a user by username, or change the role of an existing collaborator
+	apiGroup.POST("/docs/:id/collaborators", func(c *goswift.Context) error {
 		docID := c.Param("id")
-		userID, _ := c.Get("userID")
-		currentUserID := userID.(string)
+		currentUserID, err := c.UserID()
+		if err != nil {
+			return err
+		}
+
+		var req CollaboratorRequest
+		if err := c.BindJSON(&req); err != nil {
+			return goswift.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+		}
+		if req.Role != RoleViewer && req.Role != RoleCommenter && req.Role != RoleEditor {
+			return goswift.NewHTTPError(http.StatusBadRequest, "Role must be viewer, commenter or editor")
+		}
+
+		inMemoryUsers.RLock()
+		user, exists := inMemoryUsers.data[req.Username]
+		inMemoryUsers.RUnlock()
+		if !exists {
+			return goswift.NewHTTPError(http.StatusNotFound, "User not found")
+		}
 
 		inMemoryDocuments.Lock()
 		doc, ok := inMemoryDocuments.data[docID]
@@ -357,21 +1192,50 @@ func main() {
 			inMemoryDocuments.Unlock()
 			return goswift.NewHTTPError(http.StatusNotFound, "Document not found")
 		}
-		if doc.OwnerID != currentUserID {
+		if user.ID == doc.OwnerID {
 			inMemoryDocuments.Unlock()
-			return goswift.NewHTTPError(http.StatusForbidden, "Access denied")
+			return goswift.NewHTTPError(http.StatusBadRequest, "The owner already has full access")
 		}
+		doc.Collaborators = doc.withCollaborator(user.ID, req.Role)
+		inMemoryDocuments.data[docID] = doc
+		inMemoryDocuments.Unlock()
 
-		if doc.ShareID == "" {
-			shareID := uuid.New().String() // Generate a new shareable ID
-			doc.ShareID = shareID
-			inMemoryDocuments.data[doc.ID] = doc // Update the document in map
-			inMemoryDocuments.shareIDToDocID[shareID] = docID // Map share ID to doc ID
+		app.Logger.Info("User %s granted %s access on document %s to user %s", currentUserID, req.Role, docID, user.ID)
+		return c.JSON(http.StatusOK, Collaborator{UserID: user.ID, Username: user.Username, Role: req.Role})
+	}).Authorize(docRole(RoleOwner)).BodyLimit(4 << 10).Handler()
+
+	// Revoke a collaborator's access. Collaborators may also remove themselves.
+	apiGroup.DELETE("/docs/:id/collaborators/:userID", func(c *goswift.Context) error {
+		docID := c.Param("id")
+		targetUserID := c.Param("userID")
+		currentUserID, err := c.UserID()
+		if err != nil {
+			return err
+		}
+
+		inMemoryDocuments.Lock()
+		doc, ok := inMemoryDocuments.data[docID]
+		if !ok {
+			inMemoryDocuments.Unlock()
+			return goswift.NewHTTPError(http.StatusNotFound, "Document not found")
 		}
+		if _, isCollaborator := doc.Collaborators[targetUserID]; !isCollaborator {
+			inMemoryDocuments.Unlock()
+			return goswift.NewHTTPError(http.StatusNotFound, "Collaborator not found")
+		}
+		doc.Collaborators = doc.withCollaborator(targetUserID, "")
+		inMemoryDocuments.data[docID] = doc
 		inMemoryDocuments.Unlock()
+		sseManager.DisconnectUser(docID, targetUserID) // Stop live updates the user may no longer see
+		hub.DisconnectUser("doc:"+docID, targetUserID, goswift.WebSocketClosePolicyViolation, "access revoked")
 
-		shareLink := fmt.Sprintf("/share/%s", doc.ShareID)
-		return c.JSON(http.StatusOK, map[string]string{"share_link": shareLink})
+		app.Logger.Info("User %s revoked access on document %s for user %s", currentUserID, docID, targetUserID)
+		return c.NoContent(http.StatusNoContent)
+	}).Authorize(func(c *goswift.Context) (bool, error) {
+		if currentUserID, err := c.UserID(); err == nil && currentUserID == c.Param("userID") {
+			return true, nil
+		}
+		return docRole(RoleOwner)(c)
 	}).Handler()
 
 	// Public view for shared documents (no auth required)
@@ -423,6 +1287,20 @@ func main() {
 	}).Handler()
 
 
+	// --- Debug Routes (internal networks only) ---
+	// DEBUG_ALLOW_CIDRS / DEBUG_DENY_CIDRS may be overridden via environment and are re-read on change.
+	app.Config.Set("DEBUG_ALLOW_CIDRS", "127.0.0.1/32,::1/128")
+	debugGroup := app.Group("/debug")
+	debugGroup.Use(goswift.IPFilterFromConfig(app.Config, "DEBUG_ALLOW_CIDRS", "DEBUG_DENY_CIDRS"))
+	debugGroup.GET("/routes", goswift.DebugRoutesHandler).Handler()
+	debugGroup.GET("/config", goswift.DebugConfigHandler).Handler()
+	debugGroup.GET("/memory", goswift.DebugMemoryHandler).Handler()
+	debugGroup.GET("/goroutines", goswift.DebugGoroutinesHandler).Handler()
+	debugGroup.GET("/pprof/:profile", goswift.DebugPprofHandler).Handler()
+	debugGroup.GET("/sse", sseManager.StatsHandler).Handler()
+	debugGroup.GET("/hub", hub.StatsHandler).Handler()
+
+
 	// --- Start the server ---
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -0,0 +1,121 @@
+// quikdocs/backend/main_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-swift/goswift"
+)
+
+// addTestDocument stores doc for the duration of the test.
+func addTestDocument(t *testing.T, doc Document) {
+	t.Helper()
+	inMemoryDocuments.Lock()
+	inMemoryDocuments.data[doc.ID] = doc
+	inMemoryDocuments.Unlock()
+	t.Cleanup(func() {
+		inMemoryDocuments.Lock()
+		delete(inMemoryDocuments.data, doc.ID)
+		inMemoryDocuments.Unlock()
+	})
+}
+
+// docRoleStatus sends GET path to a route guarded by docRole(min), authenticated as userID
+// (unauthenticated if empty), and returns the status.
+func docRoleStatus(min DocRole, path, userID string) int {
+	app := goswift.New()
+	app.Use(func(next goswift.HandlerFunc) goswift.HandlerFunc {
+		return func(c *goswift.Context) error {
+			if userID != "" {
+				c.Set("userID", userID)
+			}
+			return next(c)
+		}
+	})
+	app.GET("/docs/:id", func(c *goswift.Context) error {
+		return c.NoContent(http.StatusOK)
+	}).Authorize(docRole(min)).Handler()
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code
+}
+
+// Each role includes the rights of the ones below it; strangers and unknown roles get nothing.
+func TestDocRoleMatrix(t *testing.T) {
+	addTestDocument(t, Document{
+		ID:      "doc-acl",
+		OwnerID: "owner",
+		Collaborators: map[string]DocRole{
+			"editor":    RoleEditor,
+			"commenter": RoleCommenter,
+			"viewer":    RoleViewer,
+			"legacy":    DocRole("admin"), // Not a role this version knows
+		},
+	})
+
+	mins := []DocRole{RoleViewer, RoleCommenter, RoleEditor, RoleOwner}
+	allowed := map[string][]bool{ // Indexed like mins
+		"owner":     {true, true, true, true},
+		"editor":    {true, true, true, false},
+		"commenter": {true, true, false, false},
+		"viewer":    {true, false, false, false},
+		"stranger":  {false, false, false, false},
+		"legacy":    {false, false, false, false},
+	}
+	for userID, want := range allowed {
+		for i, min := range mins {
+			wantStatus := http.StatusForbidden
+			if want[i] {
+				wantStatus = http.StatusOK
+			}
+			if got := docRoleStatus(min, "/docs/doc-acl", userID); got != wantStatus {
+				t.Errorf("%s on a route requiring %s: got %d, want %d", userID, min, got, wantStatus)
+			}
+		}
+	}
+
+	if got := docRoleStatus(RoleViewer, "/docs/missing", "owner"); got != http.StatusNotFound {
+		t.Errorf("missing document: got %d, want 404", got)
+	}
+	if got := docRoleStatus(RoleViewer, "/docs/doc-acl", ""); got != http.StatusUnauthorized {
+		t.Errorf("unauthenticated: got %d, want 401", got)
+	}
+}
+
+func TestDocumentRoleOf(t *testing.T) {
+	doc := Document{OwnerID: "owner", Collaborators: map[string]DocRole{"owner": RoleViewer, "editor": RoleEditor}}
+	tests := map[string]DocRole{
+		"owner":    RoleOwner, // Ownership wins over a stale collaborator entry
+		"editor":   RoleEditor,
+		"stranger": "",
+	}
+	for userID, want := range tests {
+		if got := doc.RoleOf(userID); got != want {
+			t.Errorf("RoleOf(%q) = %q, want %q", userID, got, want)
+		}
+	}
+}
+
+// withCollaborator leaves the document's own map untouched, since copies of the document may
+// be read concurrently.
+func TestDocumentWithCollaborator(t *testing.T) {
+	doc := Document{OwnerID: "owner", Collaborators: map[string]DocRole{"viewer": RoleViewer}}
+
+	granted := doc.withCollaborator("editor", RoleEditor)
+	if granted["editor"] != RoleEditor || granted["viewer"] != RoleViewer {
+		t.Fatalf("after granting: %v", granted)
+	}
+	changed := doc.withCollaborator("viewer", RoleCommenter)
+	if changed["viewer"] != RoleCommenter {
+		t.Fatalf("after changing a role: %v", changed)
+	}
+	removed := doc.withCollaborator("viewer", "")
+	if _, ok := removed["viewer"]; ok {
+		t.Fatalf("after removing: %v", removed)
+	}
+	if len(doc.Collaborators) != 1 || doc.Collaborators["viewer"] != RoleViewer {
+		t.Fatalf("original collaborators changed: %v", doc.Collaborators)
+	}
+}